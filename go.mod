@@ -4,19 +4,21 @@ go 1.19
 
 require (
 	github.com/btcsuite/btcd v0.23.4
+	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.2
 	github.com/gizak/termui/v3 v3.1.0
 	github.com/miekg/dns v1.1.50
+	github.com/oschwald/maxminddb-golang v1.12.0
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/net v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/btcsuite/btcd/chaincfg/chainhash v1.0.2 // indirect
 	github.com/mattn/go-runewidth v0.0.2 // indirect
 	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
 	github.com/nsf/termbox-go v0.0.0-20190121233118-02980233997d // indirect
 	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/mod v0.6.0-dev // indirect
-	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/tools v0.1.9 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
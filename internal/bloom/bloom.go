@@ -0,0 +1,90 @@
+// bloom is a fixed size set of strings for the huge crawls,
+// an address is never reported unknown after it was added,
+// a new one is reported known at about the false positive rate it was sized for
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+	n    int
+}
+
+// New sizes the filter for the expected items at the false positive rate,
+// memory is about 1.44*log2(1/rate) bits per item, 1.8MB per million at 0.001
+func New(items int, rate float64) *Filter {
+	if items < 1 {
+		items = 1
+	}
+	m := uint64(math.Ceil(-float64(items) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(items) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add returns false if s was probably added before
+func (f *Filter) Add(s string) bool {
+	h1, h2 := hash(s)
+	added := false
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			f.bits[word] |= mask
+			added = true
+		}
+	}
+	if added {
+		f.n++
+	}
+	return added
+}
+
+// Has returns true if s was probably added
+func (f *Filter) Has(s string) bool {
+	h1, h2 := hash(s)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the items added, the ones taken for known are not counted
+func (f *Filter) Len() int {
+	return f.n
+}
+
+// Bytes returns the memory of the bit set
+func (f *Filter) Bytes() int {
+	return len(f.bits) * 8
+}
+
+// two halves of the 128 bit fnv for the double hashing, h2 is odd to never be zero
+func hash(s string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+	return h1, h2 | 1
+}
@@ -0,0 +1,84 @@
+package bloom
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func addr(i int) string {
+	return fmt.Sprintf("%d.%d.%d.%d:8333", 1+i>>24&0x7f, i>>16&0xff, i>>8&0xff, i&0xff)
+}
+
+func TestFilterRate(t *testing.T) {
+	const items, rate = 100000, 0.001
+	f := New(items, rate)
+	for i := 0; i < items; i++ {
+		f.Add(addr(i))
+	}
+	for i := 0; i < items; i++ {
+		if !f.Has(addr(i)) {
+			t.Fatalf("%s added and not found", addr(i))
+		}
+	}
+	falsePositives := 0
+	for i := items; i < 2*items; i++ {
+		if f.Has(addr(i)) {
+			falsePositives++
+		}
+	}
+	if got := float64(falsePositives) / items; got > 2*rate {
+		t.Errorf("false positive rate %.4f, sized for %.4f", got, rate)
+	}
+	// the added ones are counted once, the false positives are not
+	if f.Len() > items || f.Len() < items-2*int(items*rate) {
+		t.Errorf("len %d, want about %d", f.Len(), items)
+	}
+}
+
+// heap taken by fill, the result is kept alive until measured
+func heapOf(fill func() interface{}) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := fill()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+// memory of the known addresses of a multi-million crawl,
+// the exact map of the default against KNOWN_BLOOM at its default rate
+func BenchmarkKnown5M(b *testing.B) {
+	const items = 5000000
+	addrs := make([]string, items)
+	for i := range addrs {
+		addrs[i] = addr(i)
+	}
+	b.Run("map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			heap := heapOf(func() interface{} {
+				known := make(map[string]struct{})
+				for _, a := range addrs {
+					// the client keeps its own copy of every address
+					known[string([]byte(a))] = struct{}{}
+				}
+				return known
+			})
+			b.ReportMetric(float64(heap)/(1<<20), "MB")
+		}
+	})
+	b.Run("bloom", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			heap := heapOf(func() interface{} {
+				known := New(items, 0.001)
+				for _, a := range addrs {
+					known.Add(a)
+				}
+				return known
+			})
+			b.ReportMetric(float64(heap)/(1<<20), "MB")
+		}
+	})
+}
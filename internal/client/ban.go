@@ -0,0 +1,236 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	seedfile "github.com/1F47E/go-btc-xray/internal/seeds"
+)
+
+// ban of a host, see banList
+type ban struct {
+	Until  time.Time        `json:"until"`
+	Reason node.Misbehavior `json:"reason"`
+}
+
+// banList keeps the misbehaving hosts out of the queue and the dials, all their ports.
+// A host is banned for BAN_TIME after BAN_STRIKES misbehaviors, the bans are saved
+// to BansFilename in the data dir and loaded by the next run. BAN_FILE hosts are banned
+// while listed, the file is read on start and on SIGHUP
+type banList struct {
+	mu      sync.Mutex
+	saveMu  sync.Mutex
+	log     logger.Interface
+	path    string
+	bans    map[string]ban
+	manual  map[string]struct{}
+	strikes map[string]int
+}
+
+func newBanList(log logger.Interface) *banList {
+	b := &banList{
+		log:     log,
+		path:    filepath.Join(cfg.DataDir, cfg.BansFilename),
+		bans:    make(map[string]ban),
+		manual:  make(map[string]struct{}),
+		strikes: make(map[string]int),
+	}
+	if err := b.load(time.Now()); err != nil {
+		log.Errorf("failed to load the bans, starting without them: %v", err)
+	}
+	if cfg.BanFile != "" {
+		if err := b.loadManual(); err != nil {
+			log.Errorf("failed to read ban file: %v", err)
+		}
+	}
+	return b
+}
+
+// ipv4 mapped ipv6 is the same host as the ipv4
+func banKey(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// the saved bans, the expired ones are dropped
+func (b *banList) load(now time.Time) error {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var bans map[string]ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return fmt.Errorf("%s: %v", b.path, err)
+	}
+	for host, bn := range bans {
+		if bn.Until.After(now) {
+			b.bans[host] = bn
+		}
+	}
+	if len(b.bans) > 0 {
+		b.log.Infof("%d banned hosts loaded", len(b.bans))
+	}
+	return nil
+}
+
+// loadManual replaces the BAN_FILE hosts, the port of a host:port line is ignored.
+// a file with malformed lines is still loaded
+func (b *banList) loadManual() error {
+	lines, err := seedfile.FromFile(cfg.BanFile)
+	var malformed *seedfile.MalformedError
+	if err != nil && !errors.As(err, &malformed) {
+		return err
+	}
+	manual := make(map[string]struct{}, len(lines))
+	for _, line := range lines {
+		host := line
+		if h, _, err := net.SplitHostPort(line); err == nil {
+			host = h
+		}
+		manual[banKey(host)] = struct{}{}
+	}
+	b.mu.Lock()
+	b.manual = manual
+	b.mu.Unlock()
+	b.log.Infof("%d hosts banned by the ban file", len(manual))
+	return err
+}
+
+// banned is true while the host is listed in BAN_FILE or its ban is not expired
+func (b *banList) banned(host string, now time.Time) bool {
+	key := banKey(host)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.manual[key]; ok {
+		return true
+	}
+	bn, ok := b.bans[key]
+	if !ok {
+		return false
+	}
+	if now.Before(bn.Until) {
+		return true
+	}
+	delete(b.bans, key)
+	return false
+}
+
+// strike counts the misbehavior of the host, true once it is banned.
+// the bans are saved right away to survive a crash
+func (b *banList) strike(host string, reason node.Misbehavior, now time.Time) bool {
+	if cfg.BanTime == 0 {
+		return false
+	}
+	key := banKey(host)
+	b.mu.Lock()
+	b.strikes[key]++
+	if b.strikes[key] < cfg.BanStrikes {
+		b.mu.Unlock()
+		return false
+	}
+	delete(b.strikes, key)
+	b.bans[key] = ban{Until: now.Add(cfg.BanTime), Reason: reason}
+	b.mu.Unlock()
+	if err := b.save(); err != nil {
+		b.log.Errorf("failed to save the bans: %v", err)
+	}
+	return true
+}
+
+// save writes the bans to a temp file renamed over the old one, a crash leaves
+// either of them whole. the snapshot is taken under the lock, the file is written
+// outside of it, saveMu keeps the snapshots in order
+func (b *banList) save() error {
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+	b.mu.Lock()
+	data, err := json.MarshalIndent(b.bans, "", "  ")
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(cfg.FileMode)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), b.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// count of the banned hosts, the manual ones included, the expired ones are dropped
+func (b *banList) count(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, bn := range b.bans {
+		if !now.Before(bn.Until) {
+			delete(b.bans, key)
+		}
+	}
+	cnt := len(b.bans)
+	for key := range b.manual {
+		if _, ok := b.bans[key]; !ok {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// the batch without the banned hosts
+func (c *Client) dropBanned(batch node.AddrBatch, now time.Time) node.AddrBatch {
+	kept, dropped := filterBatch(batch, func(addr string) bool {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		return !c.bans.banned(host, now)
+	})
+	atomic.AddInt64(&c.banHits, int64(dropped))
+	return kept
+}
+
+// count the misbehavior of the closed node toward the ban of its host
+func (c *Client) strike(n *node.Node) {
+	reason := n.Misbehaved()
+	if reason == "" {
+		return
+	}
+	if c.bans.strike(n.Host(), reason, time.Now()) {
+		c.log.Infof("%s banned for %s: %s", n.Host(), cfg.BanTime, reason)
+	}
+}
+
+// ReloadBanFile reads BAN_FILE again on SIGHUP, the hosts no longer listed are unbanned
+func (c *Client) ReloadBanFile() {
+	if cfg.BanFile == "" {
+		return
+	}
+	if err := c.bans.loadManual(); err != nil {
+		c.log.Errorf("reload: ban file: %v", err)
+	}
+}
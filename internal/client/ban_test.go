@@ -0,0 +1,45 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+)
+
+func TestBanStrikesSaved(t *testing.T) {
+	defer func(dir string, banTime time.Duration, strikes int) {
+		cfg.DataDir, cfg.BanTime, cfg.BanStrikes = dir, banTime, strikes
+	}(cfg.DataDir, cfg.BanTime, cfg.BanStrikes)
+	cfg.DataDir, cfg.BanTime, cfg.BanStrikes = t.TempDir(), time.Hour, 2
+
+	now := time.Now()
+	b := newBanList(nopLogger{})
+	if b.strike("10.0.0.1", node.MisbehaviorRejectedUs, now) {
+		t.Fatal("banned on the first strike")
+	}
+	if !b.strike("::ffff:10.0.0.1", node.MisbehaviorBadMessages, now) {
+		t.Fatal("not banned on the second strike")
+	}
+	if !b.banned("10.0.0.1", now) {
+		t.Error("ipv4 mapped strike did not ban the ipv4 host")
+	}
+	// only the saved file is left in the data dir
+	entries, err := os.ReadDir(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(b.path) {
+		t.Errorf("data dir has %v, want only %s", entries, filepath.Base(b.path))
+	}
+
+	loaded := newBanList(nopLogger{})
+	if !loaded.banned("10.0.0.1", now) {
+		t.Error("ban not loaded")
+	}
+	if loaded.banned("10.0.0.1", now.Add(2*time.Hour)) {
+		t.Error("expired ban still active")
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+)
+
+// traffic rate window, shorter ones jump with every addr answer
+const bandwidthWindow = 2 * time.Second
+
+// bandwidth is the rate of all the connections in bytes per second
+// over the last full window, see node.Traffic
+type bandwidth struct {
+	mu    sync.Mutex
+	at    time.Time
+	total uint64
+	rate  float64
+}
+
+// sample returns the rate of the last full window, zero until one passed
+func (b *bandwidth) sample(now time.Time) float64 {
+	in, out := node.Traffic()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.at.IsZero() {
+		b.at, b.total = now, in+out
+		return 0
+	}
+	if d := now.Sub(b.at); d >= bandwidthWindow {
+		b.rate = float64(in+out-b.total) / d.Seconds()
+		b.at, b.total = now, in+out
+	}
+	return b.rate
+}
+
+// MAX_BANDWIDTH, the connector does not take the next node while the traffic
+// is over the limit, the open connections go on. false on exit
+func (c *Client) waitBandwidth() bool {
+	if cfg.MaxBandwidthKBps == 0 {
+		return true
+	}
+	limit := float64(cfg.MaxBandwidthKBps) * 1024
+	for c.bandwidth.sample(time.Now()) > limit {
+		if atomic.CompareAndSwapInt32(&c.bandwidthCapped, 0, 1) {
+			c.log.Warnf("traffic over %dKB/s, dials wait for MAX_BANDWIDTH", cfg.MaxBandwidthKBps)
+		}
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if atomic.CompareAndSwapInt32(&c.bandwidthCapped, 1, 0) {
+		c.log.Info("traffic below MAX_BANDWIDTH, dials resumed")
+	}
+	return true
+}
@@ -5,46 +5,181 @@
 // New nodes can be added to the client at any time from another nodes.
 // They added to the nodes map for quick check for duplicates
 // New nodes also added to the new nodes slices and then feeded to the queue to connect.
+//
+// The client does not depend on the gui. Stats go to a stats.Sink and logs to a logger.Sink,
+// both optional, so it can be embedded with any reporter:
+//
+//	log := logger.New(logger.SinkFunc(func(level logger.Level, line string, peer bool) { ... }))
+//	c := client.NewClient(ctx, log, stats.SinkFunc(func(s stats.Stats) { ... }))
+//	c.AddSeeds(client.SeedNodes(log))
+//	c.Start()
+//	<-c.Done()
+//	report := c.Summary()
 package client
 
 import (
 	"context"
-	"math/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/1F47E/go-btc-xray/internal/bloom"
 	"github.com/1F47E/go-btc-xray/internal/client/node"
 	"github.com/1F47E/go-btc-xray/internal/config"
-	"github.com/1F47E/go-btc-xray/internal/gui"
+	"github.com/1F47E/go-btc-xray/internal/dns"
+	"github.com/1F47E/go-btc-xray/internal/geo"
+	"github.com/1F47E/go-btc-xray/internal/jitter"
 	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/report"
+	seedfile "github.com/1F47E/go-btc-xray/internal/seeds"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+	"github.com/1F47E/go-btc-xray/internal/storage"
 )
 
-var cfg = config.New()
+var cfg = config.Shared()
 
 type Client struct {
 	mu   sync.Mutex
 	ctx  context.Context
 	exit context.CancelFunc
-	log  *logger.Logger
+	log  logger.Interface
+
+	// crawl start time for the summary
+	started time.Time
+	// why the client stopped by itself, empty if stopped from outside
+	exitReason string
 
 	// nodes storage
-	nodes     map[string]*node.Node
-	nodesNew  []*node.Node
+	nodes map[string]*node.Node
+	// MAX_KNOWN_NODES, the nodes map by the last announce and the entries dropped, see evictKnown
+	seen    *seenOrder
+	evicted int64
+	// unique addresses, the nodes map is empty with the known filter.
+	// the evicted ones are taken out, announced again they count once
+	nodesCnt int
+	queue    *nodeQueue
+	// DISK_QUEUE, new addresses wait in the spool, the queue only keeps the retries.
+	// nil without it or if the file failed
+	spool *spool
+	// KNOWN_BLOOM or DISK_QUEUE, the known addresses instead of the nodes map,
+	// the nodes are dropped after the dial
+	known     *bloom.Filter
 	nodesGood []*node.Node
+	// unique endpoints that completed the handshake,
+	// nodes map is keyed by the raw address so the same endpoint can be there twice
+	reachable map[string]struct{}
+	// reachable but closed after the version, appended to the rejected log
+	nodesRejected []*node.Node
+	rejected      map[node.Reject]int
+
+	// address type counters of all and good nodes
+	addrTotal map[node.AddrType]int
+	addrGood  map[node.AddrType]int
+	// good nodes count by port
+	ports map[int]int
+	// good nodes count by kind and pruned nodes skipped with SKIP_LIMITED
+	goodKinds      map[node.Kind]int
+	limitedSkipped int
+	// nodes by the seed they came through, see Seed
+	seedCounts map[string]*stats.SeedCount
+	// entries of SEED_FILE added so far, see ReloadSeedFile
+	seedFile map[string]struct{}
+	// RESUME nodes by their last good time, saved again until RETENTION, see carriedNodes
+	carried map[string]time.Time
+	// good nodes count by netgroup, see node.NetGroup
+	netgroups map[string]int
+	// good nodes count by country and ASN name, empty without the geo file
+	geo       *geo.DB
+	countries map[string]int
+	asns      map[string]int
+
+	// dead nodes by the reason of the last failed connect
+	deadReasons map[node.DeadReason]int
+	// dial errors and retries by error class
+	dialErrs    map[node.DialErr]int
+	dialRetries map[node.DialErr]int
+	// the dials dropped by MAX_ATTEMPTS, the failed connects are counted on the node
+	attemptsCapped int64
+
+	// nodes in the connectors by the time they were taken, see Connections
+	inFlight map[*node.Node]time.Time
+
+	// connector workers by index, resized with SetConnectionsLimit
+	connLimit  int
+	connMax    int
+	connectors map[int]struct{}
+	// closed and replaced on every resize to wake up the idle connectors
+	resizeCh chan struct{}
+	// nanoseconds, changed with SetSaveInterval
+	saveInterval int64
+
+	// MONITOR peers by endpoint and the ones that failed or dropped,
+	// not picked again until the refresh, see wMonitor
+	monitored      map[string]*node.Node
+	monitorTried   map[string]struct{}
+	monitorStarted bool
+	monitorDrops   int
+	// the connectors are stopped while the monitor set is up, see pauseCrawl
+	crawlPaused bool
+	refreshing  bool
+
+	// dials and failures since the last tuner check, see wConnTuner
+	dialsWindow int64
+	failsWindow int64
+	filesWindow int64
 
 	// atomic counters
 	nodesDeadCnt int32
 	activeConns  int32
+	// unix nano of the last addr batch, used to detect the drained queue
+	lastAddrAt int64
+	// finished connections, their total nanoseconds in the connectors
+	// and the fast crawl nodes closed without an answer, see SlotTurnover
+	connsDone    int64
+	connTime     int64
+	addrTimeouts int64
+	// addr entries from the peers and the ones dropped by MAX_DEPTH
+	addrsSeen    int64
+	addrsTooDeep int64
+	// connections closed by PREEMPT_MAX, see wPreempter
+	preempted int64
+	// misbehaving hosts and the addresses skipped or the dials dropped for a ban, see banList
+	bans    *banList
+	banHits int64
+	// WHITELIST, nil without it, and the addresses outside of it
+	whitelist      *whitelist
+	notWhitelisted int64
+	// peers connected to us, see wInbound
+	inboundActive, inboundAccepted, inboundHandshaked, inboundRefused int32
+	// 1 while the dials wait for MAX_GOROUTINES, see waitGoroutines
+	goroutinesCapped int32
+	// traffic rate and 1 while the dials wait for MAX_BANDWIDTH, see waitBandwidth.
+	// the node totals are for the process, the ones at the start are taken off
+	bandwidth                       bandwidth
+	bandwidthCapped                 int32
+	trafficStartIn, trafficStartOut uint64
 
 	// channels
-	queueCh   chan *node.Node
-	guiCh     chan gui.IncomingData
+	queueCh chan *node.Node
+	// optional, gui or any other stats consumer
+	sink      stats.Sink
 	nodeResCh chan *node.Node
-	newAddrCh chan []string
+	newAddrCh chan node.AddrBatch
+	// good nodes for the library users, see GoodNodes
+	goodCh      chan *node.Node
+	goodDropped int64
 }
 
-func NewClient(ctx context.Context, log *logger.Logger, guiCh chan gui.IncomingData) *Client {
+// buffer of the GoodNodes channel
+const goodChSize = 100
+
+// sink is optional, nil to skip the stats collection
+func NewClient(ctx context.Context, log logger.Interface, sink stats.Sink) *Client {
 	// client context to stop the client but not the gui
 	// TODO: exit if no gui
 	cliCtx, cancel := context.WithCancel(ctx)
@@ -54,17 +189,38 @@ func NewClient(ctx context.Context, log *logger.Logger, guiCh chan gui.IncomingD
 
 		// called when the is no new nodes anymore to stop all the client workers
 		exit: cancel,
-		log:  log,
+		log:  log.With(logger.FieldModule, "client"),
 
 		// keeping all the nodes in a map for quick check for duplicates
 		nodes: make(map[string]*node.Node),
+		seen:  newSeenOrder(),
 
-		// all new nodes also added to the list
-		// then feeder will put them to the queue
-		nodesNew: make([]*node.Node, 0, 1000),
+		// all new nodes are also added to the priority queue
+		// then feeder will put them to the dial queue
+		queue: newNodeQueue(cfg.QueueSort),
 
 		// node considered good after successful connection and handshake
 		nodesGood: make([]*node.Node, 0),
+		reachable: make(map[string]struct{}),
+		rejected:  make(map[node.Reject]int),
+
+		addrTotal:  make(map[node.AddrType]int),
+		addrGood:   make(map[node.AddrType]int),
+		ports:      make(map[int]int),
+		goodKinds:  make(map[node.Kind]int),
+		seedCounts: make(map[string]*stats.SeedCount),
+		seedFile:   make(map[string]struct{}),
+		carried:    make(map[string]time.Time),
+		inFlight:   make(map[*node.Node]time.Time),
+
+		geo:       loadGeo(log),
+		netgroups: make(map[string]int),
+		countries: make(map[string]int),
+		asns:      make(map[string]int),
+
+		deadReasons: make(map[node.DeadReason]int),
+		dialErrs:    make(map[node.DialErr]int),
+		dialRetries: make(map[node.DialErr]int),
 
 		// feeder will put new nodes to the queue
 		queueCh: make(chan *node.Node, cfg.ConnectionsLimit),
@@ -72,19 +228,71 @@ func NewClient(ctx context.Context, log *logger.Logger, guiCh chan gui.IncomingD
 		// results from the successfull node connection and handshake
 		nodeResCh: make(chan *node.Node),
 
-		// used to send updates to the gui
-		guiCh: guiCh,
+		// used to send stats updates to the gui
+		sink: sink,
 
 		// connected nodes will send batch of addresses, usually 1000
 		// then they will be proccessed by the worker wNewAddrListner
-		newAddrCh: make(chan []string, cfg.ConnectionsLimit),
+		newAddrCh: make(chan node.AddrBatch, cfg.AddrChSize),
+
+		goodCh: make(chan *node.Node, goodChSize),
+
+		connLimit:    cfg.ConnectionsLimit,
+		connMax:      cfg.ConnectionsLimit,
+		connectors:   make(map[int]struct{}),
+		resizeCh:     make(chan struct{}),
+		saveInterval: int64(cfg.SaveInterval),
+
+		monitored:    make(map[string]*node.Node),
+		monitorTried: make(map[string]struct{}),
+	}
+	if cfg.DiskQueue {
+		c.openSpool()
+	}
+	// new addresses taken for known at the BLOOM_RATE are never dialed
+	if cfg.KnownBloom || c.spool != nil {
+		c.known = bloom.New(cfg.BloomItems, cfg.BloomRate)
+		c.log.Infof("known addresses filter %dKb for %d", c.known.Bytes()/1024, cfg.BloomItems)
+	}
+	c.bans = newBanList(c.log)
+	if cfg.Whitelist != "" {
+		c.loadWhitelist()
 	}
 	return &c
 }
 
+// the memory queue is used if the file can't be created
+func (c *Client) openSpool() {
+	s, err := openSpool()
+	if err != nil {
+		c.log.Errorf("%v, the queue stays in memory", err)
+		return
+	}
+	c.spool = s
+	c.log.Infof("queue in %s", s.path)
+}
+
+// called by the feeder on exit, the addresses coming after are dropped
+func (c *Client) closeSpool() {
+	if c.spool == nil {
+		return
+	}
+	if err := c.spool.close(); err != nil {
+		c.log.Errorf("failed to remove queue file: %v", err)
+	}
+}
+
 func (c *Client) Start() {
-	// collect and send data to the gui via channel
-	go c.wGuiUpdater()
+	c.mu.Lock()
+	c.started = time.Now()
+	c.trafficStartIn, c.trafficStartOut = node.Traffic()
+	c.mu.Unlock()
+	atomic.StoreInt64(&c.lastAddrAt, c.started.UnixNano())
+
+	// collect and send stats to the sink
+	if c.sink != nil {
+		go c.wStatsUpdater()
+	}
 
 	// proccess good nodes that comes from the connector workers
 	go c.wNodeResultsHandler()
@@ -98,49 +306,886 @@ func (c *Client) Start() {
 	// feed the queue with new nodes
 	go c.wNodesFeeder()
 
+	// lower the connections on dial failures
+	if cfg.AdaptiveConn {
+		go c.wConnTuner()
+	}
+
+	// peers connecting to us, outside of the connectors
+	if cfg.ListenAddr != "" {
+		go c.wInbound()
+	}
+
+	// free the slots of the idle connections for the queued nodes
+	if cfg.PreemptMax > 0 {
+		go c.wPreempter()
+	}
+
+	// stop when there is nothing left to crawl, the monitor waits for a refresh instead
+	if cfg.Monitor > 0 {
+		go c.wMonitor()
+	} else if cfg.DrainTimeout > 0 {
+		go c.wDrainWatcher()
+	}
+
 	// start a worker pool to connect to the nodes
-	for i := 0; i < cfg.ConnectionsLimit; i++ {
-		i := i
+	c.mu.Lock()
+	c.startConnectors()
+	c.mu.Unlock()
+}
+
+// start the missing connectors up to the limit, called under the lock
+func (c *Client) startConnectors() {
+	for i := 0; i < c.connLimit; i++ {
+		if _, ok := c.connectors[i]; ok {
+			continue
+		}
+		c.connectors[i] = struct{}{}
 		go c.wNodesConnector(i)
 	}
 }
 
+// connector over the limit exits before taking the next node,
+// otherwise it gets the channel to wait for the next resize
+func (c *Client) connectorDone(i int) (bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i < c.connLimit {
+		return false, c.resizeCh
+	}
+	delete(c.connectors, i)
+	return true, nil
+}
+
+// SetConnectionsLimit resizes the connector pool. New connectors start right away,
+// the extra ones finish the node they are dialing and exit, idle ones exit at once.
+// with ADAPTIVE_CONN it's the max for the tuner, the crawl paused by the monitor stays paused
+func (c *Client) SetConnectionsLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connMax = limit
+	if !c.crawlPaused {
+		c.resize(limit)
+	}
+}
+
+// called under the lock
+func (c *Client) resize(limit int) {
+	if limit == c.connLimit {
+		return
+	}
+	c.connLimit = limit
+	close(c.resizeCh)
+	c.resizeCh = make(chan struct{})
+	if !c.started.IsZero() {
+		c.startConnectors()
+	}
+}
+
+// ConnectionsLimit returns the current size of the connector pool
+func (c *Client) ConnectionsLimit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connLimit
+}
+
+// SetSaveInterval changes how often the nodes file is rewritten, from the next save
+func (c *Client) SetSaveInterval(d time.Duration) {
+	atomic.StoreInt64(&c.saveInterval, int64(d))
+}
+
 // TODO: refactor this to know what nodes are now connected
 func (c *Client) Disconnect() {
-	c.log.Debug("[CLIENT]: disconnecting...")
-	defer c.log.Debug("[CLIENT]: exited")
+	c.log.Debug("disconnecting...")
+	defer c.log.Debug("exited")
 	cnt := 0
 	for _, n := range c.nodes {
 		if n.Disconnect() {
 			cnt++
 		}
 	}
-	c.log.Debugf("[CLIENT]: disconnected %d nodes\n", cnt)
+	c.mu.Lock()
+	// not in the nodes map, the connected ones are in the connectors
+	if c.known != nil {
+		for n := range c.inFlight {
+			if n.Disconnect() {
+				cnt++
+			}
+		}
+	}
+	for _, n := range c.monitored {
+		if n.Disconnect() {
+			cnt++
+		}
+	}
+	c.mu.Unlock()
+	c.log.Debugf("disconnected %d nodes\n", cnt)
+}
+
+// origins of the seeds besides the dns seeds, see Seed
+const (
+	OriginSeeds    = "SEEDS"
+	OriginSeedFile = "SEED_FILE"
+	OriginPrevious = "previous cycle"
+	// gossip of the peers connected to us
+	OriginInbound = "inbound"
+)
+
+// Seed is a seed node and where it came from, a dns seed name or one of the origins above.
+// the nodes found through it count for its origin, see SeedCounts
+type Seed struct {
+	Addr   string
+	Origin string
+	// when a saved node was last good, zero for the others, see RETENTION
+	LastGood time.Time
+}
+
+func newSeeds(origin string, addrs []string) []Seed {
+	seeds := make([]Seed, len(addrs))
+	for i, addr := range addrs {
+		seeds[i] = Seed{Addr: addr, Origin: origin}
+	}
+	return seeds
+}
+
+// SeedNodes returns the configured seed nodes, the seed file nodes
+// plus the nodes resolved from the dns seeds
+func SeedNodes(log logger.Interface) []Seed {
+	seeds := newSeeds(OriginSeeds, cfg.Seeds)
+	if cfg.SeedFile != "" {
+		fromFile, err := seedfile.FromFile(cfg.SeedFile)
+		var malformed *seedfile.MalformedError
+		if errors.As(err, &malformed) {
+			log.Warnf("seed file: %v", err)
+		} else if err != nil {
+			log.Errorf("failed to read seed file: %v", err)
+		}
+		log.Infof("%d nodes from the seed file", len(fromFile))
+		seeds = append(seeds, newSeeds(OriginSeedFile, fromFile)...)
+	}
+	if len(cfg.DnsSeeds) > 0 {
+		bySeed := dns.New(log).Scan()
+		// in the config order, the first seed wins a node found through many
+		for _, seed := range cfg.DnsSeeds {
+			seeds = append(seeds, newSeeds(seed, bySeed[seed])...)
+		}
+	}
+	return seeds
 }
 
-func (c *Client) AddNodes(ips []string) {
-	c.log.Debugf("[CLIENT]: got batch of %d nodes\n", len(ips))
+// SavedNodes returns the good nodes saved by the previous run for RESUME,
+// from the nodes cache when it is up to date
+func SavedNodes(log logger.Interface) []Seed {
+	if !cfg.Resume {
+		return nil
+	}
+	start := time.Now()
+	saved, err := storage.Load(log, filepath.Join(cfg.DataDir, cfg.NodesFilename))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("failed to load the saved nodes: %v", err)
+		}
+		return nil
+	}
+	seeds := make([]Seed, 0, len(saved))
+	pruned := 0
+	for _, n := range saved {
+		// no last good without the cache, loaded but not carried
+		if cfg.Retention > 0 && !n.LastGood.IsZero() && start.Sub(n.LastGood) > cfg.Retention {
+			pruned++
+			continue
+		}
+		seeds = append(seeds, Seed{Addr: n.Endpoint, Origin: OriginPrevious, LastGood: n.LastGood})
+	}
+	log.Infof("%d saved nodes loaded in %s", len(seeds), time.Since(start).Round(time.Millisecond))
+	if pruned > 0 {
+		log.Infof("%d saved nodes pruned, not good for %s", pruned, cfg.Retention)
+	}
+	return seeds
+}
+
+// bootstrap retries double the delay up to this
+const bootstrapDelayMax = 5 * time.Minute
+
+// Bootstrap returns the seed nodes, retrying with a doubling delay while none is found,
+// BOOTSTRAP_RETRIES times or forever if negative. The error is returned when the retries
+// are used up or the context is canceled.
+func Bootstrap(ctx context.Context, log logger.Interface) ([]Seed, error) {
+	delay := cfg.BootstrapDelay
+	for attempt := 1; ; attempt++ {
+		seeds := SeedNodes(log)
+		if len(seeds) > 0 {
+			return seeds, nil
+		}
+		if cfg.BootstrapRetries >= 0 && attempt > cfg.BootstrapRetries {
+			return nil, fmt.Errorf("no seed nodes found (attempts: %d), check the network or set SEEDS or SEED_FILE", attempt)
+		}
+		wait := jitter.Apply(delay)
+		log.Warnf("no seed nodes found, attempt %d, next in %s", attempt, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+		if delay > bootstrapDelayMax {
+			delay = bootstrapDelayMax
+		}
+	}
+}
+
+// GoodNodes delivers every node right after it's added to the good list.
+// Delivery never blocks the crawl: the channel is buffered and nodes are dropped
+// while it's full, see GoodDropped. Each node is sent at most once, in the order
+// they were confirmed. The channel is closed after the started client stops.
+// Reading is optional, the good list is complete either way.
+func (c *Client) GoodNodes() <-chan *node.Node {
+	return c.goodCh
+}
+
+// GoodDropped returns how many good nodes were not delivered to GoodNodes
+func (c *Client) GoodDropped() int64 {
+	return atomic.LoadInt64(&c.goodDropped)
+}
+
+// Done is closed when the client context is canceled
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Stop cancels the client context with a reason
+func (c *Client) Stop(reason string) {
+	c.mu.Lock()
+	if c.exitReason == "" {
+		c.exitReason = reason
+	}
+	c.mu.Unlock()
+	c.exit()
+}
+
+// ExitReason is empty if the client was not stopped with Stop
+func (c *Client) ExitReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exitReason
+}
+
+// endpoints of the good nodes, used to seed the next crawl
+func (c *Client) GoodEndpoints() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make([]string, len(c.nodesGood))
+	for i, n := range c.nodesGood {
+		ret[i] = n.EndpointSafe()
+	}
+	return ret
+}
+
+// Summary returns nil if the client was never started
+func (c *Client) Summary() *report.Summary {
+	if c.started.IsZero() {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _, stale, lying := c.classifyHeights()
+	s := report.New(string(cfg.Network), c.started, c.nodesCnt, int(atomic.LoadInt32(&c.nodesDeadCnt)), c.nodesGood)
+	s.NodesStale, s.NodesLying = stale, lying
+	s.SetFeeFilter(c.connectedFees())
+	s.NodesReachable = len(c.reachable)
+	s.AddrsSeen = atomic.LoadInt64(&c.addrsSeen)
+	s.OurUserAgent = cfg.UserAgent
+	s.NodesRejected = len(c.nodesRejected)
+	s.NodesLimitedSkipped = c.limitedSkipped
+	for kind, cnt := range c.goodKinds {
+		s.Kinds[string(kind)] = cnt
+	}
+	if len(c.rejected) > 0 {
+		s.Rejected = make(map[string]int, len(c.rejected))
+		for reason, cnt := range c.rejected {
+			s.Rejected[string(reason)] = cnt
+		}
+	}
+	if cfg.V2Probe {
+		v2 := 0
+		for _, n := range c.nodesGood {
+			if n.SupportsV2() {
+				v2++
+			}
+		}
+		s.NodesV2 = &v2
+	}
+	if cfg.ChainCheckpoint != nil {
+		s.Chain = make(map[string]int)
+		for _, n := range c.nodesGood {
+			s.Chain[string(n.Chain())]++
+		}
+	}
+	for t, cnt := range c.addrTotal {
+		s.Networks[string(t)] = report.NetCount{Total: cnt, Good: c.addrGood[t]}
+	}
+	for port, cnt := range c.ports {
+		s.Ports[port] = cnt
+	}
+	for reason, cnt := range c.deadReasons {
+		s.DeadReasons[string(reason)] = cnt
+	}
+	for class, cnt := range c.dialErrs {
+		s.DialErrors[string(class)] = cnt
+	}
+	for class, cnt := range c.dialRetries {
+		s.DialRetries[string(class)] = cnt
+	}
+	done, avg := c.SlotTurnover()
+	s.ConnsDone = done
+	s.ConnAvgMs = avg.Milliseconds()
+	s.AddrTimeouts = atomic.LoadInt64(&c.addrTimeouts)
+	s.Unconfirmed = node.Unconfirmed()
+	s.AddrsTooOld = node.AddrsTooOld()
+	s.AddrsTooDeep = atomic.LoadInt64(&c.addrsTooDeep)
+	s.Preempted = atomic.LoadInt64(&c.preempted)
+	s.AttemptsCapped = c.attemptsCapped
+	s.Evicted = c.evicted
+	s.BytesIn, s.BytesOut = c.Traffic()
+	if cfg.Whitelist != "" {
+		s.Filtered = map[string]int64{FilterNotWhitelisted: atomic.LoadInt64(&c.notWhitelisted)}
+	}
+	s.Banned = c.bans.count(time.Now())
+	if d := stats.DiversityStats(c.netgroups); d.Groups > 0 {
+		s.Diversity = &report.Diversity{Netgroups: d.Groups, LargestShare: d.LargestShare, HHI: d.HHI}
+	}
+	if c.geo != nil {
+		s.Countries = stats.TopGeo(c.countries)
+		s.ASNs = stats.TopGeo(c.asns)
+	}
+	s.BanHits = atomic.LoadInt64(&c.banHits)
+	if cfg.ListenAddr != "" {
+		in := c.InboundCounts()
+		s.Inbound = &report.Inbound{Accepted: in.Accepted, Handshaked: in.Handshaked, Refused: in.Refused}
+	}
+	if len(c.seedCounts) > 0 {
+		s.Seeds = make(map[string]report.SeedCount, len(c.seedCounts))
+		for origin, sc := range c.seedCounts {
+			s.Seeds[origin] = report.SeedCount{Queued: sc.Queued, Good: sc.Good, Dead: sc.Dead}
+		}
+	}
+	s.Files = storage.OutputFiles()
+	return s
+}
+
+// ReloadSeedFile reads SEED_FILE again on SIGHUP and queues the new entries,
+// the removed ones are only logged as the crawl already knows them.
+// a file with malformed lines or no seeds at all may be half written, the list is kept
+func (c *Client) ReloadSeedFile() {
+	if cfg.SeedFile == "" {
+		return
+	}
+	fromFile, err := seedfile.FromFile(cfg.SeedFile)
+	if err != nil {
+		c.log.Errorf("reload: seed file not changed: %v", err)
+		return
+	}
+	if len(fromFile) == 0 {
+		c.log.Warnf("reload: seed file not changed: no seeds in %s", cfg.SeedFile)
+		return
+	}
+	next := make(map[string]struct{}, len(fromFile))
+	var added []string
+	c.mu.Lock()
+	for _, addr := range fromFile {
+		if _, ok := next[addr]; ok {
+			continue
+		}
+		next[addr] = struct{}{}
+		if _, ok := c.seedFile[addr]; !ok {
+			added = append(added, addr)
+		}
+	}
+	removed := 0
+	for addr := range c.seedFile {
+		if _, ok := next[addr]; !ok {
+			removed++
+		}
+	}
+	c.seedFile = next
+	c.mu.Unlock()
+	if len(added) == 0 && removed == 0 {
+		c.log.Info("reload: seed file not changed")
+		return
+	}
+	c.log.Infof("reload: seed file %d added, %d removed", len(added), removed)
+	c.AddSeeds(newSeeds(OriginSeedFile, added))
+}
+
+// counts of the seed the node came through, nil if unknown. called under the lock
+func (c *Client) seedCount(origin string) *stats.SeedCount {
+	if origin == "" {
+		return nil
+	}
+	sc, ok := c.seedCounts[origin]
+	if !ok {
+		sc = &stats.SeedCount{}
+		c.seedCounts[origin] = sc
+	}
+	return sc
+}
+
+// SeedCounts returns the queued, good and dead nodes found through every seed
+func (c *Client) SeedCounts() map[string]stats.SeedCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seedCountsCopy()
+}
+
+// called under the lock
+func (c *Client) seedCountsCopy() map[string]stats.SeedCount {
+	counts := make(map[string]stats.SeedCount, len(c.seedCounts))
+	for origin, sc := range c.seedCounts {
+		counts[origin] = *sc
+	}
+	return counts
+}
+
+// flag the good nodes by the estimated tip, the median of their heights,
+// returns zeros while no height is known. called under the lock
+func (c *Client) classifyHeights() (max, tip int32, stale, lying int) {
+	heights := make([]int32, len(c.nodesGood))
+	for i, n := range c.nodesGood {
+		heights[i] = n.Height()
+	}
+	max, tip, ok := stats.HeightStats(heights)
+	if !ok {
+		return 0, 0, 0, 0
+	}
+	for _, n := range c.nodesGood {
+		isStale, isLying := stats.Stale(n.Height(), tip, cfg.StaleBlocks), stats.Lying(n.Height(), tip)
+		n.SetHeightFlags(isStale, isLying)
+		if isStale {
+			stale++
+		}
+		if isLying {
+			lying++
+		}
+	}
+	return max, tip, stale, lying
+}
+
+// reject counts the node closed after its version, it is reachable but not good
+func (c *Client) reject(n *node.Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reachable[n.Endpoint()] = struct{}{}
+	c.rejected[n.Rejected()]++
+	c.nodesRejected = append(c.nodesRejected, n)
+}
+
+// the redials after a timeout double the delay up to the max, see jitter.Backoff
+const (
+	dialRetryDelay    = 5 * time.Second
+	dialRetryDelayMax = time.Minute
+)
+
+// requeue the node after a delay if the dial error is worth retrying,
+// returns false if the node should be considered dead
+func (c *Client) retryDial(n *node.Node) bool {
+	class := n.DialErr()
+	if class == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialErrs[class]++
+	if attemptsOver(n) {
+		c.attemptsCapped++
+		return false
+	}
+	if !class.Retryable() || n.DialAttempts() > cfg.DialRetries || c.ctx.Err() != nil {
+		return false
+	}
+	c.dialRetries[class]++
+	c.queue.retry(n, time.Now().Add(jitter.Backoff(dialRetryDelay, dialRetryDelayMax, n.DialAttempts())))
+	return true
+}
+
+// connFailed counts the failed connect toward MAX_ATTEMPTS
+func (c *Client) connFailed(n *node.Node) {
+	if cfg.MaxAttempts > 0 {
+		n.ConnFailed()
+	}
+}
+
+// true once the endpoint failed MAX_ATTEMPTS times
+func attemptsOver(n *node.Node) bool {
+	return cfg.MaxAttempts > 0 && n.FailedConns() >= cfg.MaxAttempts
+}
+
+// save good nodes to a file, returns false on error
+func (c *Client) SaveNodes() bool {
+	if c.started.IsZero() {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	carried := c.carriedNodes()
+	err := storage.Save(c.nodesGood, carried)
+	if err != nil {
+		c.log.Errorf("failed to save nodes: %v", err)
+		return false
+	}
+	c.log.Infof("saved %d nodes, %d carried", len(c.nodesGood), len(carried))
+	return true
+}
+
+// the saved nodes of the previous runs not good in this one, kept until RETENTION
+// passes since they were last good. called under the lock
+func (c *Client) carriedNodes() []storage.SavedNode {
+	if len(c.carried) == 0 {
+		return nil
+	}
+	good := make(map[string]struct{}, len(c.nodesGood))
+	for _, n := range c.nodesGood {
+		good[n.EndpointSafe()] = struct{}{}
+	}
+	now := time.Now()
+	carried := make([]storage.SavedNode, 0, len(c.carried))
+	for endpoint, lastGood := range c.carried {
+		if _, ok := good[endpoint]; ok || now.Sub(lastGood) > cfg.Retention {
+			continue
+		}
+		carried = append(carried, storage.SavedNode{Endpoint: endpoint, LastGood: lastGood})
+	}
+	sort.Slice(carried, func(i, j int) bool { return carried[i].LastGood.After(carried[j].LastGood) })
+	return carried
+}
+
+// write the crawl summary, called on exit. returns the summary to print, nil if not started
+func (c *Client) SaveSummary() *report.Summary {
+	s := c.Summary()
+	if s == nil {
+		c.log.Debug("not started, no summary")
+		return nil
+	}
+	err := storage.SaveSummary(s)
+	if err != nil {
+		c.log.Errorf("failed to save summary: %v", err)
+		return s
+	}
+	c.log.Infof("summary saved, good:%d, dead:%d", s.NodesGood, s.NodesDead)
+	// the peers agree with each other, a median far from zero is our clock
+	if s.ClockSkew != nil {
+		c.log.Infof("median clock skew of the good nodes %s, %d off by over %s", time.Duration(s.ClockSkew.MedianMs)*time.Millisecond, s.ClockSkew.Off, stats.MaxClockSkew)
+	}
+	return s
+}
+
+// AddSeeds adds the seed nodes, they are not counted as announced
+func (c *Client) AddSeeds(seeds []Seed) {
+	byOrigin := make(map[string][]string)
+	var origins []string
+	c.mu.Lock()
+	for _, s := range seeds {
+		if s.Origin == OriginSeedFile {
+			c.seedFile[s.Addr] = struct{}{}
+		}
+		if cfg.Retention > 0 && !s.LastGood.IsZero() {
+			c.carried[s.Addr] = s.LastGood
+		}
+	}
+	c.mu.Unlock()
+	for _, s := range seeds {
+		if _, ok := byOrigin[s.Origin]; !ok {
+			origins = append(origins, s.Origin)
+		}
+		byOrigin[s.Origin] = append(byOrigin[s.Origin], s.Addr)
+	}
+	for _, origin := range origins {
+		c.addAnnounced(node.AddrBatch{Addrs: byOrigin[origin], Origin: origin})
+	}
+}
+
+// add the addresses sent by the peer, known ones only count the new announcer
+// and take the smaller depth
+func (c *Client) addAnnounced(batch node.AddrBatch) {
+	c.log.Debugf("got batch of %d nodes\n", len(batch.Addrs))
+	now := time.Now()
+	atomic.StoreInt64(&c.lastAddrAt, now.UnixNano())
+	// the seeds have no sender
+	if batch.From != "" {
+		atomic.AddInt64(&c.addrsSeen, int64(len(batch.Addrs)))
+	}
+	// the sender was at the max depth
+	if cfg.MaxDepth > 0 && int(batch.Depth) > cfg.MaxDepth {
+		atomic.AddInt64(&c.addrsTooDeep, int64(len(batch.Addrs)))
+		return
+	}
+	// the forms of an endpoint are one known node
+	batch = normalizeBatch(batch)
+	batch = c.dropBanned(batch, now)
+	batch = c.dropNotWhitelisted(batch)
 	cnt := 1
 	c.mu.Lock()
-	for _, ip := range ips {
-		if _, ok := c.nodes[ip]; ok {
+	if c.known != nil {
+		added, spooled := c.addFiltered(batch, now)
+		c.mu.Unlock()
+		cnt += added + c.pushSpool(spooled)
+		c.log.Debugf("got %d nodes from %d batch\n", cnt, len(batch.Addrs))
+		return
+	}
+	for i, ip := range batch.Addrs {
+		n, ok := c.nodes[ip]
+		if !ok {
+			n = newAnnounced(c.log, batch, i, c.newAddrCh)
+			// add new nodes to the all nodes map but also to the queue
+			c.nodes[ip] = n
+			c.nodesCnt++
+			if sc := c.seedCount(n.Origin()); sc != nil {
+				sc.Queued++
+			}
+			c.queue.push(n, now)
+			c.addrTotal[n.AddrType()]++
+			if cfg.MaxKnownNodes > 0 {
+				c.seen.touch(ip)
+			}
+			cnt++
 			continue
 		}
-		n := node.NewNode(c.log, ip, c.newAddrCh)
-		// add new nodes to the all nodes map but also to the queue
-		c.nodes[ip] = n
-		c.nodesNew = append(c.nodesNew, n)
-		cnt++
+		if cfg.MaxKnownNodes > 0 {
+			c.seen.touch(ip)
+		}
+		if batch.Advertised != nil {
+			n.SetAdvertised(batch.Advertised[i])
+		}
+		if batch.Depth < n.Depth() {
+			n.SetDepth(batch.Depth)
+		}
+		if batch.From != "" && n.Announced(batch.From) {
+			c.queue.announced(n, now)
+		}
+	}
+	c.evictKnown()
+	c.mu.Unlock()
+	c.log.Debugf("got %d nodes from %d batch\n", cnt, len(batch.Addrs))
+}
+
+// normalizeBatch returns the batch with the addresses in one form, see storage.NormalizeEndpoint
+func normalizeBatch(batch node.AddrBatch) node.AddrBatch {
+	addrs := make([]string, len(batch.Addrs))
+	for i, addr := range batch.Addrs {
+		addrs[i] = storage.NormalizeEndpoint(addr)
+	}
+	batch.Addrs = addrs
+	return batch
+}
+
+// filterBatch returns the batch with the addresses kept by keep and the dropped count,
+// the same batch if none is dropped
+func filterBatch(batch node.AddrBatch, keep func(addr string) bool) (node.AddrBatch, int) {
+	kept := node.AddrBatch{From: batch.From, Depth: batch.Depth, Origin: batch.Origin, At: batch.At}
+	for i, addr := range batch.Addrs {
+		if !keep(addr) {
+			continue
+		}
+		kept.Addrs = append(kept.Addrs, addr)
+		if batch.Advertised != nil {
+			kept.Advertised = append(kept.Advertised, batch.Advertised[i])
+		}
+	}
+	dropped := len(batch.Addrs) - len(kept.Addrs)
+	if dropped == 0 {
+		return batch, 0
+	}
+	return kept, dropped
+}
+
+// node of the i-th address of the batch
+func newAnnounced(log logger.Interface, batch node.AddrBatch, i int, addrCh chan node.AddrBatch) *node.Node {
+	n := node.NewNode(log, batch.Addrs[i], addrCh)
+	if batch.From != "" {
+		n.Announced(batch.From)
+	}
+	if batch.Advertised != nil {
+		n.SetAdvertised(batch.Advertised[i])
+	}
+	n.SetDepth(batch.Depth)
+	n.SetOrigin(batch.Origin)
+	return n
+}
+
+// queue the addresses unknown to the filter, returns how many were new.
+// only the first announcer is counted, with the spool none as the nodes are created
+// by the feeder. the new addresses for the spool are returned instead, see pushSpool.
+// called under the lock
+func (c *Client) addFiltered(batch node.AddrBatch, now time.Time) (int, []string) {
+	fresh := make([]int, 0, len(batch.Addrs))
+	for i, ip := range batch.Addrs {
+		if c.known.Add(ip) {
+			fresh = append(fresh, i)
+		}
+	}
+	if c.spool != nil {
+		addrs := make([]string, len(fresh))
+		for i, idx := range fresh {
+			addrs[i] = batch.Addrs[idx]
+		}
+		return 0, addrs
+	}
+	for _, i := range fresh {
+		c.queue.push(newAnnounced(c.log, batch, i, c.newAddrCh), now)
+	}
+	if sc := c.seedCount(batch.Origin); sc != nil {
+		sc.Queued += len(fresh)
+	}
+	c.countAdded(batch.Addrs, fresh)
+	return len(fresh), nil
+}
+
+// pushSpool writes the new addresses to the spool outside of the lock,
+// returns how many were queued
+func (c *Client) pushSpool(addrs []string) int {
+	if len(addrs) == 0 {
+		return 0
+	}
+	if err := c.spool.push(addrs); err != nil {
+		if !errors.Is(err, os.ErrClosed) {
+			c.log.Errorf("%v, %d addresses lost", err, len(addrs))
+		}
+		return 0
+	}
+	all := make([]int, len(addrs))
+	for i := range all {
+		all[i] = i
+	}
+	c.mu.Lock()
+	c.countAdded(addrs, all)
+	c.mu.Unlock()
+	return len(addrs)
+}
+
+// count the queued addresses of the indexes, called under the lock
+func (c *Client) countAdded(addrs []string, idx []int) {
+	for _, i := range idx {
+		c.addrTotal[node.AddrTypeOf(addrs[i])]++
+	}
+	c.nodesCnt += len(idx)
+}
+
+// next node to dial, the due retries go first. nil if there is none.
+// takes the lock, the spool is read outside of it
+func (c *Client) nextNode() *node.Node {
+	c.mu.Lock()
+	c.queue.release(time.Now())
+	n := c.queue.pop()
+	c.mu.Unlock()
+	if n != nil || c.spool == nil {
+		return n
+	}
+	addr, ok, err := c.spool.pop()
+	if err != nil {
+		c.log.Errorf("%v", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return node.NewNode(c.log, addr, c.newAddrCh)
+}
+
+// ConnInfo is an active connection, see Connections
+type ConnInfo = stats.ConnInfo
+
+// Connections returns the nodes in the connectors, oldest first
+func (c *Client) Connections() []ConnInfo {
+	now := time.Now()
+	c.mu.Lock()
+	conns := make([]ConnInfo, 0, len(c.inFlight))
+	for n, since := range c.inFlight {
+		in, out := n.Bytes()
+		conns = append(conns, ConnInfo{
+			Endpoint: n.Endpoint(),
+			State:    n.ConnState(),
+			BytesIn:  in,
+			BytesOut: out,
+			Age:      now.Sub(since),
+		})
 	}
-	// shuffle new nodes
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	rnd.Shuffle(len(c.nodesNew), func(i, j int) {
-		c.nodesNew[i], c.nodesNew[j] = c.nodesNew[j], c.nodesNew[i]
+	c.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].Age != conns[j].Age {
+			return conns[i].Age > conns[j].Age
+		}
+		return conns[i].Endpoint < conns[j].Endpoint
 	})
+	return conns
+}
+
+// latest feefilter of the nodes in the connectors and the monitored ones,
+// -1 for the ones that sent none. called under the lock
+func (c *Client) connectedFees() []int64 {
+	fees := make([]int64, 0, len(c.inFlight)+len(c.monitored))
+	for n := range c.inFlight {
+		fee, _ := n.FeeFilter()
+		fees = append(fees, fee)
+	}
+	for _, n := range c.monitored {
+		fee, _ := n.FeeFilter()
+		fees = append(fees, fee)
+	}
+	return fees
+}
+
+func (c *Client) connStarted(n *node.Node) {
+	c.mu.Lock()
+	c.inFlight[n] = time.Now()
 	c.mu.Unlock()
-	c.log.Debugf("[CLIENT]: got %d nodes from %d batch\n", cnt, len(ips))
+}
+
+// connFinished counts the slot time of the node, see SlotTurnover
+func (c *Client) connFinished(n *node.Node) {
+	c.mu.Lock()
+	since := c.inFlight[n]
+	delete(c.inFlight, n)
+	c.mu.Unlock()
+	atomic.AddInt64(&c.connsDone, 1)
+	atomic.AddInt64(&c.connTime, int64(time.Since(since)))
+	if n.TimedOut() == node.TimeoutAddr {
+		atomic.AddInt64(&c.addrTimeouts, 1)
+	}
+}
+
+// SlotTurnover returns the finished connections and their average time in the connector,
+// the whole dial to close, lower with FAST_CRAWL
+func (c *Client) SlotTurnover() (done int64, avg time.Duration) {
+	done = atomic.LoadInt64(&c.connsDone)
+	if done == 0 {
+		return 0, 0
+	}
+	return done, time.Duration(atomic.LoadInt64(&c.connTime) / done)
+}
+
+// nodes waiting in the priority queue
+func (c *Client) queued() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.queueLen()
+}
+
+// called under the lock
+func (c *Client) queueLen() int {
+	if c.spool != nil {
+		return c.queue.pending() + c.spool.Len()
+	}
+	return c.queue.pending()
 }
 
 func (c *Client) ActiveConns() int {
 	return int(atomic.LoadInt32(&c.activeConns))
 }
+
+// Traffic returns the bytes received and sent by the connections of the client
+func (c *Client) Traffic() (in, out uint64) {
+	in, out = node.Traffic()
+	return in - c.trafficStartIn, out - c.trafficStartOut
+}
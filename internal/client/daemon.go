@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+	"github.com/1F47E/go-btc-xray/internal/storage"
+)
+
+// RunDaemon crawls in cycles until the context is canceled.
+// Every cycle is a fresh client limited by cfg.CycleDuration,
+// seeded with the good nodes of the previous cycle plus the DNS seeds.
+// Previous client is dropped so its queue and known nodes can be collected.
+func RunDaemon(ctx context.Context, log logger.Interface, sink stats.Sink) {
+	log = log.With(logger.FieldModule, "daemon")
+	log.Infof("started, cycle %s, interval %s", cfg.CycleDuration, cfg.CycleInterval)
+	defer log.Info("exited")
+
+	// RESUME starts the first cycle like the next ones
+	prevGood := SavedNodes(log)
+	for cycle := 1; ; cycle++ {
+		// the previous good nodes are enough to start, no need to wait for the dns
+		var seeds []Seed
+		var err error
+		if len(prevGood) > 0 {
+			seeds = append(prevGood, SeedNodes(log)...)
+		} else {
+			seeds, err = Bootstrap(ctx, log)
+		}
+		if err != nil {
+			log.Errorf("cycle %d: %v", cycle, err)
+		} else {
+			prevGood = runCycle(ctx, log, sink, cycle, seeds)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Infof("cycle %d done, next in %s", cycle, cfg.CycleInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.CycleInterval):
+		}
+	}
+}
+
+// crawl once, save the results and return the good nodes, the carried ones too with RETENTION
+func runCycle(ctx context.Context, log logger.Interface, sink stats.Sink, cycle int, seeds []Seed) []Seed {
+	cycleCtx, cancel := context.WithTimeout(ctx, cfg.CycleDuration)
+	defer cancel()
+
+	log.Infof("cycle %d started with %d seeds", cycle, len(seeds))
+	c := NewClient(cycleCtx, log, sink)
+	c.AddSeeds(seeds)
+	c.Start()
+	<-c.Done()
+	c.Disconnect()
+
+	now := time.Now()
+	good := newSeeds(OriginPrevious, c.GoodEndpoints())
+	if cfg.Retention > 0 {
+		for i := range good {
+			good[i].LastGood = now
+		}
+	}
+	c.mu.Lock()
+	for _, n := range c.carriedNodes() {
+		good = append(good, Seed{Addr: n.Endpoint, Origin: OriginPrevious, LastGood: n.LastGood})
+	}
+	c.mu.Unlock()
+	c.SaveNodes()
+	s := c.Summary()
+	log.Infof("cycle %d summary: total:%d, good:%d, dead:%d", cycle, s.NodesTotal, s.NodesGood, s.NodesDead)
+	if err := storage.SaveSummary(s); err != nil {
+		log.Errorf("cycle %d: failed to save summary: %v", cycle, err)
+	}
+	if cfg.HistoryFilename != "" {
+		if err := storage.AppendHistory(s); err != nil {
+			log.Errorf("cycle %d: failed to append history: %v", cycle, err)
+		}
+	}
+	return good
+}
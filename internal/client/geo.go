@@ -0,0 +1,39 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/1F47E/go-btc-xray/internal/geo"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+)
+
+// loaded once and shared by the daemon cycles
+var (
+	geoOnce sync.Once
+	geoDB   *geo.DB
+)
+
+// loadGeo returns nil without GEO_FILE and GEO_MMDB or if the files failed to load
+func loadGeo(log logger.Interface) *geo.DB {
+	geoOnce.Do(func() {
+		switch {
+		case len(cfg.GeoMMDB) > 0:
+			db, err := geo.LoadMMDB(cfg.GeoMMDB...)
+			if err != nil {
+				log.Errorf("failed to load geo databases, countries and ASNs are not counted: %v", err)
+				return
+			}
+			log.Infof("geo databases loaded, %d nodes", db.Len())
+			geoDB = db
+		case cfg.GeoFile != "":
+			db, err := geo.Load(cfg.GeoFile)
+			if err != nil {
+				log.Errorf("failed to load geo file, countries and ASNs are not counted: %v", err)
+				return
+			}
+			log.Infof("geo file loaded, %d ranges", db.Len())
+			geoDB = db
+		}
+	})
+	return geoDB
+}
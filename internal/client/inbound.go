@@ -0,0 +1,110 @@
+package client
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/metrics"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// accept the peers on LISTEN_ADDR, at most INBOUND_MAX at once, the others are closed right away.
+// They are not in the connectors so the connections limit of the crawl is not touched
+func (c *Client) wInbound() {
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("INBOUND worker started")
+	defer c.log.Debug("INBOUND worker exited")
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		c.log.Errorf("inbound: failed to listen on %s: %v", cfg.ListenAddr, err)
+		return
+	}
+	c.log.Infof("inbound: listening on %s, max %d peers", ln.Addr(), cfg.InboundMax)
+	go func() {
+		<-c.ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.log.Warnf("inbound: accept failed: %v", err)
+			// too many open files, give the connections some time to close
+			time.Sleep(time.Second)
+			continue
+		}
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil && c.bans.banned(host, time.Now()) {
+			atomic.AddInt32(&c.inboundRefused, 1)
+			c.log.Debugf("inbound: %s refused, banned", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if int(atomic.AddInt32(&c.inboundActive, 1)) > cfg.InboundMax {
+			atomic.AddInt32(&c.inboundActive, -1)
+			atomic.AddInt32(&c.inboundRefused, 1)
+			c.log.Debugf("inbound: %s refused, %d peers connected", conn.RemoteAddr(), cfg.InboundMax)
+			conn.Close()
+			continue
+		}
+		atomic.AddInt32(&c.inboundAccepted, 1)
+		go c.inboundPeer(conn)
+	}
+}
+
+// runs until the peer or the client closes the connection
+func (c *Client) inboundPeer(conn net.Conn) {
+	defer metrics.Track(metrics.RoleConnector)()
+	defer atomic.AddInt32(&c.inboundActive, -1)
+	defer conn.Close()
+	n := node.NewNode(c.log, conn.RemoteAddr().String(), c.newAddrCh)
+	n.SetOrigin(OriginInbound)
+	err := n.Accept(c.ctx, conn, c.goodAddrs)
+	c.strike(n)
+	if err != nil {
+		c.log.Debugf("inbound: %s failed: %v", n.Endpoint(), err)
+		return
+	}
+	if n.IsHandshaked() {
+		atomic.AddInt32(&c.inboundHandshaked, 1)
+	}
+	c.log.Debugf("inbound: %s closed, %s %s", n.Endpoint(), n.UserAgent(), n.Kind())
+}
+
+// good ip nodes in random order for the getaddr of the inbound peers,
+// wire.MaxAddrPerMsg at most. the onions do not fit the v1 addr
+func (c *Client) goodAddrs() []*wire.NetAddress {
+	now := time.Now()
+	c.mu.Lock()
+	addrs := make([]*wire.NetAddress, 0, len(c.nodesGood))
+	for _, n := range c.nodesGood {
+		host, _, _ := net.SplitHostPort(n.EndpointSafe())
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		addrs = append(addrs, wire.NewNetAddressTimestamp(now, n.Services(), ip, n.Port()))
+	}
+	c.mu.Unlock()
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > wire.MaxAddrPerMsg {
+		addrs = addrs[:wire.MaxAddrPerMsg]
+	}
+	return addrs
+}
+
+// InboundCounts returns the peers connected to us, zero without LISTEN_ADDR
+func (c *Client) InboundCounts() stats.InboundCounts {
+	return stats.InboundCounts{
+		Active:     int(atomic.LoadInt32(&c.inboundActive)),
+		Accepted:   int(atomic.LoadInt32(&c.inboundAccepted)),
+		Handshaked: int(atomic.LoadInt32(&c.inboundHandshaked)),
+		Refused:    int(atomic.LoadInt32(&c.inboundRefused)),
+	}
+}
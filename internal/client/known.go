@@ -0,0 +1,94 @@
+package client
+
+import (
+	"container/list"
+	"sync/atomic"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+)
+
+// nodes map entries checked per batch by evictKnown, the protected ones
+// at the back are passed over so a batch never walks the whole map
+const evictScan = 1000
+
+// seenOrder is the nodes map from the least recently announced address,
+// guarded by the client lock
+type seenOrder struct {
+	order *list.List
+	elems map[string]*list.Element
+}
+
+func newSeenOrder() *seenOrder {
+	return &seenOrder{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+// touch moves the address to the front, adds it if new
+func (s *seenOrder) touch(addr string) {
+	if e, ok := s.elems[addr]; ok {
+		s.order.MoveToFront(e)
+		return
+	}
+	s.elems[addr] = s.order.PushFront(addr)
+}
+
+func (s *seenOrder) remove(addr string) {
+	if e, ok := s.elems[addr]; ok {
+		s.order.Remove(e)
+		delete(s.elems, addr)
+	}
+}
+
+// oldest returns false if empty
+func (s *seenOrder) oldest() (string, bool) {
+	e := s.order.Back()
+	if e == nil {
+		return "", false
+	}
+	return e.Value.(string), true
+}
+
+// evictKnown drops the least recently announced nodes over MAX_KNOWN_NODES,
+// the good, queued and dialing ones are never dropped. the rest are the dead,
+// the rejected and the ones never dialed, banned or over MAX_ATTEMPTS.
+// an evicted address announced again is taken as new and dialed again,
+// so it is taken out of the total and the dead counts. called under the lock
+func (c *Client) evictKnown() {
+	if cfg.MaxKnownNodes == 0 {
+		return
+	}
+	for i := 0; i < evictScan && len(c.nodes) > cfg.MaxKnownNodes; i++ {
+		addr, ok := c.seen.oldest()
+		if !ok {
+			return
+		}
+		n, ok := c.nodes[addr]
+		if !ok {
+			c.seen.remove(addr)
+			continue
+		}
+		if !c.evictable(n) {
+			// checked again after a turn of the whole list
+			c.seen.touch(addr)
+			continue
+		}
+		delete(c.nodes, addr)
+		c.seen.remove(addr)
+		c.evicted++
+		c.nodesCnt--
+		if n.IsDead() {
+			atomic.AddInt32(&c.nodesDeadCnt, -1)
+		}
+	}
+}
+
+// called under the lock. the status is written by the connectors without it,
+// the good nodes are the reported ones, an atomic flag
+func (c *Client) evictable(n *node.Node) bool {
+	if _, ok := c.inFlight[n]; ok {
+		return false
+	}
+	if _, ok := c.queue.queued[n]; ok {
+		return false
+	}
+	return !n.Reported()
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// writeRaw writes a message the wire package does not know
+func writeRaw(conn net.Conn, command string, payload []byte) error {
+	var hdr bytes.Buffer
+	_ = binary.Write(&hdr, binary.LittleEndian, uint32(cfg.Btcnet))
+	var cmdBytes [wire.CommandSize]byte
+	copy(cmdBytes[:], command)
+	hdr.Write(cmdBytes[:])
+	_ = binary.Write(&hdr, binary.LittleEndian, uint32(len(payload)))
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	hdr.Write(second[:4])
+	_, err := conn.Write(append(hdr.Bytes(), payload...))
+	return err
+}
+
+// fakePeer answers the version like Core, with the wtxidrelay before the verack
+// and the sendcmpct after it, the rest is read and dropped
+func fakePeer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					msg, _, err := wire.ReadMessage(conn, cfg.Pver, cfg.Btcnet)
+					if err != nil {
+						return
+					}
+					if _, ok := msg.(*wire.MsgVersion); !ok {
+						continue
+					}
+					addr := wire.NewNetAddressIPPort(net.IPv4(127, 0, 0, 1), 8333, 0)
+					version := wire.NewMsgVersion(addr, addr, 1, 0)
+					version.Services = cfg.RequiredServices
+					version.UserAgent = "/fake:0.1/"
+					if wire.WriteMessage(conn, version, cfg.Pver, cfg.Btcnet) != nil ||
+						writeRaw(conn, "wtxidrelay", nil) != nil ||
+						wire.WriteMessage(conn, wire.NewMsgVerAck(), cfg.Pver, cfg.Btcnet) != nil ||
+						writeRaw(conn, "sendcmpct", []byte{0, 2, 0, 0, 0, 0, 0, 0, 0}) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// connect dials the nodes like the connectors until they are reported good
+func connect(t *testing.T, c *Client, nodes []*node.Node) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *node.Node) {
+			defer wg.Done()
+			ctx, cancel := context.WithCancel(context.Background())
+			resCh := make(chan *node.Node, 1)
+			errCh := make(chan error, 1)
+			c.connStarted(n)
+			go func() { errCh <- n.Connect(ctx, resCh) }()
+			select {
+			case <-resCh:
+				c.mu.Lock()
+				c.nodesGood = append(c.nodesGood, n)
+				c.mu.Unlock()
+			case err := <-errCh:
+				t.Errorf("%s: %v", n.Endpoint(), err)
+			}
+			cancel()
+			<-errCh
+			c.connFinished(n)
+		}(n)
+	}
+	wg.Wait()
+}
+
+// pop takes the next queued nodes like the feeder
+func pop(c *Client, count int) []*node.Node {
+	var nodes []*node.Node
+	for i := 0; i < count; i++ {
+		n := c.nextNode()
+		if n == nil {
+			break
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func announce(c *Client, addrs ...string) {
+	c.addAnnounced(node.AddrBatch{Addrs: addrs, From: "10.0.0.1:8333", Origin: "test"})
+}
+
+// a crawl of 3000 addresses over MAX_KNOWN_NODES of 500, most of them are dialed
+// and dead, the good, dialing and queued ones are kept
+func TestEvictKnownStream(t *testing.T) {
+	defer func(limit int, dataDir string) {
+		cfg.MaxKnownNodes, cfg.DataDir = limit, dataDir
+	}(cfg.MaxKnownNodes, cfg.DataDir)
+	const limit = 500
+	cfg.MaxKnownNodes, cfg.DataDir = limit, t.TempDir()
+
+	c := NewClient(context.Background(), nopLogger{}, nil)
+	defer c.exit()
+	c.queue = newNodeQueue(config.QueueSortFIFO)
+
+	// the first ones found are good
+	var goodAddrs []string
+	for i := 0; i < 3; i++ {
+		goodAddrs = append(goodAddrs, fakePeer(t))
+	}
+	announce(c, goodAddrs...)
+	good := pop(c, len(goodAddrs))
+	connect(t, c, good)
+	for _, n := range good {
+		if !n.Reported() {
+			t.Fatalf("%s is not reported good", n.Endpoint())
+		}
+	}
+	// dialing during the whole stream
+	announce(c, "10.1.0.1:8333", "10.1.0.2:8333")
+	dialing := pop(c, 2)
+	for _, n := range dialing {
+		c.connStarted(n)
+	}
+	// dead and announced again on every batch
+	announce(c, "10.2.0.1:8333")
+	fresh := pop(c, 1)[0]
+
+	const batches, batchSize, dialed = 30, 100, 90
+	for b := 0; b < batches; b++ {
+		addrs := make([]string, batchSize)
+		for i := range addrs {
+			addrs[i] = fmt.Sprintf("10.3.%d.%d:8333", b, i)
+		}
+		announce(c, addrs...)
+		announce(c, fresh.Endpoint())
+		// the rest stay queued
+		pop(c, dialed)
+
+		c.mu.Lock()
+		known := len(c.nodes)
+		c.mu.Unlock()
+		if total := len(goodAddrs) + 3 + (b+1)*batchSize; total > limit && known != limit {
+			t.Fatalf("batch %d: %d known, want %d", b, known, limit)
+		}
+	}
+
+	// the least recently announced go first
+	c.mu.Lock()
+	kept := c.nodes[fresh.Endpoint()] == fresh
+	c.mu.Unlock()
+	if !kept {
+		t.Errorf("%s announced on every batch and evicted", fresh.Endpoint())
+	}
+
+	// queued over the cap, only the dead are evicted
+	addrs := make([]string, limit)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("10.4.%d.%d:8333", i/256, i%256)
+	}
+	announce(c, addrs...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	protected := len(good) + len(dialing) + len(c.queue.queued)
+	if len(c.nodes) != protected {
+		t.Errorf("%d known, want the %d good, dialing and queued", len(c.nodes), protected)
+	}
+	for _, n := range append(append([]*node.Node(nil), good...), dialing...) {
+		if c.nodes[n.Endpoint()] != n {
+			t.Errorf("%s evicted", n.Endpoint())
+		}
+	}
+	for n := range c.queue.queued {
+		if c.nodes[n.Endpoint()] != n {
+			t.Errorf("queued %s evicted", n.Endpoint())
+		}
+	}
+	if queued := len(c.queue.queued); queued != batches*(batchSize-dialed)+limit {
+		t.Errorf("%d queued, want %d", queued, batches*(batchSize-dialed)+limit)
+	}
+	added := len(goodAddrs) + 3 + batches*batchSize + limit
+	if c.evicted != int64(added-len(c.nodes)) {
+		t.Errorf("%d evicted, want %d", c.evicted, added-len(c.nodes))
+	}
+	if len(c.seen.elems) != len(c.nodes) || c.seen.order.Len() != len(c.nodes) {
+		t.Errorf("seen order of %d for %d known", c.seen.order.Len(), len(c.nodes))
+	}
+	if c.nodesCnt != len(c.nodes) {
+		t.Errorf("total %d for %d known", c.nodesCnt, len(c.nodes))
+	}
+}
+
+// an evicted address gossiped again is new and queued for the dial
+func TestEvictKnownAnnouncedAgain(t *testing.T) {
+	defer func(limit int, dataDir string) {
+		cfg.MaxKnownNodes, cfg.DataDir = limit, dataDir
+	}(cfg.MaxKnownNodes, cfg.DataDir)
+	cfg.MaxKnownNodes, cfg.DataDir = 10, t.TempDir()
+
+	c := NewClient(context.Background(), nopLogger{}, nil)
+	defer c.exit()
+	c.queue = newNodeQueue(config.QueueSortFIFO)
+
+	announce(c, "10.0.1.1:8333")
+	first := pop(c, 1)[0]
+	for i := 0; i < 10; i++ {
+		announce(c, fmt.Sprintf("10.0.2.%d:8333", i))
+	}
+	c.mu.Lock()
+	_, known := c.nodes[first.Endpoint()]
+	c.mu.Unlock()
+	if known {
+		t.Fatal("the oldest dead node is not evicted")
+	}
+
+	// dialed, the oldest of them is evicted for the one announced again
+	pop(c, 10)
+	announce(c, first.Endpoint())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	again, ok := c.nodes[first.Endpoint()]
+	if !ok {
+		t.Fatal("announced again and not known")
+	}
+	if again == first {
+		t.Error("the evicted node is reused")
+	}
+	if _, ok := c.queue.queued[again]; !ok {
+		t.Error("announced again and not queued")
+	}
+	if len(c.nodes) != 10 {
+		t.Errorf("%d known, want 10", len(c.nodes))
+	}
+	// counted once, not again after the eviction
+	if c.nodesCnt != 10 {
+		t.Errorf("total %d, want 10", c.nodesCnt)
+	}
+	if _, ok := c.nodes["10.0.2.0:8333"]; ok {
+		t.Error("the oldest dialed node is not evicted")
+	}
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/metrics"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+)
+
+// good nodes per monitored peer found before the first pick,
+// picking right away would keep the first good nodes instead of the fastest
+const monitorPool = 10
+
+// keep MONITOR fastest good nodes connected, a dropped peer is replaced with the next
+// fastest one. The crawl is paused once all the peers are up and resumed with Refresh,
+// every MONITOR_REFRESH or when there is no candidate left
+func (c *Client) wMonitor() {
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("MONITOR worker started")
+	defer c.log.Debug("MONITOR worker exited")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var refresh <-chan time.Time
+	if cfg.MonitorRefresh > 0 {
+		refreshTicker := time.NewTicker(cfg.MonitorRefresh)
+		defer refreshTicker.Stop()
+		refresh = refreshTicker.C
+	}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-refresh:
+			c.Refresh()
+		case <-ticker.C:
+			drained := c.queued() == 0 && len(c.queueCh) == 0 && len(c.newAddrCh) == 0 && c.ActiveConns() == 0
+			c.mu.Lock()
+			c.pickMonitored(drained)
+			c.pauseCrawl(drained)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// connect the fastest good nodes to fill the monitor set,
+// nodes without a pong go last. called under the lock
+func (c *Client) pickMonitored(drained bool) {
+	missing := cfg.Monitor - len(c.monitored)
+	if missing <= 0 {
+		return
+	}
+	candidates := make([]*node.Node, 0, len(c.nodesGood))
+	for _, n := range c.nodesGood {
+		if _, ok := c.monitored[n.Endpoint()]; ok {
+			continue
+		}
+		if _, ok := c.monitorTried[n.Endpoint()]; ok {
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+	if !c.monitorStarted && !drained && len(candidates) < cfg.Monitor*monitorPool {
+		return
+	}
+	if len(candidates) == 0 {
+		c.resumeCrawl("no monitor candidates left")
+		return
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := candidates[i].RTT(), candidates[j].RTT()
+		if ri == rj {
+			return false
+		}
+		return rj == 0 || (ri != 0 && ri < rj)
+	})
+	c.monitorStarted = true
+	for _, n := range candidates {
+		if missing == 0 {
+			break
+		}
+		// the same endpoint may be good twice, see reachable
+		endpoint := n.Endpoint()
+		if _, ok := c.monitored[endpoint]; ok {
+			continue
+		}
+		peer := node.NewNode(c.log, n.EndpointSafe(), c.newAddrCh)
+		peer.Keep()
+		peer.SetDepth(n.Depth())
+		peer.SetOrigin(n.Origin())
+		c.monitored[endpoint] = peer
+		missing--
+		c.log.Infof("monitor: connecting %s, ping %s", endpoint, n.RTT().Round(time.Millisecond))
+		go c.monitorPeer(endpoint, peer)
+	}
+}
+
+// runs until the peer is gone, the next tick picks the replacement
+func (c *Client) monitorPeer(endpoint string, n *node.Node) {
+	defer metrics.Track(metrics.RoleConnector)()
+	// Connect sends the node once the handshake is done, buffered to not block
+	up := make(chan *node.Node, 1)
+	err := n.Connect(c.ctx, up)
+	c.mu.Lock()
+	delete(c.monitored, endpoint)
+	c.monitorTried[endpoint] = struct{}{}
+	if len(up) > 0 {
+		c.monitorDrops++
+	}
+	c.mu.Unlock()
+	switch {
+	case c.ctx.Err() != nil:
+	case len(up) > 0:
+		c.log.Warnf("monitor: %s dropped, replacing", endpoint)
+	default:
+		c.log.Warnf("monitor: %s failed: %v, replacing", endpoint, err)
+	}
+}
+
+// stop the connectors once all the monitored peers are up,
+// a refresh keeps them until the queue is drained. called under the lock
+func (c *Client) pauseCrawl(drained bool) {
+	if c.refreshing && drained {
+		c.refreshing = false
+		c.log.Info("monitor: refresh done")
+	}
+	up := 0
+	for _, n := range c.monitored {
+		if n.Uptime() > 0 {
+			up++
+		}
+	}
+	if c.crawlPaused || c.refreshing || up < cfg.Monitor {
+		return
+	}
+	c.crawlPaused = true
+	c.resize(0)
+	c.log.Infof("monitor: %d peers up, crawl paused", up)
+}
+
+// called under the lock
+func (c *Client) resumeCrawl(reason string) {
+	if !c.crawlPaused {
+		return
+	}
+	c.crawlPaused = false
+	c.resize(c.connMax)
+	c.log.Infof("monitor: crawl resumed, %s", reason)
+}
+
+// Refresh resumes the crawl paused by the monitor until the queue is drained,
+// the queue has the addresses gossiped by the peers meanwhile.
+// The peers that failed or dropped can be picked again
+func (c *Client) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.monitorTried = make(map[string]struct{})
+	c.refreshing = true
+	c.resumeCrawl("refresh")
+}
+
+// Monitored returns the peers kept by the monitor, fastest first
+func (c *Client) Monitored() []stats.MonitorInfo {
+	c.mu.Lock()
+	peers := make([]stats.MonitorInfo, 0, len(c.monitored))
+	for endpoint, n := range c.monitored {
+		// -1 if none came
+		fee, _ := n.FeeFilter()
+		info := stats.MonitorInfo{
+			Endpoint:  endpoint,
+			RTT:       n.RTT(),
+			Uptime:    n.Uptime(),
+			FeeFilter: fee,
+		}
+		if info.Uptime > 0 {
+			info.InvRate = float64(n.InvCount()) / info.Uptime.Minutes()
+		}
+		peers = append(peers, info)
+	}
+	c.mu.Unlock()
+	sort.Slice(peers, func(i, j int) bool {
+		ri, rj := peers[i].RTT, peers[j].RTT
+		if ri != rj {
+			return rj == 0 || (ri != 0 && ri < rj)
+		}
+		return peers[i].Endpoint < peers[j].Endpoint
+	})
+	return peers
+}
@@ -0,0 +1,126 @@
+package node
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/cmd"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ChainStatus is the answer to getheaders from CHAIN_CHECKPOINT, see verifyChain
+type ChainStatus string
+
+const (
+	// headers connect to the checkpoint and to each other with a valid proof of work
+	ChainVerified ChainStatus = "verified"
+	// headers from the checkpoint that do not link or with a bad proof of work
+	ChainFork ChainStatus = "fork"
+	// headers after another block, the node does not know the checkpoint and answers
+	// from the genesis. still syncing below it or on a fork before it, can't tell
+	ChainUnknown ChainStatus = "unknown"
+	// no headers in HANDSHAKE_TIMEOUT, or none after the checkpoint
+	ChainUnanswered ChainStatus = "unanswered"
+)
+
+// verifyChain asks for the headers after the checkpoint before the node is reported,
+// one more round trip per node
+func (n *Node) verifyChain(ctx context.Context) {
+	err := n.send(func(conn net.Conn) error { return cmd.SendGetHeaders(conn, cfg.ChainCheckpoint) })
+	if err != nil {
+		n.log.Errorf("failed to write getheaders: %v", err)
+		n.chain = ChainUnanswered
+		return
+	}
+	timer := time.NewTimer(cfg.HandshakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-n.listenDone:
+		n.chain = ChainUnanswered
+	case <-timer.C:
+		n.chain = ChainUnanswered
+	case headers := <-n.headersCh:
+		n.chain = checkHeaders(headers, cfg.ChainCheckpoint)
+	}
+	n.log.Debugf("chain: %s", n.chain)
+}
+
+// Chain is empty without CHAIN_CHECKPOINT or before the answer
+func (n *Node) Chain() ChainStatus {
+	return n.chain
+}
+
+// every header must link to the previous one starting from the checkpoint
+// and its hash must meet the target of its bits
+func checkHeaders(headers []*wire.BlockHeader, checkpoint *chainhash.Hash) ChainStatus {
+	if len(headers) == 0 {
+		return ChainUnanswered
+	}
+	if headers[0].PrevBlock != *checkpoint {
+		return ChainUnknown
+	}
+	prev := *checkpoint
+	for _, h := range headers {
+		if h.PrevBlock != prev {
+			return ChainFork
+		}
+		hash := h.BlockHash()
+		if !checkPoW(hash, h.Bits) {
+			return ChainFork
+		}
+		prev = hash
+	}
+	return ChainVerified
+}
+
+func checkPoW(hash chainhash.Hash, bits uint32) bool {
+	target := compactToBig(bits)
+	if target.Sign() <= 0 {
+		return false
+	}
+	if limit := powLimit(); limit != nil && target.Cmp(limit) > 0 {
+		return false
+	}
+	// the hash bytes are little endian
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return big.NewInt(0).SetBytes(hash[:]).Cmp(target) <= 0
+}
+
+// the compact target format of the header bits, negative targets are returned as is
+func compactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+	var target *big.Int
+	if exponent <= 3 {
+		target = big.NewInt(int64(mantissa >> (8 * (3 - exponent))))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if compact&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// nil for a custom MAGIC, only the bits are checked
+func powLimit() *big.Int {
+	switch cfg.Btcnet {
+	case wire.MainNet:
+		return chaincfg.MainNetParams.PowLimit
+	case wire.TestNet3:
+		return chaincfg.TestNet3Params.PowLimit
+	case wire.TestNet:
+		return chaincfg.RegressionNetParams.PowLimit
+	}
+	return nil
+}
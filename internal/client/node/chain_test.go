@@ -0,0 +1,53 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// regtest headers after prev, mined to the regtest limit
+func testHeaders(prev chainhash.Hash, count int) []*wire.BlockHeader {
+	headers := make([]*wire.BlockHeader, count)
+	for i := range headers {
+		h := wire.NewBlockHeader(1, &prev, &chainhash.Hash{}, 0x207fffff, 0)
+		h.Timestamp = time.Unix(1700000000+int64(i), 0)
+		for !checkPoW(h.BlockHash(), h.Bits) {
+			h.Nonce++
+		}
+		headers[i] = h
+		prev = h.BlockHash()
+	}
+	return headers
+}
+
+func TestCheckHeaders(t *testing.T) {
+	defer func(net wire.BitcoinNet) { cfg.Btcnet = net }(cfg.Btcnet)
+	cfg.Btcnet = wire.TestNet
+	checkpoint := chainhash.Hash{1}
+	unlinked := testHeaders(checkpoint, 3)
+	unlinked[2].PrevBlock = chainhash.Hash{2}
+	badPoW := testHeaders(checkpoint, 2)
+	badPoW[1].Bits = 0x1d00ffff
+	tests := []struct {
+		name    string
+		headers []*wire.BlockHeader
+		want    ChainStatus
+	}{
+		{"linked", testHeaders(checkpoint, 3), ChainVerified},
+		{"none", nil, ChainUnanswered},
+		// answered from the genesis, the node does not have the checkpoint
+		{"after another block", testHeaders(chainhash.Hash{3}, 3), ChainUnknown},
+		{"broken link", unlinked, ChainFork},
+		{"bad proof of work", badPoW, ChainFork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkHeaders(tt.headers, &checkpoint); got != tt.want {
+				t.Errorf("checkHeaders = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/cmd"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ErrUnconfirmed is returned by Connect for the nodes that failed a CONFIRM_PROBES handshake
+var ErrUnconfirmed = errors.New("unconfirmed")
+
+// nodes that failed a confirmation handshake
+var unconfirmed uint64
+
+// Unconfirmed returns the nodes dropped by CONFIRM_PROBES
+func Unconfirmed() uint64 {
+	return atomic.LoadUint64(&unconfirmed)
+}
+
+// confirmReachable does CONFIRM_PROBES more handshakes from scratch before the node is reported,
+// a node that accepted the first connection by chance fails one of them
+func (n *Node) confirmReachable(ctx context.Context) error {
+	for i := 0; i < cfg.ConfirmProbes; i++ {
+		if err := n.confirm(ctx); err != nil {
+			atomic.AddUint64(&unconfirmed, 1)
+			n.log.Debugf("unconfirmed after %d handshakes: %v", n.Confirmations(), err)
+			return fmt.Errorf("%w: %v", ErrUnconfirmed, err)
+		}
+		atomic.AddInt32(&n.confirmations, 1)
+	}
+	return nil
+}
+
+// confirm is a version and verack exchange on a separate connection,
+// closed right after. the first messages of the peer besides those are skipped,
+// the unknown ones too (wtxidrelay, sendcmpct)
+func (n *Node) confirm(ctx context.Context) error {
+	conn, err := dial(ctx, n.log, n.EndpointSafe())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn = &countingConn{Conn: conn}
+	_ = conn.SetDeadline(time.Now().Add(cfg.HandshakeTimeout))
+
+	nonce := newNonce()
+	defer releaseNonce(nonce)
+	if err := cmd.SendVersion(conn, nonce); err != nil {
+		return fmt.Errorf("failed to write version: %w", err)
+	}
+	var version, verack bool
+	for !version || !verack {
+		_, msg, _, err := wire.ReadMessageN(conn, cmd.OurVersion(), cfg.Btcnet)
+		if err != nil {
+			if errors.Is(err, wire.ErrUnknownMessage) || recoverableReadErr(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read: %w", err)
+		}
+		switch msg.(type) {
+		case *wire.MsgVersion:
+			version = true
+			if err := cmd.SendVerAck(conn); err != nil {
+				return fmt.Errorf("failed to write verack: %w", err)
+			}
+		case *wire.MsgVerAck:
+			verack = true
+		}
+	}
+	return nil
+}
+
+// Confirmations is the successful handshakes of the last connect,
+// 1 plus the CONFIRM_PROBES ones for a good node
+func (n *Node) Confirmations() int32 {
+	return atomic.LoadInt32(&n.confirmations)
+}
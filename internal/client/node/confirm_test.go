@@ -0,0 +1,21 @@
+package node
+
+import (
+	"context"
+	"testing"
+)
+
+// the confirmation handshakes skip the unknown messages of a recent peer
+func TestConfirmSkipsUnknownMessages(t *testing.T) {
+	addr := corePeer(t, 42, make(chan struct{}, 1))
+	defer func(probes int) { cfg.ConfirmProbes = probes }(cfg.ConfirmProbes)
+	cfg.ConfirmProbes = 2
+
+	n := NewNode(testLogger{t: t}, addr, make(chan AddrBatch, 16))
+	if err := n.confirmReachable(context.Background()); err != nil {
+		t.Fatalf("confirmReachable: %v", err)
+	}
+	if got := n.Confirmations(); got != 2 {
+		t.Errorf("confirmations = %d, want 2", got)
+	}
+}
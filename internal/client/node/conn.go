@@ -0,0 +1,89 @@
+package node
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/1F47E/go-btc-xray/internal/cmd"
+)
+
+// bytes read and written by all the connections, the probes included
+var trafficIn, trafficOut uint64
+
+// Traffic returns the total bytes received and sent by all the nodes
+func Traffic() (in, out uint64) {
+	return atomic.LoadUint64(&trafficIn), atomic.LoadUint64(&trafficOut)
+}
+
+// countingConn counts the bytes of the current connection of the node and the totals,
+// only the totals for the probe connections without a node
+type countingConn struct {
+	net.Conn
+	n *Node
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	cnt, err := c.Conn.Read(b)
+	atomic.AddUint64(&trafficIn, uint64(cnt))
+	if c.n != nil {
+		atomic.AddUint64(&c.n.bytesIn, uint64(cnt))
+	}
+	return cnt, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	cnt, err := c.Conn.Write(b)
+	atomic.AddUint64(&trafficOut, uint64(cnt))
+	if c.n != nil {
+		atomic.AddUint64(&c.n.bytesOut, uint64(cnt))
+	}
+	return cnt, err
+}
+
+// ProtocolVersion is the one negotiated by the node, ours for the probes, see cmd.Versioned
+func (c *countingConn) ProtocolVersion() uint32 {
+	if c.n != nil {
+		return c.n.negotiatedVersion()
+	}
+	return cmd.OurVersion()
+}
+
+// the messages of the connection are encoded with the lower of ours and the peer version
+func (n *Node) negotiatedVersion() uint32 {
+	return atomic.LoadUint32(&n.pver)
+}
+
+// negotiate lowers the version of the connection to the one of the peer
+func (n *Node) negotiate(peer int32) {
+	if peer > 0 && uint32(peer) < n.negotiatedVersion() {
+		atomic.StoreUint32(&n.pver, uint32(peer))
+	}
+}
+
+// Bytes read and written on the current or the last connection
+func (n *Node) Bytes() (in, out uint64) {
+	return atomic.LoadUint64(&n.bytesIn), atomic.LoadUint64(&n.bytesOut)
+}
+
+// connection states for the diagnostics, see ConnState
+const (
+	ConnDialing     = "dialing"
+	ConnHandshake   = "handshake"
+	ConnEstablished = "established"
+	ConnClosed      = "closed"
+)
+
+// ConnState is a best effort view of the connection for the diagnostics
+func (n *Node) ConnState() string {
+	st := n.loadStatus()
+	switch {
+	case st == connecting:
+		return ConnDialing
+	case st == connected && n.IsHandshaked():
+		return ConnEstablished
+	case st == connected:
+		return ConnHandshake
+	default:
+		return ConnClosed
+	}
+}
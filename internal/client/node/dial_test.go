@@ -0,0 +1,95 @@
+//go:build linux
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// blackhole returns a loopback address that never answers the syn: a listener
+// with a full accept queue, linux drops the syns then. closed with the test
+func blackhole(t *testing.T) string {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+	// never accepted, fill the queue until a dial hangs
+	for i := 0; i < 8; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			return addr
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	t.Skip("the accept queue is not full after 8 connections")
+	return ""
+}
+
+func TestConnectDialTimeout(t *testing.T) {
+	defer func(timeout time.Duration, probe bool) {
+		cfg.DialTimeout, cfg.V2Probe = timeout, probe
+	}(cfg.DialTimeout, cfg.V2Probe)
+	cfg.DialTimeout, cfg.V2Probe = 500*time.Millisecond, false
+
+	n := NewNode(testLogger{t: t}, blackhole(t), make(chan AddrBatch))
+	start := time.Now()
+	err := n.Connect(context.Background(), make(chan *Node))
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatal("connected to a blackholed address")
+	}
+	if n.DialErr() != DialErrTimeout {
+		t.Errorf("dial error = %q, want %q: %v", n.DialErr(), DialErrTimeout, err)
+	}
+	if n.TimedOut() != TimeoutDial {
+		t.Errorf("timed out = %q, want %q", n.TimedOut(), TimeoutDial)
+	}
+	if !n.IsDead() {
+		t.Error("node is not dead")
+	}
+	if elapsed < cfg.DialTimeout || elapsed > cfg.DialTimeout+time.Second {
+		t.Errorf("connect returned after %s, want %s", elapsed, cfg.DialTimeout)
+	}
+}
+
+// the nodes of the other family than LOCAL_ADDR are not dialed unbound
+func TestConnectLocalAddrFamily(t *testing.T) {
+	defer func(local string, probe bool) {
+		cfg.LocalAddr, cfg.V2Probe = local, probe
+		localIPOnce, localIP = sync.Once{}, nil
+	}(cfg.LocalAddr, cfg.V2Probe)
+	cfg.LocalAddr, cfg.V2Probe = "127.0.0.1", false
+	localIPOnce, localIP = sync.Once{}, nil
+
+	n := NewNode(testLogger{t: t}, "[::1]:8333", make(chan AddrBatch))
+	err := n.Connect(context.Background(), make(chan *Node))
+	if !errors.Is(err, ErrLocalAddrFamily) {
+		t.Fatalf("connect error = %v, want %v", err, ErrLocalAddrFamily)
+	}
+	if n.DialErr() != DialErrFamily {
+		t.Errorf("dial error = %q, want %q", n.DialErr(), DialErrFamily)
+	}
+	if n.DeadReason(err) != DeadUnroutable {
+		t.Errorf("dead reason = %q, want %q", n.DeadReason(err), DeadUnroutable)
+	}
+}
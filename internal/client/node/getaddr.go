@@ -0,0 +1,140 @@
+package node
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/cmd"
+	"github.com/1F47E/go-btc-xray/internal/jitter"
+)
+
+// smaller addr messages are the self announcements of the node
+// or of its peers, not the answers to getaddr
+const minAddrAnswer = 10
+
+// repeatGetAddr keeps the connection and asks for more addresses every GETADDR_INTERVAL
+// until GETADDR_MAX requests, the yield drops below GETADDR_MIN_YIELD or the node is gone.
+// Every answer is a random sample of the addrman of the node, see addrAnswered
+func (n *Node) repeatGetAddr(ctx context.Context) {
+	defer n.Disconnect()
+	// pongs keep the read deadline away between the requests
+	keepalive := time.NewTicker(cfg.MsgReadTimeout / 2)
+	defer keepalive.Stop()
+	for int(atomic.LoadInt32(&n.getAddrs)) < cfg.GetAddrMax {
+		timer := time.NewTimer(jitter.Apply(cfg.GetAddrInterval))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-n.listenDone:
+				timer.Stop()
+				return
+			case <-keepalive.C:
+				if err := n.Ping(); err != nil {
+					timer.Stop()
+					return
+				}
+			case <-n.addrAnswer:
+				if atomic.LoadInt32(&n.addrExhausted) == 1 {
+					timer.Stop()
+					n.log.Debugf("getaddr: exhausted after %d requests", atomic.LoadInt32(&n.getAddrs))
+					return
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+		if err := n.send(cmd.SendGetAddr); err != nil {
+			n.log.Errorf("failed to write getaddr: %v", err)
+			return
+		}
+		atomic.AddInt32(&n.getAddrs, 1)
+	}
+	// the last answer
+	select {
+	case <-ctx.Done():
+	case <-n.listenDone:
+	case <-n.addrAnswer:
+	case <-time.After(cfg.PingTimeout):
+	}
+	n.log.Debugf("getaddr: done after %d requests", atomic.LoadInt32(&n.getAddrs))
+}
+
+// addrAnswered counts the addresses never sent by this node before,
+// the node is exhausted once the share of the new ones is below GETADDR_MIN_YIELD
+func (n *Node) addrAnswered(addrs []string) {
+	if len(addrs) < minAddrAnswer {
+		return
+	}
+	if n.addrSeen == nil {
+		n.addrSeen = make(map[uint64]struct{}, len(addrs))
+	}
+	fresh := 0
+	for _, a := range addrs {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(a))
+		key := h.Sum64()
+		if _, ok := n.addrSeen[key]; !ok {
+			n.addrSeen[key] = struct{}{}
+			fresh++
+		}
+	}
+	requests := atomic.LoadInt32(&n.getAddrs)
+	yield := float64(fresh) / float64(len(addrs))
+	n.log.Debugf("getaddr %d: %d addresses, %d new, yield %.0f%%", requests, len(addrs), fresh, yield*100)
+	if requests > 1 && yield < cfg.GetAddrMinYield {
+		atomic.StoreInt32(&n.addrExhausted, 1)
+	}
+	select {
+	case n.addrAnswer <- struct{}{}:
+	default:
+	}
+}
+
+// afterAddr closes the connection after the addr message was passed to the client,
+// one answer is enough unless the getaddr is repeated
+func (n *Node) afterAddr(batch []string) {
+	switch {
+	case n.keep, n.inbound:
+		// gossip of the monitored and the inbound peers, the connection stays
+	case cfg.FastCrawl:
+		// self announcements keep the connection until the answer or FAST_TIMEOUT
+		if len(batch) >= cfg.FastMinAddrs {
+			n.Disconnect()
+		}
+	case cfg.GetAddrInterval == 0:
+		n.Disconnect()
+	default:
+		n.addrAnswered(batch)
+	}
+}
+
+// fastGetAddr asks for the addresses right after the handshake and frees the connector
+// on the first answer of FAST_MIN_ADDRS or after FAST_TIMEOUT, the node is already good.
+// The ping is sent without waiting, the latency is known only if the pong comes first
+func (n *Node) fastGetAddr(ctx context.Context) error {
+	defer n.Disconnect()
+	if err := n.send(cmd.SendGetAddr); err != nil {
+		n.log.Errorf("failed to write getaddr: %v", err)
+		return nil
+	}
+	atomic.StoreInt32(&n.getAddrs, 1)
+	if err := n.Ping(); err != nil {
+		n.log.Errorf("failed to write ping: %v", err)
+		return nil
+	}
+	timer := time.NewTimer(cfg.FastTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-n.listenDone:
+	case <-timer.C:
+		n.timedOut = TimeoutAddr
+		n.log.Debugf("no addresses in %s, closing", cfg.FastTimeout)
+	}
+	return nil
+}
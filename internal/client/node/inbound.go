@@ -0,0 +1,92 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/cmd"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Accept does the handshake as the responder on a connection from the peer, see LISTEN_ADDR,
+// and blocks until the peer or ctx closes it. the addr gossip of the peer goes to the client
+// like from the crawled nodes and getaddr is answered with addrSource.
+// returning error here means the handshake failed
+func (n *Node) Accept(ctx context.Context, conn net.Conn, addrSource func() []*wire.NetAddress) error {
+	n.inbound = true
+	n.addrSource = addrSource
+	n.log.Debug("accepted")
+	defer func() {
+		n.setConn(nil)
+		n.log.Debug("closed")
+	}()
+	atomic.StoreUint32(&n.pver, cmd.OurVersion())
+	conn = &countingConn{Conn: conn, n: n}
+	atomic.StoreInt64(&n.lastRecv, time.Now().UnixNano())
+	n.addrAnswer = make(chan struct{}, 1)
+	n.versionNonce = newNonce()
+	defer releaseNonce(n.versionNonce)
+	handshakeDeadline := time.Now().Add(cfg.HandshakeTimeout)
+	_ = conn.SetWriteDeadline(handshakeDeadline)
+	n.setConn(conn)
+	n.setStatus(connected)
+	n.handshakeCh = make(chan struct{})
+	n.listenDone = make(chan struct{})
+	go n.listen(ctx, conn)
+
+	// the peer sent its version first or is about to, ours go right away
+	n.ourUserAgent = cfg.UserAgent
+	err := n.send(func(conn net.Conn) error { return cmd.SendVersion(conn, n.versionNonce) })
+	if err != nil {
+		return n.handshakeFailed(conn, fmt.Errorf("failed to write version: %v", err))
+	}
+	if err := n.send(cmd.SendAddrV2); err != nil {
+		return n.handshakeFailed(conn, fmt.Errorf("failed to write sendaddrv2: %v", err))
+	}
+	if err := n.send(cmd.SendVerAck); err != nil {
+		return n.handshakeFailed(conn, fmt.Errorf("failed to write verack: %v", err))
+	}
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	handshakeTimer := time.NewTimer(time.Until(handshakeDeadline))
+	defer handshakeTimer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-n.handshakeCh:
+	case <-n.listenDone:
+		return n.handshakeFailed(conn, fmt.Errorf("closed during the handshake"))
+	case <-handshakeTimer.C:
+		n.timedOut = TimeoutHandshake
+		return n.handshakeFailed(conn, fmt.Errorf("handshake timeout after %s", cfg.HandshakeTimeout))
+	}
+	n.log.Debug("inbound handshake done")
+
+	// the listener answers the pings and getaddr until the peer is gone
+	select {
+	case <-ctx.Done():
+	case <-n.listenDone:
+	}
+	return nil
+}
+
+// Inbound is true for the peers connected to us
+func (n *Node) Inbound() bool {
+	return n.inbound
+}
+
+// addr with the good nodes of the crawl, v1 only so the onions are skipped
+func (n *Node) answerGetAddr() {
+	addrs := n.addrSource()
+	if len(addrs) > wire.MaxAddrPerMsg {
+		addrs = addrs[:wire.MaxAddrPerMsg]
+	}
+	n.log.Debugf("sending addr with %d addresses\n", len(addrs))
+	if err := n.send(func(conn net.Conn) error { return cmd.SendAddr(conn, addrs) }); err != nil {
+		n.log.Errorf("failed to write addr: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package node
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Keep makes Connect stay connected after the handshake until the node
+// or the context closes it, the addresses gossiped by the node are still sent
+func (n *Node) Keep() {
+	n.keep = true
+}
+
+// keepAlive pings the node so the latency stays fresh and the read deadline away,
+// returns after the connection is closed
+func (n *Node) keepAlive(ctx context.Context) error {
+	defer n.Disconnect()
+	atomic.StoreInt64(&n.establishedAt, time.Now().UnixNano())
+	defer atomic.StoreInt64(&n.establishedAt, 0)
+	n.log.Debug("keeping the connection")
+	if err := n.Ping(); err != nil {
+		n.log.Errorf("failed to write ping: %v", err)
+		return nil
+	}
+	ticker := time.NewTicker(cfg.MsgReadTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-n.listenDone:
+			return nil
+		case <-ticker.C:
+			if err := n.Ping(); err != nil {
+				n.log.Errorf("failed to write ping: %v", err)
+				return nil
+			}
+		}
+	}
+}
+
+// Uptime of the kept connection, zero if not connected
+func (n *Node) Uptime() time.Duration {
+	at := atomic.LoadInt64(&n.establishedAt)
+	if at == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, at))
+}
+
+// FeeFilter returns the latest BIP133 min fee rate of the node in sat/kvB,
+// false if the node never sent one
+func (n *Node) FeeFilter() (int64, bool) {
+	fee := atomic.LoadInt64(&n.feeFilter)
+	return fee, fee >= 0
+}
@@ -2,128 +2,338 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/1F47E/go-btc-xray/internal/cmd"
+	"github.com/1F47E/go-btc-xray/internal/metrics"
+
 	"github.com/btcsuite/btcd/wire"
 )
 
+// ReadMessageN discards the payload of the bad messages
+// except when the header is from another network or the payload is over the limit,
+// those leave the stream out of sync. Non wire errors are io errors.
+func recoverableReadErr(err error) bool {
+	var msgErr *wire.MessageError
+	if !errors.As(err, &msgErr) {
+		return false
+	}
+	if msgErr.Func != "ReadMessage" {
+		// payload decode error
+		return true
+	}
+	return !strings.HasPrefix(msgErr.Description, "message from other network") &&
+		!strings.HasPrefix(msgErr.Description, "message payload is too large")
+}
+
+// sendAddrs waits for the client to take the batch, the reads of the node stall
+// meanwhile so a full channel is counted for ADDR_CH_SIZE. false on exit
+func (n *Node) sendAddrs(ctx context.Context, batch AddrBatch) bool {
+	select {
+	case n.newAddrCh <- batch:
+		return true
+	default:
+	}
+	metrics.ChannelFull(metrics.ChanAddrs)
+	select {
+	case <-ctx.Done():
+		return false
+	case n.newAddrCh <- batch:
+		return true
+	}
+}
+
+// drop the addresses the peer last saw over ADDR_MAX_AGE ago, the addrman of the peers
+// keeps long gone nodes for weeks. the whole answer still counts for afterAddr
+func (n *Node) newAddrBatch(addrs []string, seen []time.Time) AddrBatch {
+	now := time.Now()
+	batch := AddrBatch{From: n.Endpoint(), Addrs: addrs, Advertised: seen, At: now, Depth: n.Depth() + 1, Origin: n.origin}
+	if cfg.AddrMaxAge == 0 {
+		return batch
+	}
+	batch.Addrs = make([]string, 0, len(addrs))
+	batch.Advertised = make([]time.Time, 0, len(seen))
+	for i, addr := range addrs {
+		if now.Sub(seen[i]) > cfg.AddrMaxAge {
+			continue
+		}
+		batch.Addrs = append(batch.Addrs, addr)
+		batch.Advertised = append(batch.Advertised, seen[i])
+	}
+	if dropped := len(addrs) - len(batch.Addrs); dropped > 0 {
+		atomic.AddUint64(&addrsTooOld, uint64(dropped))
+	}
+	return batch
+}
+
+// addresses a minute of a flooding peer, a getaddr answer is wire.MaxAddrPerMsg at most
+const addrFloodPerMin = 10 * wire.MaxAddrPerMsg
+
+// count the addr entries in the minute window, true once the peer is over addrFloodPerMin
+func (n *Node) addrFlood(cnt int, now time.Time) bool {
+	if now.Sub(n.addrWindow) >= time.Minute {
+		n.addrWindow, n.addrWindowCnt = now, 0
+	}
+	n.addrWindowCnt += cnt
+	return n.addrWindowCnt > addrFloodPerMin
+}
+
+// signal Connect once both version and verack came
+func (n *Node) checkHandshake() {
+	if n.IsHandshaked() && !n.handshakeDone {
+		n.handshakeDone = true
+		close(n.handshakeCh)
+	}
+}
+
 // listen to incoming messages
-func (n *Node) listen(ctx context.Context) {
-	a := fmt.Sprintf("◀︎ %s", n.Endpoint())
+func (n *Node) listen(ctx context.Context, conn net.Conn) {
+	defer metrics.Track(metrics.RoleListener)()
 	ticker := time.NewTicker(cfg.ListenInterval)
 	defer func() {
 		// ensure to close the connection on exit
-		if n.conn != nil {
-			n.conn.Close()
-		}
-		n.status = disconnected
-		n.log.Warnf("%s closed\n", a)
+		conn.Close()
+		n.setStatus(disconnected)
+		n.log.Warn("closed")
 		ticker.Stop()
+		close(n.listenDone)
 	}()
-	// exit listener if no connection
-	if n.conn == nil {
-		return
-	}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// do not connect if no connection, will panic
-			if n.conn == nil || n.status != connected {
+			// disconnected, the read of the closed connection would fail anyway
+			if n.loadStatus() != connected {
 				return
 			}
-			cnt, msg, rawPayload, err := wire.ReadMessageN(n.conn, cfg.Pver, cfg.Btcnet)
+			// silent nodes do not keep the listener forever
+			_ = conn.SetReadDeadline(time.Now().Add(cfg.MsgReadTimeout))
+			cnt, msg, rawPayload, err := wire.ReadMessageN(conn, n.negotiatedVersion(), cfg.Btcnet)
 			// cnt, msg, rawPayload, err := wire.ReadMessageWithEncodingN(n.Conn, cfg.Pver, cfg.Btcnet, wire.BaseEncoding)
 			if err != nil {
-				if err == io.EOF {
-					n.log.Warnf("%s EOF, exit\n", a)
-					return
-				}
 				// Since the protocol version is 70016 but we don't
 				// implement compact blocks, we have to ignore unknown
 				// messages after the version-verack handshake. This
 				// matches bitcoind's behavior and is necessary since
 				// compact blocks negotiation occurs after the
-				// handshake.
-				if err == wire.ErrUnknownMessage {
-					n.log.Warnf("%s ERR: unknown message, ignoring\n", a)
+				// handshake. The payload is discarded, the stream is aligned.
+				if errors.Is(err, wire.ErrUnknownMessage) {
+					n.log.Debug("unknown message, ignoring")
 					continue
 				}
-
-				// log.Fatalf("Cant read buffer, error: %v\n", err)
-				n.log.Warnf("%s ERR: Cant read buffer, error: %v\n", a, err)
-				n.log.Warnf("%s ERR: bytes read: %v\n", a, cnt)
-				n.log.Warnf("%s ERR: msg: %v\n", a, msg)
-				n.log.Warnf("%s ERR: rawPayload: %v\n", a, rawPayload)
+				if err == io.EOF {
+					n.log.Warn("EOF, exit")
+					return
+				}
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					n.timedOut = TimeoutRead
+					n.log.Debugf("read timeout %s, closing", cfg.MsgReadTimeout)
+					return
+				}
+				// the unknown messages are skipped above, what is left outside of the wire
+				// errors is the connection reset or closed under us, not the fault of the node
+				var msgErr *wire.MessageError
+				if !errors.As(err, &msgErr) {
+					n.readErr = err
+					n.log.Debugf("read failed, closing: %v", err)
+					return
+				}
+				// out of sync or broken stream, nothing to read anymore
+				if !recoverableReadErr(err) {
+					n.misbehaved = MisbehaviorOutOfSync
+					n.log.Warnf("misbehaving, closing: %v", err)
+					return
+				}
+				// message was skipped but the stream is still aligned
+				n.decodeErrs++
+				n.log.Warnf("ERR: bad message (%d/%d), %d bytes read: %v", n.decodeErrs, cfg.MaxDecodeErrors, cnt, err)
+				if n.decodeErrs >= cfg.MaxDecodeErrors {
+					n.misbehaved = MisbehaviorBadMessages
+					n.log.Warn("misbehaving, too many bad messages, closing")
+					return
+				}
 				continue
 			}
-			n.log.Debugf("%s Got message: %d bytes, cmd: %s rawPayload len: %d\n", a, cnt, msg.Command(), len(rawPayload))
+			atomic.AddUint64(&msgsIn, 1)
+			atomic.StoreInt64(&n.lastRecv, time.Now().UnixNano())
+			countMessage(msg.Command())
+			n.log.Debugf("Got message: %d bytes, cmd: %s rawPayload len: %d\n", cnt, msg.Command(), len(rawPayload))
 			switch m := msg.(type) {
 			case *wire.MsgVersion:
-				n.log.Infof("%s MsgVersion received\n", a)
-				n.log.Debugf("%s version: %v\n", a, m.ProtocolVersion)
-				n.log.Debugf("%s msg: %+v\n", a, m)
+				n.log.Info("MsgVersion received")
+				n.log.Debugf("version: %v\n", m.ProtocolVersion)
+				n.log.Debugf("msg: %+v\n", m)
 				n.version = m.ProtocolVersion
+				n.negotiate(m.ProtocolVersion)
+				n.userAgent = m.UserAgent
+				n.services = m.Services
+				n.height = m.LastBlock
+				n.peerTime, n.versionAt = m.Timestamp, time.Now()
+				learnExternal(n.log, m.AddrYou.IP, n.Endpoint())
+				// anyone may connect to us, only ourselves is not accepted
+				if reject := checkVersion(m); reject != "" && (!n.inbound || reject == RejectSelf) {
+					n.rejected = reject
+					n.log.Infof("rejected: %s, version %d, services %s", reject, m.ProtocolVersion, m.Services)
+					return
+				}
+				n.checkHandshake()
 
 			case *wire.MsgVerAck:
-				n.log.Infof("%s MsgVerAck received\n", a)
-				n.log.Debugf("%s msg: %+v\n", a, m)
+				n.log.Info("MsgVerAck received")
+				n.verack = true
+				n.checkHandshake()
+				n.log.Debugf("msg: %+v\n", m)
 
 			case *wire.MsgPing:
-				n.log.Infof("%s MsgPing received\n", a)
-				n.log.Debugf("%s nonce: %v\n", a, m.Nonce)
-				n.log.Debugf("%s msg: %+v\n", a, m)
+				n.log.Info("MsgPing received")
+				n.log.Debugf("nonce: %v\n", m.Nonce)
+				n.log.Debugf("msg: %+v\n", m)
+				// peers close the connections that do not answer
+				if err := n.send(func(conn net.Conn) error { return cmd.SendPong(conn, m.Nonce) }); err != nil {
+					n.log.Errorf("failed to write pong: %v", err)
+				}
 
 			case *wire.MsgPong:
-				n.log.Infof("%s MsgPong received\n", a)
+				n.log.Info("MsgPong received")
 				if m.Nonce == n.pingNonce {
-					n.log.Debugf("%s pong OK\n", a)
+					n.log.Debug("pong OK")
 					n.pongCount++
+					n.rtt = time.Since(n.pingSent)
 					n.UpdatePingNonce()
 				} else {
-					n.log.Warnf("%s pong nonce mismatch, expected %v, got %v\n", a, n.pingNonce, m.Nonce)
+					n.log.Warnf("pong nonce mismatch, expected %v, got %v\n", n.pingNonce, m.Nonce)
 				}
 
 			case *wire.MsgAddr:
-				n.log.Infof("%s MsgAddr received\n", a)
-				n.log.Debugf("%s got %d addresses\n", a, len(m.AddrList))
+				n.log.Info("MsgAddr received")
+				n.log.Debugf("got %d addresses\n", len(m.AddrList))
 				batch := make([]string, len(m.AddrList))
+				seen := make([]time.Time, len(m.AddrList))
 				for i, a := range m.AddrList {
 					batch[i] = fmt.Sprintf("[%s]:%d", a.IP.String(), a.Port)
+					seen[i] = a.Timestamp
+				}
+				if n.addrFlood(len(batch), time.Now()) {
+					n.misbehaved = MisbehaviorAddrFlood
+					n.log.Warnf("misbehaving, over %d addresses a minute, closing", addrFloodPerMin)
+					return
+				}
+				if !n.sendAddrs(ctx, n.newAddrBatch(batch, seen)) {
+					return
 				}
-				n.newAddrCh <- batch
-				n.Disconnect()
+				n.afterAddr(batch)
 
 			case *wire.MsgAddrV2:
-				n.log.Infof("%s MsgAddrV2 received\n", a)
-				n.log.Debugf("%s got %d addresses\n", a, len(m.AddrList))
+				n.log.Info("MsgAddrV2 received")
+				n.log.Debugf("got %d addresses\n", len(m.AddrList))
 				batch := make([]string, len(m.AddrList))
+				seen := make([]time.Time, len(m.AddrList))
 				for i, a := range m.AddrList {
 					batch[i] = a.Addr.String()
+					seen[i] = a.Timestamp
+				}
+				if n.addrFlood(len(batch), time.Now()) {
+					n.misbehaved = MisbehaviorAddrFlood
+					n.log.Warnf("misbehaving, over %d addresses a minute, closing", addrFloodPerMin)
+					return
 				}
-				n.newAddrCh <- batch
-				n.Disconnect()
+				if !n.sendAddrs(ctx, n.newAddrBatch(batch, seen)) {
+					return
+				}
+				n.afterAddr(batch)
 
 			case *wire.MsgInv:
-				n.log.Infof("%s MsgInv received\n", a)
-				n.log.Debugf("%s data: %d\n", a, len(m.InvList))
-				// TODO: answer on inv
+				n.log.Info("MsgInv received")
+				n.log.Debugf("data: %d\n", len(m.InvList))
+				n.invCount += len(m.InvList)
+				if cfg.InvSample > 0 && !n.servesData {
+					n.requestInvSample(m.InvList)
+				}
+
+			case *wire.MsgTx:
+				n.log.Info("MsgTx received")
+				n.log.Debugf("tx: %s\n", m.TxHash())
+				n.servesData = true
+
+			case *wire.MsgBlock:
+				n.log.Info("MsgBlock received")
+				n.log.Debugf("block: %s\n", m.BlockHash())
+				n.servesData = true
+
+			case *wire.MsgNotFound:
+				n.log.Info("MsgNotFound received")
+				n.log.Debugf("data: %d\n", len(m.InvList))
 
 			case *wire.MsgFeeFilter:
-				n.log.Infof("%s MsgFeeFilter received\n", a)
-				n.log.Debugf("%s fee: %v\n", a, m.MinFee)
+				n.log.Info("MsgFeeFilter received")
+				n.log.Debugf("fee: %v\n", m.MinFee)
+				atomic.StoreInt64(&n.feeFilter, m.MinFee)
+
+			case *wire.MsgHeaders:
+				n.log.Info("MsgHeaders received")
+				n.log.Debugf("headers: %d\n", len(m.Headers))
+				// only the first answer is checked, see verifyChain
+				select {
+				case n.headersCh <- m.Headers:
+				default:
+				}
+
+			case *wire.MsgGetAddr:
+				n.log.Info("MsgGetAddr received")
+				if n.inbound && n.addrSource != nil {
+					n.answerGetAddr()
+				}
 
 			case *wire.MsgGetHeaders:
-				n.log.Infof("%s MsgGetHeaders received\n", a)
-				n.log.Debugf("%s headers: %d\n", a, len(m.BlockLocatorHashes))
+				n.log.Info("MsgGetHeaders received")
+				n.log.Debugf("headers: %d\n", len(m.BlockLocatorHashes))
+
+			case *wire.MsgReject:
+				n.log.Warnf("MsgReject received: %s %s: %s", m.Cmd, m.Code, m.Reason)
+				n.peerReject = fmt.Sprintf("%s %s: %s", m.Cmd, m.Code, m.Reason)
+				// no handshake after our version was rejected, no need to wait for the read timeout
+				if m.Cmd == wire.CmdVersion && !n.handshakeDone {
+					n.rejected = RejectByPeer
+					n.misbehaved = MisbehaviorRejectedUs
+					return
+				}
 
 			default:
-				n.log.Infof("%s (%T) message received (unhandled)\n", a, m)
-				n.log.Debugf("%s msg: %+v\n", a, m)
+				n.log.Infof("(%T) message received (unhandled)\n", m)
+				n.log.Debugf("msg: %+v\n", m)
 			}
 		}
 	}
 }
+
+// ask for a random sample of announced txs and blocks
+// to check the node actually serves the data
+func (n *Node) requestInvSample(invs []*wire.InvVect) {
+	sample := make([]*wire.InvVect, 0, cfg.InvSample)
+	for _, i := range rand.Perm(len(invs)) {
+		switch invs[i].Type {
+		case wire.InvTypeTx, wire.InvTypeWitnessTx, wire.InvTypeBlock, wire.InvTypeWitnessBlock:
+			sample = append(sample, invs[i])
+		}
+		if len(sample) >= cfg.InvSample {
+			break
+		}
+	}
+	if len(sample) == 0 {
+		return
+	}
+	n.log.Debugf("sending getdata for %d of %d items\n", len(sample), len(invs))
+	err := n.send(func(conn net.Conn) error { return cmd.SendGetData(conn, sample) })
+	if err != nil {
+		n.log.Errorf("failed to write getdata: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// writeRaw writes a message the wire package does not know
+func writeRaw(conn net.Conn, command string, payload []byte) error {
+	var hdr bytes.Buffer
+	_ = binary.Write(&hdr, binary.LittleEndian, uint32(cfg.Btcnet))
+	var cmdBytes [wire.CommandSize]byte
+	copy(cmdBytes[:], command)
+	hdr.Write(cmdBytes[:])
+	_ = binary.Write(&hdr, binary.LittleEndian, uint32(len(payload)))
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	hdr.Write(second[:4])
+	_, err := conn.Write(append(hdr.Bytes(), payload...))
+	return err
+}
+
+// corePeer answers the version like a recent Core node, with the messages
+// unknown to the wire package around the verack, and pings after the handshake.
+// the pong of pingNonce is signaled on ponged
+func corePeer(t *testing.T, pingNonce uint64, ponged chan<- struct{}) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					msg, _, err := wire.ReadMessage(conn, cfg.Pver, cfg.Btcnet)
+					if err != nil {
+						return
+					}
+					switch m := msg.(type) {
+					case *wire.MsgVersion:
+						addr := wire.NewNetAddressIPPort(net.IPv4(127, 0, 0, 1), 8333, 0)
+						version := wire.NewMsgVersion(addr, addr, 1, 0)
+						version.Services = cfg.RequiredServices
+						version.UserAgent = "/Satoshi:27.0.0/"
+						if wire.WriteMessage(conn, version, cfg.Pver, cfg.Btcnet) != nil ||
+							writeRaw(conn, "wtxidrelay", nil) != nil ||
+							wire.WriteMessage(conn, wire.NewMsgSendAddrV2(), cfg.Pver, cfg.Btcnet) != nil ||
+							wire.WriteMessage(conn, wire.NewMsgVerAck(), cfg.Pver, cfg.Btcnet) != nil ||
+							writeRaw(conn, "sendcmpct", []byte{0, 2, 0, 0, 0, 0, 0, 0, 0}) != nil ||
+							writeRaw(conn, "xyzzy", []byte("unknown payload")) != nil ||
+							wire.WriteMessage(conn, wire.NewMsgPing(pingNonce), cfg.Pver, cfg.Btcnet) != nil {
+							return
+						}
+					case *wire.MsgPong:
+						if m.Nonce == pingNonce {
+							select {
+							case ponged <- struct{}{}:
+							default:
+							}
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// connectCore connects to a corePeer and waits for the pong of its ping
+func connectCore(t *testing.T) *Node {
+	t.Helper()
+	const pingNonce = 42
+	ponged := make(chan struct{}, 1)
+	addr := corePeer(t, pingNonce, ponged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := NewNode(testLogger{t: t}, addr, make(chan AddrBatch, 16))
+	resCh := make(chan *Node, 1)
+	errCh := make(chan error, 1)
+	go func() { errCh <- n.Connect(ctx, resCh) }()
+
+	select {
+	case <-resCh:
+	case err := <-errCh:
+		t.Fatalf("connect: %v", err)
+	case <-time.After(cfg.HandshakeTimeout + 5*time.Second):
+		t.Fatal("no handshake")
+	}
+	// the ping after the unknown messages is answered
+	select {
+	case <-ponged:
+	case err := <-errCh:
+		t.Fatalf("connect: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pong after the unknown messages")
+	}
+	cancel()
+	<-errCh
+	return n
+}
+
+// the messages after the version of a recent peer are unknown to the wire package
+// and skipped, the stream stays aligned and the node is good
+func TestListenSkipsUnknownMessages(t *testing.T) {
+	n := connectCore(t)
+	if n.Misbehaved() != "" {
+		t.Errorf("misbehaved: %s", n.Misbehaved())
+	}
+	if n.readErr != nil {
+		t.Errorf("read error: %v", n.readErr)
+	}
+}
+
+// the messages are encoded with the lower of ADVERTISED_VERSION and the peer version
+func TestNegotiatedVersion(t *testing.T) {
+	defer func(v uint32) { cfg.AdvertisedVersion = v }(cfg.AdvertisedVersion)
+	tests := []struct {
+		name       string
+		advertised uint32
+		want       uint32
+	}{
+		{"ours", wire.ProtocolVersion, wire.ProtocolVersion},
+		{"older advertised", wire.FeeFilterVersion, wire.FeeFilterVersion},
+		// the peer version is lower, the wire package does not know newer ones
+		{"newer advertised", wire.ProtocolVersion + 10, wire.ProtocolVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AdvertisedVersion = tt.advertised
+			n := connectCore(t)
+			if got := n.negotiatedVersion(); got != tt.want {
+				t.Errorf("negotiated version %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
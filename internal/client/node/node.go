@@ -3,20 +3,69 @@ package node
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/1F47E/go-btc-xray/internal/cmd"
 	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/geo"
 	"github.com/1F47E/go-btc-xray/internal/logger"
+
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/net/proxy"
+)
+
+var cfg = config.Shared()
+
+// messages read and written by all the nodes
+var msgsIn, msgsOut uint64
+
+// addr entries over ADDR_MAX_AGE dropped by all the nodes
+var addrsTooOld uint64
+
+// AddrsTooOld returns the addresses dropped by ADDR_MAX_AGE
+func AddrsTooOld() uint64 {
+	return atomic.LoadUint64(&addrsTooOld)
+}
+
+// MessageCounts returns the total messages received and sent by all the nodes
+func MessageCounts() (in, out uint64) {
+	return atomic.LoadUint64(&msgsIn), atomic.LoadUint64(&msgsOut)
+}
+
+// messages received by all the nodes by command
+var (
+	msgTypesMu sync.Mutex
+	msgTypes   = make(map[string]uint64)
 )
 
-var cfg = config.New()
+func countMessage(command string) {
+	msgTypesMu.Lock()
+	msgTypes[command]++
+	msgTypesMu.Unlock()
+}
+
+// MessageTypes returns a copy of the received messages counts by command
+func MessageTypes() map[string]uint64 {
+	msgTypesMu.Lock()
+	defer msgTypesMu.Unlock()
+	types := make(map[string]uint64, len(msgTypes))
+	for command, cnt := range msgTypes {
+		types[command] = cnt
+	}
+	return types
+}
 
-type status int
+type status int32
 
 const (
 	new = iota
@@ -24,6 +73,7 @@ const (
 	connected
 	disconnected
 	dead
+	rejected
 )
 
 type Result struct {
@@ -32,34 +82,181 @@ type Result struct {
 }
 
 type Node struct {
-	log       *logger.Logger
+	log       logger.Interface
 	ip        string
+	port      uint16
 	conn      net.Conn
+	connMu    sync.Mutex
+	writeMu   sync.Mutex
 	pingNonce uint64
-	pongCount uint8
-	status    status
+	pingSent  time.Time
+	// nonce of our version message, see isOurNonce
+	versionNonce uint64
+	// getaddr requests sent, hashes of the answered addresses
+	// and the answers signal, see repeatGetAddr
+	getAddrs      int32
+	addrSeen      map[uint64]struct{}
+	addrExhausted int32
+	addrAnswer    chan struct{}
+	// atomic, bytes of the current connection, see countingConn
+	bytesIn  uint64
+	bytesOut uint64
+	// atomic, the lower of ours and the peer version, see negotiatedVersion
+	pver uint32
+	// atomic, failed connects in the run toward MAX_ATTEMPTS
+	failedConns int32
+	pongCount   uint8
+	// atomic, see loadStatus, ConnState is read by the gui and the stats
+	status    int32
+	newAddrCh chan AddrBatch
+
+	// user agent we presented, peers may answer differently by it
+	ourUserAgent string
+
+	// filled from the remote version message
 	version   int32
-	newAddrCh chan []string
+	userAgent string
+	services  wire.ServiceFlag
+	height    int32
+	verack    bool
+	// timestamp of the remote version and when it came, see ClockSkew
+	peerTime  time.Time
+	versionAt time.Time
+
+	// round trip time of the last answered ping
+	rtt time.Duration
+
+	// classified error of the last failed dial and number of dials
+	dialErr      DialErr
+	dialAttempts int
+	// which timeout closed the connection, empty if none
+	timedOut Timeout
+	// why the version of the node was not accepted, empty if accepted
+	rejected Reject
+	// last reject message of the node, "cmd code: reason"
+	peerReject string
+	// read error that closed the listener, nil on EOF and the timeouts
+	readErr error
+	// closed by the listener on version and verack or on exit
+	handshakeCh   chan struct{}
+	handshakeDone bool
+	listenDone    chan struct{}
+	// atomic, unix nano of the last message of the current connection and 1 once it was sent
+	// to the client, see LastRecv and Reported
+	lastRecv int64
+	reported int32
+	// undecodable messages, see recoverableReadErr
+	decodeErrs int
+	// why the listener closed the misbehaving peer, empty if it did not
+	misbehaved Misbehavior
+	// addr entries of the current minute, see addrFlood
+	addrWindow    time.Time
+	addrWindowCnt int
+
+	// inventory announcements, liveness signal
+	invCount int
+	// answered getdata for a sampled inv item with a tx or block
+	servesData bool
+	// answered the BIP324 v2 handshake, see probeV2
+	supportsV2 bool
+	// answer to getheaders from CHAIN_CHECKPOINT and the headers signal, see verifyChain
+	chain     ChainStatus
+	headersCh chan []*wire.BlockHeader
+	// atomic, handshakes of the last connect, see confirmReachable
+	confirmations int32
+
+	// atomic, the announcements of this address, only the first peer is kept,
+	// the full graph is on disk with RECORD_GRAPH
+	announceCount int32
+	firstFrom     string
+	// atomic, unix nano of the latest time a peer saw the node by the addr messages
+	advertised int64
+	// atomic, hops from the seeds, the smallest seen
+	depth int32
+	// seed the node came through, the first one that reached it
+	origin string
+	// country and ASN of a good node with GEO_FILE or GEO_MMDB, see SetGeo
+	geo geo.Info
+	// connected by the peer, see Accept
+	inbound    bool
+	addrSource func() []*wire.NetAddress
+
+	// stay connected after the handshake, see Keep
+	keep bool
+	// atomic, unix nano of the handshake, zero while not connected
+	establishedAt int64
+	// atomic, latest BIP133 feefilter in sat/kvB, -1 until one came
+	feeFilter int64
+	// atomic, the height against the estimated tip, see SetHeightFlags
+	stale int32
+	lying int32
+}
+
+// AddrBatch is an addr message from a peer
+type AddrBatch struct {
+	From  string
+	Addrs []string
+	// when the peer last saw every address, nil for the seeds
+	Advertised []time.Time
+	// hops from the seeds, the depth of the sender plus one, zero for the seeds
+	Depth int32
+	// seed the sender came through, see Node.Origin
+	Origin string
+	// when the addr message came
+	At time.Time
 }
 
-func NewNode(log *logger.Logger, ip string, newAddrCh chan []string) *Node {
+// NewNode accepts a bare ip or a host:port pair.
+// Without a port the network default port is used.
+func NewNode(log logger.Interface, ip string, newAddrCh chan AddrBatch) *Node {
 	n := Node{
 		log:       log,
 		ip:        ip,
+		port:      cfg.NodesPort,
 		newAddrCh: newAddrCh,
+		feeFilter: -1,
 	}
+	if host, port, err := net.SplitHostPort(ip); err == nil {
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			n.ip = host
+			n.port = uint16(p)
+		}
+	}
+	n.log = log.With(logger.FieldModule, "node").With(logger.FieldPeer, n.Endpoint())
 	n.UpdatePingNonce()
 	return &n
 }
 
+// Disconnect closes the connection from another goroutine, the listener exits
+// on the read error and Connect on the listener. false if it was not connected
 func (n *Node) Disconnect() bool {
-	if n.conn != nil {
-		n.conn.Close()
-		n.conn = nil
-		n.status = disconnected
-		return true
+	if !atomic.CompareAndSwapInt32(&n.status, connected, disconnected) {
+		return false
+	}
+	n.closeConn()
+	return true
+}
+
+// closeConn closes the current connection without clearing the field,
+// the goroutines of the connection still use it
+func (n *Node) closeConn() {
+	if conn := n.loadConn(); conn != nil {
+		conn.Close()
 	}
-	return false
+}
+
+// setConn and loadConn guard the field against the goroutines
+// that do not own the connection
+func (n *Node) setConn(conn net.Conn) {
+	n.connMu.Lock()
+	n.conn = conn
+	n.connMu.Unlock()
+}
+
+func (n *Node) loadConn() net.Conn {
+	n.connMu.Lock()
+	defer n.connMu.Unlock()
+	return n.conn
 }
 
 func (n *Node) UpdatePingNonce() {
@@ -67,133 +264,802 @@ func (n *Node) UpdatePingNonce() {
 	n.pingNonce = nonceBig.Uint64()
 }
 
+func (n *Node) setStatus(s status) {
+	atomic.StoreInt32(&n.status, int32(s))
+}
+
+func (n *Node) loadStatus() status {
+	return status(atomic.LoadInt32(&n.status))
+}
+
 func (n *Node) IsNew() bool {
-	return n.status == new
+	return n.loadStatus() == new
 }
 
 func (n *Node) IsDead() bool {
-	return n.status == dead
+	return n.loadStatus() == dead
+}
+
+func (n *Node) IsRejected() bool {
+	return n.loadStatus() == rejected
 }
 
 func (n *Node) IsConnecting() bool {
-	return n.status == connecting
+	return n.loadStatus() == connecting
 }
 func (n *Node) IsConnected() bool {
-	return n.status == connected && n.conn != nil
+	return n.loadStatus() == connected && n.loadConn() != nil
+}
+
+func (n *Node) IsHandshaked() bool {
+	return n.version != 0 && n.verack
 }
 
 func (n *Node) Endpoint() string {
-	return fmt.Sprintf("%s:%d", n.ip, cfg.NodesPort)
+	return fmt.Sprintf("%s:%d", n.ip, n.port)
+}
+
+func (n *Node) Port() uint16 {
+	return n.port
 }
 
 // wrapper with brackets for ipv6 needed for net.Dial
 func (n *Node) EndpointSafe() string {
-	return fmt.Sprintf("[%s]:%d", n.ip, cfg.NodesPort)
+	return fmt.Sprintf("[%s]:%d", n.ip, n.port)
+}
+
+func (n *Node) Version() int32 {
+	return n.version
+}
+
+func (n *Node) UserAgent() string {
+	return n.userAgent
+}
+
+// OurUserAgent returns the user agent sent to the node, empty until the version is sent
+func (n *Node) OurUserAgent() string {
+	return n.ourUserAgent
+}
+
+func (n *Node) Services() wire.ServiceFlag {
+	return n.services
+}
+
+func (n *Node) Height() int32 {
+	return n.height
+}
+
+// SetHeightFlags marks the advertised height as behind or far above the estimated tip,
+// set by the client as the estimate moves
+func (n *Node) SetHeightFlags(stale, lying bool) {
+	atomic.StoreInt32(&n.stale, boolInt32(stale))
+	atomic.StoreInt32(&n.lying, boolInt32(lying))
+}
+
+// Stale is behind the estimated tip by more than STALE_BLOCKS
+func (n *Node) Stale() bool {
+	return atomic.LoadInt32(&n.stale) == 1
+}
+
+// Lying advertised a height far above the estimated tip
+func (n *Node) Lying() bool {
+	return atomic.LoadInt32(&n.lying) == 1
+}
+
+func boolInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (n *Node) InvCount() int {
+	return n.invCount
+}
+
+func (n *Node) ServesData() bool {
+	return n.servesData
+}
+
+// Announced counts the announcement, returns false for a repeat of the first peer.
+// the other peers are not kept and count on every announcement.
+// not safe for concurrent use, the caller serializes it
+func (n *Node) Announced(from string) bool {
+	if n.firstFrom == from {
+		return false
+	}
+	if n.firstFrom == "" {
+		n.firstFrom = from
+	}
+	atomic.AddInt32(&n.announceCount, 1)
+	return true
+}
+
+// SetAdvertised keeps the latest time a peer saw the node,
+// set by the client under its lock like Announced
+func (n *Node) SetAdvertised(t time.Time) {
+	if t.UnixNano() > atomic.LoadInt64(&n.advertised) {
+		atomic.StoreInt64(&n.advertised, t.UnixNano())
+	}
+}
+
+// Advertised is zero for the seeds and with DISK_QUEUE
+func (n *Node) Advertised() time.Time {
+	nanos := atomic.LoadInt64(&n.advertised)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// SetDepth is called by the client under its lock like Announced
+func (n *Node) SetDepth(depth int32) {
+	atomic.StoreInt32(&n.depth, depth)
+}
+
+// Depth is zero for the seeds and with DISK_QUEUE
+func (n *Node) Depth() int32 {
+	return atomic.LoadInt32(&n.depth)
+}
+
+// SetOrigin is called by the client before the node is queued
+func (n *Node) SetOrigin(origin string) {
+	n.origin = origin
+}
+
+// SetGeo annotates the good node with its country and ASN for the outputs
+func (n *Node) SetGeo(info geo.Info) {
+	n.geo = info
+}
+
+// Geo returns the zero Info without GEO_FILE or GEO_MMDB
+func (n *Node) Geo() geo.Info {
+	return n.geo
+}
+
+// Origin is the dns seed or the seed list the node came through,
+// empty with DISK_QUEUE
+func (n *Node) Origin() string {
+	return n.origin
+}
+
+// FirstFrom is the first peer that sent the address, empty for the seeds
+// and with DISK_QUEUE
+func (n *Node) FirstFrom() string {
+	return n.firstFrom
+}
+
+// AnnounceCount returns the number of announcements of the address,
+// zero for the seeds
+func (n *Node) AnnounceCount() int {
+	return int(atomic.LoadInt32(&n.announceCount))
+}
+
+// DialErr is empty if the last dial succeeded
+func (n *Node) DialErr() DialErr {
+	return n.dialErr
+}
+
+func (n *Node) DialAttempts() int {
+	return n.dialAttempts
+}
+
+// ClockSkew is the peer clock minus ours, false before the version. the version was sent
+// about half the round trip before it came, none is known until a pong
+func (n *Node) ClockSkew() (time.Duration, bool) {
+	if n.peerTime.IsZero() {
+		return 0, false
+	}
+	return n.peerTime.Sub(n.versionAt.Add(-n.rtt / 2)), true
+}
+
+// RTT returns zero until a pong has been received
+func (n *Node) RTT() time.Duration {
+	return n.rtt
+}
+
+// LastRecv is the time of the last message, the dial time before the first one
+func (n *Node) LastRecv() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&n.lastRecv))
+}
+
+// ConnFailed counts a failed connect toward MAX_ATTEMPTS, the count is kept
+// as long as the client knows the node
+func (n *Node) ConnFailed() {
+	atomic.AddInt32(&n.failedConns, 1)
+}
+
+// FailedConns returns the failed connects of the run
+func (n *Node) FailedConns() int {
+	return int(atomic.LoadInt32(&n.failedConns))
+}
+
+// Reported is true once the handshake of the current connection was sent to the client,
+// the node is good or rejected and closing it loses only the addresses it did not send yet
+func (n *Node) Reported() bool {
+	return atomic.LoadInt32(&n.reported) == 1
+}
+
+// Ping sends a ping with the current nonce and remembers when it was sent
+// so the listener can measure the round trip time on pong.
+func (n *Node) Ping() error {
+	n.pingSent = time.Now()
+	return n.send(func(conn net.Conn) error { return cmd.SendPing(conn, n.pingNonce) })
+}
+
+// send serializes writes from the connect and the listen goroutines,
+// a message is written in a few chunks and must not interleave
+func (n *Node) send(fn func(conn net.Conn) error) error {
+	n.writeMu.Lock()
+	defer n.writeMu.Unlock()
+	conn := n.loadConn()
+	if conn == nil {
+		return net.ErrClosed
+	}
+	err := fn(conn)
+	if err == nil {
+		atomic.AddUint64(&msgsOut, 1)
+	}
+	return err
 }
 
 // returning error here will consider the node as dead
 func (n *Node) Connect(ctx context.Context, resCh chan *Node) error {
-	n.status = connecting
-	a := fmt.Sprintf("▶︎ %s", n.ip)
-	n.log.Debugf("%s connecting...\n", a)
+	n.setStatus(connecting)
+	n.log.Debug("connecting...")
 	defer func() {
-		n.conn = nil
-		n.log.Debugf("%s closed\n", a)
+		n.setConn(nil)
+		n.log.Debug("closed")
 	}()
-	conn, err := net.DialTimeout("tcp", n.EndpointSafe(), cfg.NodeTimeout)
+	// dial timeout is separate from the handshake timeout
+	// so unreachable nodes fail fast and free the worker
+	n.dialAttempts++
+	// best effort v2 detection on a separate connection, the crawl goes on as v1.
+	// a failed probe is not v2, only the v1 dial decides if the node is dead
+	if cfg.V2Probe {
+		v2, err := n.probeV2(ctx)
+		if err != nil {
+			n.log.Debugf("v2 probe failed, trying v1: %v", err)
+		}
+		n.supportsV2 = v2 && err == nil
+		n.log.Debugf("v2 transport: %v", n.supportsV2)
+	}
+	conn, err := dial(ctx, n.log, n.EndpointSafe())
 	if err != nil {
-		n.status = dead
-		return fmt.Errorf("%s failed to connect: %w", a, err)
+		n.setStatus(dead)
+		n.dialErr = classifyDialErr(err)
+		if n.dialErr == DialErrTimeout {
+			n.timedOut = TimeoutDial
+			n.log.Debugf("dead: dial timeout %s", cfg.DialTimeout)
+		}
+		return fmt.Errorf("failed to connect: %w", err)
 	}
-	n.log.Debugf("%s connected\n", a)
-	n.conn = conn
-	n.status = connected
+	setKeepAlive(n.log, conn)
+	n.dialErr = ""
+	n.rejected = ""
+	n.peerReject = ""
+	n.readErr = nil
+	n.misbehaved = ""
+	n.addrWindow, n.addrWindowCnt = time.Time{}, 0
+	atomic.StoreUint64(&n.bytesIn, 0)
+	atomic.StoreUint64(&n.bytesOut, 0)
+	atomic.StoreUint32(&n.pver, cmd.OurVersion())
+	conn = &countingConn{Conn: conn, n: n}
+	atomic.StoreInt32(&n.getAddrs, 0)
+	n.addrSeen = nil
+	atomic.StoreInt32(&n.addrExhausted, 0)
+	n.addrAnswer = make(chan struct{}, 1)
+	n.chain = ""
+	n.headersCh = make(chan []*wire.BlockHeader, 1)
+	atomic.StoreInt32(&n.confirmations, 0)
+	atomic.StoreInt64(&n.lastRecv, time.Now().UnixNano())
+	atomic.StoreInt32(&n.reported, 0)
+	n.versionNonce = newNonce()
+	defer releaseNonce(n.versionNonce)
+	n.log.Debug("connected")
+	// the whole handshake has its own timeout from here,
+	// handshake writes should not hang on a stalled node
+	handshakeDeadline := time.Now().Add(cfg.HandshakeTimeout)
+	_ = conn.SetWriteDeadline(handshakeDeadline)
+	n.setConn(conn)
+	n.setStatus(connected)
+	n.handshakeCh = make(chan struct{})
+	n.handshakeDone = false
+	n.listenDone = make(chan struct{})
 	// handle answers
 	// exit on closed connection or context cancel
-	go n.listen(ctx)
+	go n.listen(ctx, conn)
 
 	// ===== NEGOTIATION
 	// TODO: make it in a separate negotiation function
 	// 1. sending version
-	n.log.Debugf("%s sending version...\n", a)
-	err = cmd.SendVersion(n.conn, n.pingNonce)
+	n.log.Debug("sending version...")
+	n.ourUserAgent = cfg.UserAgent
+	err = n.send(func(conn net.Conn) error { return cmd.SendVersion(conn, n.versionNonce) })
 	if err != nil {
-		return fmt.Errorf("%s failed to write version: %v", a, err)
+		return n.handshakeFailed(conn, fmt.Errorf("failed to write version: %w", err))
 	}
-	n.log.Debugf("%s OK\n", a)
+	n.log.Debug("OK")
 
 	// 2. send addr v2
-	n.log.Debugf("%s sending sendaddrv2...\n", a)
-	err = cmd.SendAddrV2(n.conn)
+	n.log.Debug("sending sendaddrv2...")
+	err = n.send(cmd.SendAddrV2)
 	if err != nil {
-		return fmt.Errorf("%s failed to write sendaddrv2: %v", a, err)
+		return n.handshakeFailed(conn, fmt.Errorf("failed to write sendaddrv2: %w", err))
 	}
-	n.log.Debugf("%s OK\n", a)
+	n.log.Debug("OK")
 
 	// 3. send verAck
 	// TODO: read version first
 	time.Sleep(2 * time.Second)
-	n.log.Debugf("%s sending verack...\n", a)
-	err = cmd.SendVerAck(n.conn)
+	n.log.Debug("sending verack...")
+	err = n.send(cmd.SendVerAck)
 	if err != nil {
-		return fmt.Errorf("%s failed to write verack: %v", a, err)
+		return n.handshakeFailed(conn, fmt.Errorf("failed to write verack: %w", err))
+	}
+	n.log.Debug("OK")
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	// 4. wait for the version and verack of the node
+	handshakeTimer := time.NewTimer(time.Until(handshakeDeadline))
+	defer handshakeTimer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-n.handshakeCh:
+	case <-n.listenDone:
+		return n.handshakeFailed(conn, fmt.Errorf("closed during the handshake"))
+	case <-handshakeTimer.C:
+		n.log.Debugf("dead: handshake timeout %s", cfg.HandshakeTimeout)
+		// set after the listener exits, it does not touch the node anymore
+		err := n.handshakeFailed(conn, fmt.Errorf("handshake timeout after %s", cfg.HandshakeTimeout))
+		n.timedOut = TimeoutHandshake
+		return err
+	}
+
+	atomic.StoreInt32(&n.confirmations, 1)
+
+	if cfg.ChainCheckpoint != nil {
+		n.verifyChain(ctx)
+	}
+
+	// the kept nodes are good already
+	if cfg.ConfirmProbes > 0 && !n.keep {
+		if err := n.confirmReachable(ctx); err != nil {
+			conn.Close()
+			<-n.listenDone
+			n.setStatus(dead)
+			return err
+		}
 	}
-	n.log.Debugf("%s OK\n", a)
 
 	// send results but continue working,
 	// asking for peers and sending a few pings
-	resCh <- n
+	select {
+	case <-ctx.Done():
+		return nil
+	case resCh <- n:
+	}
+	atomic.StoreInt32(&n.reported, 1)
 
 	// ====== NEGOTIATION DONE
+	if n.keep {
+		return n.keepAlive(ctx)
+	}
+	if cfg.FastCrawl {
+		return n.fastGetAddr(ctx)
+	}
 	time.Sleep(1 * time.Second)
 
 	// ask for peers once
-	n.log.Debugf("%s sending getaddr...\n", a)
-	err = cmd.SendGetAddr(n.conn)
+	n.log.Debug("sending getaddr...")
+	err = n.send(cmd.SendGetAddr)
+	if err != nil {
+		n.log.Errorf("failed to write getaddr: %v", err)
+		return nil
+	}
+	atomic.StoreInt32(&n.getAddrs, 1)
+	n.log.Debug("OK")
+
+	// first ping right away to measure the latency
+	n.log.Debug("sending ping...")
+	err = n.Ping()
 	if err != nil {
-		n.log.Errorf("%s failed to write getaddr: %v", a, err)
+		n.log.Errorf("failed to write ping: %v", err)
 		return nil
 	}
-	n.log.Debugf("%s OK\n", a)
+	n.log.Debug("OK")
 
-	// Sending a ping to keep a connection while waiting for peers from get addr command
-	// Waiting for the pong in the listen goroutine and increment ping count
-	// Every ping should have a nonce different from the previous one
-	// Disconnect if ping count reached or no pong received
+	n.waitPong(ctx)
+	if cfg.GetAddrInterval > 0 {
+		n.repeatGetAddr(ctx)
+	}
+	return nil
+}
+
+// Sending a ping to keep a connection while waiting for peers from get addr command
+// Waiting for the pong in the listen goroutine and increment ping count
+// Every ping should have a nonce different from the previous one
+// Disconnect if ping count reached or no pong received
+func (n *Node) waitPong(ctx context.Context) {
 	timeout, cancel := context.WithTimeout(ctx, cfg.PingTimeout)
 	defer cancel()
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
-	pingCount := 0
+	pingCount := 1
 	for {
 		select {
 		case <-timeout.Done():
-			n.log.Warnf("%s ping timeout\n", a)
-			return nil
+			n.log.Warn("ping timeout")
+			return
 		case <-ctx.Done():
-			n.log.Warnf("%s context done, disconnecting\n", a)
-			return nil
+			n.log.Warn("context done, disconnecting")
+			return
+		case <-n.listenDone:
+			n.log.Debug("disconnected")
+			return
 		case <-ticker.C:
-			if n.conn == nil {
-				n.log.Debugf("%s disconnected\n", a)
-				return nil
+			if !n.IsConnected() {
+				n.log.Debug("disconnected")
+				return
 			}
 			if n.pongCount >= 1 {
-				n.log.Debugf("%s pong count reached\n", a)
-				return nil
+				n.log.Debug("pong count reached")
+				return
 			}
 			if pingCount >= cfg.PingRetrys {
-				n.log.Debugf("%s ping retry count reached\n", a)
-				return nil
+				n.log.Debug("ping retry count reached")
+				return
 			}
-			n.log.Debugf("%s sending ping...\n", a)
-			err = cmd.SendPing(n.conn, n.pingNonce)
+			n.log.Debug("sending ping...")
+			err := n.Ping()
 			if err != nil {
-				n.log.Errorf("%s failed to write ping: %v", a, err)
-				return nil
+				n.log.Errorf("failed to write ping: %v", err)
+				return
 			}
 			pingCount++
-			n.log.Debugf("%s OK\n", a)
+			n.log.Debug("OK")
 		}
 	}
 }
+
+type AddrType string
+
+const (
+	AddrIPv4  AddrType = "ipv4"
+	AddrIPv6  AddrType = "ipv6"
+	AddrOnion AddrType = "onion"
+	AddrOther AddrType = "other"
+)
+
+// AddrType classifies the node address, ipv4 mapped ipv6 counts as ipv4
+func (n *Node) AddrType() AddrType {
+	return hostType(n.ip)
+}
+
+// AddrTypeOf classifies an address as passed to NewNode without creating the node
+func AddrTypeOf(addr string) AddrType {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return hostType(host)
+	}
+	return hostType(addr)
+}
+
+func hostType(host string) AddrType {
+	if strings.HasSuffix(host, ".onion") {
+		return AddrOnion
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return AddrOther
+	case ip.To4() != nil:
+		return AddrIPv4
+	default:
+		return AddrIPv6
+	}
+}
+
+// NetGroup is the /16 of an ipv4 host and the /32 of an ipv6 one, the hosts of a group
+// are likely run by the same operator. the onions are one group like in bitcoind and
+// the other names another, the random names tell nothing about the operator
+func NetGroup(host string) string {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return string(hostType(host))
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(16, 32)).String() + "/16"
+	default:
+		return ip.Mask(net.CIDRMask(32, 128)).String() + "/32"
+	}
+}
+
+// NetGroup of the node host, see the NetGroup func
+func (n *Node) NetGroup() string {
+	return NetGroup(n.ip)
+}
+
+// Kind of the node by the advertised services, limited nodes are pruned
+// and serve only the last 288 blocks, useless for the initial block download
+type Kind string
+
+const (
+	KindFull    Kind = "full"
+	KindLimited Kind = "limited"
+	KindNeither Kind = "neither"
+)
+
+// BIP159 NODE_NETWORK_LIMITED, not defined in btcd
+const sfNodeNetworkLimited wire.ServiceFlag = 1 << 10
+
+// Kind is neither until the version of the node came
+func (n *Node) Kind() Kind {
+	switch {
+	case n.services&wire.SFNodeNetwork != 0:
+		return KindFull
+	case n.services&sfNodeNetworkLimited != 0:
+		return KindLimited
+	default:
+		return KindNeither
+	}
+}
+
+// Timeout that closed the connection, to tune them for the network
+type Timeout string
+
+const (
+	TimeoutDial      Timeout = "dial"
+	TimeoutHandshake Timeout = "handshake"
+	TimeoutRead      Timeout = "read"
+	// no addr answer in FAST_TIMEOUT, see fastGetAddr
+	TimeoutAddr Timeout = "addr"
+)
+
+// TimedOut returns which timeout closed the last connection, empty if none
+func (n *Node) TimedOut() Timeout {
+	return n.timedOut
+}
+
+// ErrRejected is returned by Connect for the nodes closed after their version, see Rejected
+var ErrRejected = errors.New("rejected")
+
+// Reject is why the version of the node was not accepted
+type Reject string
+
+const (
+	RejectOldProtocol     Reject = "old protocol"
+	RejectMissingServices Reject = "missing services"
+	// our own version came back or the address is our external one
+	RejectSelf Reject = "self"
+	// the node sent a reject for our version, see PeerReject
+	RejectByPeer Reject = "by peer"
+)
+
+// Rejected returns why the node was closed after its version, empty if it was not
+func (n *Node) Rejected() Reject {
+	return n.rejected
+}
+
+// PeerReject returns the last reject message of the node as "cmd code: reason", empty if none
+func (n *Node) PeerReject() string {
+	return n.peerReject
+}
+
+// Misbehavior is why the listener closed the peer, counted toward the ban of its ip
+type Misbehavior string
+
+const (
+	// MAX_DECODE_ERRORS undecodable messages
+	MisbehaviorBadMessages Misbehavior = "bad messages"
+	// bad magic or oversized payload
+	MisbehaviorOutOfSync Misbehavior = "out of sync"
+	// over addrFloodPerMin addresses a minute
+	MisbehaviorAddrFlood Misbehavior = "addr flood"
+	// the peer sent a reject for our version
+	MisbehaviorRejectedUs Misbehavior = "rejected our version"
+)
+
+// Misbehaved returns why the last connection was closed for misbehaving, empty if it was not
+func (n *Node) Misbehaved() Misbehavior {
+	return n.misbehaved
+}
+
+// Host returns the address without the port, the ports of a host are banned together
+func (n *Node) Host() string {
+	return n.ip
+}
+
+// checkVersion rejects our own connections, the nodes below MIN_PROTOCOL_VERSION or without REQUIRED_SERVICES,
+// the advertised fields are kept for the rejected nodes log
+func checkVersion(m *wire.MsgVersion) Reject {
+	if isOurNonce(m.Nonce) {
+		return RejectSelf
+	}
+	if m.ProtocolVersion < cfg.MinProtocolVersion {
+		return RejectOldProtocol
+	}
+	if m.Services&cfg.RequiredServices != cfg.RequiredServices {
+		return RejectMissingServices
+	}
+	return ""
+}
+
+// handshakeFailed closes the connection and waits for the listener,
+// a write may fail because the listener has just rejected the node
+func (n *Node) handshakeFailed(conn net.Conn, err error) error {
+	conn.Close()
+	<-n.listenDone
+	if n.rejected != "" {
+		n.setStatus(rejected)
+		return fmt.Errorf("%w: %s", ErrRejected, n.rejected)
+	}
+	n.setStatus(dead)
+	return err
+}
+
+// DeadReason is why a connect failed, a network level one or a failed handshake
+type DeadReason string
+
+const (
+	DeadRefused    DeadReason = "refused"
+	DeadTimeout    DeadReason = "timeout"
+	DeadUnroutable DeadReason = "unroutable"
+	// closed by the node with a reset or a broken pipe after the dial
+	DeadReset DeadReason = "reset"
+	// closed after a reject message of the node
+	DeadReject DeadReason = "reject"
+	// connected but no version and verack in HANDSHAKE_TIMEOUT or closed before them
+	DeadHandshake DeadReason = "handshake incomplete"
+	// too many open files on our side and the unknown dial errors
+	DeadOther DeadReason = "other"
+)
+
+// DeadReason classifies the error returned by Connect, empty without an error
+func (n *Node) DeadReason(err error) DeadReason {
+	if err == nil {
+		return ""
+	}
+	switch n.dialErr {
+	case "":
+	case DialErrRefused:
+		return DeadRefused
+	case DialErrTimeout:
+		return DeadTimeout
+	case DialErrUnreachable, DialErrFamily:
+		return DeadUnroutable
+	default:
+		return DeadOther
+	}
+	// our close on the handshake timeout ends the listener with a read error too
+	if n.timedOut == TimeoutHandshake {
+		return DeadHandshake
+	}
+	if isReset(err) || isReset(n.readErr) {
+		return DeadReset
+	}
+	if n.peerReject != "" {
+		return DeadReject
+	}
+	return DeadHandshake
+}
+
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// DialErr is a class of the dial error, used for the retry policy
+type DialErr string
+
+const (
+	DialErrRefused     DialErr = "refused"
+	DialErrTimeout     DialErr = "timeout"
+	DialErrUnreachable DialErr = "unreachable"
+	// too many open files on our side, the node is fine
+	DialErrFiles DialErr = "files"
+	// the node is not of the LOCAL_ADDR family, not dialed
+	DialErrFamily DialErr = "family"
+	DialErrOther  DialErr = "other"
+)
+
+// ErrLocalAddrFamily is the dial of a node the LOCAL_ADDR can't reach,
+// an ipv4 source can't reach an ipv6 host and the other way
+var ErrLocalAddrFamily = errors.New("not of the LOCAL_ADDR address family")
+
+// Retryable errors may be transient, refused means the node is down
+func (e DialErr) Retryable() bool {
+	return e == DialErrTimeout || e == DialErrFiles
+}
+
+// LOCAL_ADDR parsed once, nil without it
+var (
+	localIPOnce sync.Once
+	localIP     net.IP
+)
+
+// localAddr is the LOCAL_ADDR bind of the connection to the host:port, nil without it.
+// ErrLocalAddrFamily if the families differ, the hostnames are resolved to the family
+// of the bind by the dialer
+func localAddr(target string) (*net.TCPAddr, error) {
+	localIPOnce.Do(func() { localIP = net.ParseIP(cfg.LocalAddr) })
+	if localIP == nil {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.To4() == nil) != (localIP.To4() == nil) {
+		return nil, fmt.Errorf("%s is %w %s", host, ErrLocalAddrFamily, cfg.LocalAddr)
+	}
+	return &net.TCPAddr{IP: localIP}, nil
+}
+
+// dial directly or via the socks5 proxy if configured,
+// proxy resolves the hostnames so onion addresses work with tor.
+// LOCAL_ADDR binds the connection to the node or to the proxy, the ones of the other
+// family are not dialed so every connection comes from it
+func dial(ctx context.Context, log logger.Interface, addr string) (net.Conn, error) {
+	// keepalive is set by Connect, the probes are not needed for the short dials
+	direct := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: -1}
+	target := addr
+	if cfg.Proxy != "" {
+		target = cfg.Proxy
+	}
+	local, err := localAddr(target)
+	if err != nil {
+		log.Debugf("not dialed: %v", err)
+		return nil, err
+	}
+	if local != nil {
+		direct.LocalAddr = local
+	}
+	if cfg.Proxy == "" {
+		return direct.DialContext(ctx, "tcp", addr)
+	}
+	d, err := proxy.SOCKS5("tcp", cfg.Proxy, nil, direct)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+	defer cancel()
+	return d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// the OS finds the dead peers of the long connections even when the node is silent
+// and the NATs keep the idle flows, with a proxy the probes reach the proxy only
+func setKeepAlive(log logger.Interface, conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if cfg.TCPKeepAlive == 0 {
+		_ = tcp.SetKeepAlive(false)
+		return
+	}
+	if err := tcp.SetKeepAlive(true); err != nil {
+		log.Debugf("failed to enable keepalive: %v", err)
+		return
+	}
+	if err := tcp.SetKeepAlivePeriod(cfg.TCPKeepAlive); err != nil {
+		log.Debugf("failed to set keepalive period: %v", err)
+	}
+}
+
+func classifyDialErr(err error) DialErr {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrLocalAddrFamily):
+		return DialErrFamily
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialErrRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return DialErrUnreachable
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return DialErrFiles
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return DialErrTimeout
+	default:
+		return DialErrOther
+	}
+}
@@ -0,0 +1,113 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+)
+
+// testLogger logs to the test output
+type testLogger struct {
+	t      testing.TB
+	fields string
+}
+
+func (l testLogger) log(level string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf("%s%s %s", level, l.fields, fmt.Sprint(args...))
+}
+
+func (l testLogger) Debug(args ...interface{}) { l.log("DEBU", args...) }
+func (l testLogger) Debugf(format string, args ...interface{}) {
+	l.log("DEBU", fmt.Sprintf(format, args...))
+}
+func (l testLogger) Info(args ...interface{}) { l.log("INFO", args...) }
+func (l testLogger) Infof(format string, args ...interface{}) {
+	l.log("INFO", fmt.Sprintf(format, args...))
+}
+func (l testLogger) Warn(args ...interface{}) { l.log("WARN", args...) }
+func (l testLogger) Warnf(format string, args ...interface{}) {
+	l.log("WARN", fmt.Sprintf(format, args...))
+}
+func (l testLogger) Errorf(format string, args ...interface{}) {
+	l.log("ERRO", fmt.Sprintf(format, args...))
+}
+func (l testLogger) Fatal(args ...interface{}) { l.t.Fatal(args...) }
+
+func (l testLogger) With(key string, value interface{}) logger.Interface {
+	l.fields += fmt.Sprintf(" %s=%v", key, value)
+	return l
+}
+
+// a local bitcoind -regtest, the network is set for all the packages on start:
+// REGTEST=1 REGTEST_NODE=127.0.0.1:18444 go test ./internal/client/node -run Regtest -v
+func TestRegtestHandshake(t *testing.T) {
+	addr := os.Getenv("REGTEST_NODE")
+	if addr == "" {
+		t.Skip("REGTEST_NODE is not set")
+	}
+	if cfg.Network != config.NetworkRegtest {
+		t.Fatal("REGTEST=1 is required with REGTEST_NODE")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := NewNode(testLogger{t: t}, addr, make(chan AddrBatch, 16))
+	resCh := make(chan *Node, 1)
+	errCh := make(chan error, 1)
+	go func() { errCh <- n.Connect(ctx, resCh) }()
+
+	select {
+	case <-resCh:
+	case err := <-errCh:
+		t.Fatalf("connect to %s: %v", addr, err)
+	case <-time.After(cfg.DialTimeout + cfg.HandshakeTimeout + 5*time.Second):
+		t.Fatalf("no handshake with %s", addr)
+	}
+	if !n.IsHandshaked() {
+		t.Error("reported before the handshake")
+	}
+	if n.Version() == 0 {
+		t.Error("no version")
+	}
+	if n.UserAgent() == "" {
+		t.Error("no user agent")
+	}
+	t.Logf("%s: version %d, %s, services %s, height %d",
+		addr, n.Version(), n.UserAgent(), n.Services(), n.Height())
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("connect after cancel: %v", err)
+		}
+	case <-time.After(cfg.PingTimeout + 5*time.Second):
+		t.Error("connect did not return after cancel")
+	}
+}
+
+func TestNetGroup(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"1.2.3.4", "1.2.0.0/16"},
+		{"1.2.200.1", "1.2.0.0/16"},
+		{"::ffff:1.2.3.4", "1.2.0.0/16"},
+		{"2001:db8:1:2::1", "2001:db8::/32"},
+		// the random names are one group whatever the first character
+		{"abcdefghijklmnopqrstuvwxyz234567abcdefghijklmnopqrstuvwx.onion", "onion"},
+		{"zyxwvutsrqponmlkjihgfedcba765432zyxwvutsrqponmlkjihgfedcba.onion", "onion"},
+		{"seed.example.com", "other"},
+	}
+	for _, tt := range tests {
+		if got := NetGroup(tt.host); got != tt.want {
+			t.Errorf("NetGroup(%s) = %s, want %s", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/1F47E/go-btc-xray/internal/logger"
+)
+
+// version nonces of the open connections, a version coming back
+// with one of them means we have connected to ourselves
+var (
+	noncesMu sync.Mutex
+	nonces   = make(map[uint64]struct{})
+)
+
+func newNonce() uint64 {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	nonce := binary.LittleEndian.Uint64(b[:])
+	noncesMu.Lock()
+	nonces[nonce] = struct{}{}
+	noncesMu.Unlock()
+	return nonce
+}
+
+func releaseNonce(nonce uint64) {
+	noncesMu.Lock()
+	delete(nonces, nonce)
+	noncesMu.Unlock()
+}
+
+func isOurNonce(nonce uint64) bool {
+	noncesMu.Lock()
+	defer noncesMu.Unlock()
+	_, ok := nonces[nonce]
+	return ok
+}
+
+// peers tell our address in AddrYou, one peer may lie or see a proxy,
+// the address is ours once this many distinct peers agree
+const externalVotes = 2
+
+var (
+	externalMu sync.Mutex
+	// voters by the ip we were seen as
+	externalSeen = make(map[string]map[string]struct{})
+	external     = make(map[string]struct{})
+)
+
+// learnExternal counts the AddrYou of the peer, loopback and unspecified
+// addresses are shared by all the local nodes and never learned
+func learnExternal(log logger.Interface, ip net.IP, from string) {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return
+	}
+	addr := ip.String()
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	if _, ok := external[addr]; ok {
+		return
+	}
+	voters, ok := externalSeen[addr]
+	if !ok {
+		voters = make(map[string]struct{})
+		externalSeen[addr] = voters
+	}
+	voters[from] = struct{}{}
+	if len(voters) >= externalVotes {
+		external[addr] = struct{}{}
+		delete(externalSeen, addr)
+		log.Infof("our external address is %s, skipping it", addr)
+	}
+}
+
+// IsOurAddress is true if the peers agreed the host of the node is our external address
+func (n *Node) IsOurAddress() bool {
+	ip := net.ParseIP(n.ip)
+	if ip == nil {
+		return false
+	}
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	_, ok := external[ip.String()]
+	return ok
+}
+
+// MarkSelf rejects the node before the dial, see IsOurAddress
+func (n *Node) MarkSelf() {
+	n.setStatus(rejected)
+	n.rejected = RejectSelf
+}
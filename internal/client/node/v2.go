@@ -0,0 +1,48 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BIP324 v2 transport starts with a 64 byte ElligatorSwift encoded public key.
+// any 64 bytes decode to a valid key, so random bytes look like a real initiator
+// and a v2 peer answers with its own key. a v1 peer sees no network magic and
+// disconnects. the shared secret is not derived, the connection is closed after
+// the key and the node is dialed again as v1
+const v2KeySize = 64
+
+// probeV2 returns true if the peer answered the v2 handshake,
+// the errors are logged by the caller, the node is dialed as v1 anyway
+func (n *Node) probeV2(ctx context.Context) (bool, error) {
+	conn, err := dial(ctx, n.log, n.EndpointSafe())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn = &countingConn{Conn: conn}
+	_ = conn.SetDeadline(time.Now().Add(cfg.HandshakeTimeout))
+
+	key := make([]byte, v2KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return false, fmt.Errorf("failed to generate key: %w", err)
+	}
+	// v1 peers check the magic after the first bytes,
+	// random bytes matching the magic are practically impossible
+	if _, err := conn.Write(key); err != nil {
+		return false, nil
+	}
+	answer := make([]byte, v2KeySize)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// SupportsV2 is true if the node answered the v2 handshake, always false without V2_PROBE
+func (n *Node) SupportsV2() bool {
+	return n.supportsV2
+}
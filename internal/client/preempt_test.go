@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/config"
+)
+
+// the preempter closes the reported connections while their connect and listen
+// goroutines still run, go test -race covers the close from the other goroutine
+func TestPreemptReported(t *testing.T) {
+	defer func(dataDir string, idle time.Duration, max int) {
+		cfg.DataDir, cfg.PreemptIdle, cfg.PreemptMax = dataDir, idle, max
+	}(cfg.DataDir, cfg.PreemptIdle, cfg.PreemptMax)
+	cfg.DataDir, cfg.PreemptIdle, cfg.PreemptMax = t.TempDir(), time.Minute, 10
+
+	c := NewClient(context.Background(), nopLogger{}, nil)
+	defer c.exit()
+	c.queue = newNodeQueue(config.QueueSortFIFO)
+
+	const peers = 3
+	var addrs []string
+	for i := 0; i < peers; i++ {
+		addrs = append(addrs, fakePeer(t))
+	}
+	announce(c, addrs...)
+	nodes := pop(c, peers)
+	// a node waits for a slot
+	announce(c, "10.1.0.1:8333")
+	c.connLimit = peers
+	atomic.StoreInt32(&c.activeConns, peers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChs := make([]chan error, len(nodes))
+	for i, n := range nodes {
+		resCh := make(chan *node.Node, 1)
+		errChs[i] = make(chan error, 1)
+		c.connStarted(n)
+		go func(n *node.Node, errCh chan error) { errCh <- n.Connect(ctx, resCh) }(n, errChs[i])
+		select {
+		case <-resCh:
+		case err := <-errChs[i]:
+			t.Fatalf("%s: %v", n.Endpoint(), err)
+		case <-time.After(cfg.HandshakeTimeout + 5*time.Second):
+			t.Fatalf("%s: no handshake", n.Endpoint())
+		}
+	}
+
+	if got := c.preempt(time.Now(), peers); got != 0 {
+		t.Errorf("preempted %d connections before PREEMPT_IDLE", got)
+	}
+	idle := time.Now().Add(cfg.PreemptIdle)
+	if got := c.preempt(idle, peers-1); got != peers-1 {
+		t.Errorf("preempted %d, want the max of %d", got, peers-1)
+	}
+	if got := c.preempt(idle, peers); got != 1 {
+		t.Errorf("preempted %d, want the last 1", got)
+	}
+	for i, n := range nodes {
+		select {
+		case <-errChs[i]:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: connect did not return after the preemption", n.Endpoint())
+		}
+		c.connFinished(n)
+		if n.IsConnected() {
+			t.Errorf("%s still connected", n.Endpoint())
+		}
+	}
+	if got := atomic.LoadInt64(&c.preempted); got != peers {
+		t.Errorf("preempted count %d, want %d", got, peers)
+	}
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/config"
+)
+
+// new nodes waiting for the dial, the highest score is dialed first,
+// ties go in the order they came. not safe for concurrent use, guarded by the client lock
+type nodeQueue struct {
+	strategy config.QueueSort
+	items    []*queueItem
+	queued   map[*node.Node]*queueItem
+	seq      uint64
+	rnd      *rand.Rand
+
+	// the retries waiting for their delay, see retry
+	delayed retryHeap
+}
+
+type queueItem struct {
+	node *node.Node
+	// last time the address was announced, zero for the retries
+	announcedAt time.Time
+	// the retry is not dialed before, zero once released
+	due time.Time
+	// random score for the random strategy, fixed on push
+	random int64
+	seq    uint64
+	pos    int
+}
+
+func newNodeQueue(strategy config.QueueSort) *nodeQueue {
+	return &nodeQueue{
+		strategy: strategy,
+		queued:   make(map[*node.Node]*queueItem),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// push queues the node, at is when it was announced, zero puts it behind the fresh ones
+func (q *nodeQueue) push(n *node.Node, at time.Time) {
+	if _, ok := q.queued[n]; ok {
+		return
+	}
+	q.seq++
+	item := &queueItem{node: n, announcedAt: at, random: q.rnd.Int63(), seq: q.seq}
+	q.queued[n] = item
+	heap.Push(q, item)
+}
+
+// retry queues the node again once due, see release
+func (q *nodeQueue) retry(n *node.Node, due time.Time) {
+	if _, ok := q.queued[n]; ok {
+		return
+	}
+	q.seq++
+	item := &queueItem{node: n, due: due, random: q.rnd.Int63(), seq: q.seq}
+	q.queued[n] = item
+	heap.Push(&q.delayed, item)
+}
+
+// release moves the retries due by now to the dial order, behind the fresh ones
+func (q *nodeQueue) release(now time.Time) {
+	for len(q.delayed) > 0 && !q.delayed[0].due.After(now) {
+		item := heap.Pop(&q.delayed).(*queueItem)
+		item.due = time.Time{}
+		heap.Push(q, item)
+	}
+}
+
+// pending is the queued nodes with the delayed retries
+func (q *nodeQueue) pending() int {
+	return len(q.items) + len(q.delayed)
+}
+
+// announced moves the queued node up after one more peer sent its address
+func (q *nodeQueue) announced(n *node.Node, at time.Time) {
+	item, ok := q.queued[n]
+	if !ok {
+		return
+	}
+	item.announcedAt = at
+	// a delayed retry takes its place on release
+	if !item.due.IsZero() {
+		return
+	}
+	heap.Fix(q, item.pos)
+}
+
+// pop returns nil if the queue is empty
+func (q *nodeQueue) pop() *node.Node {
+	if len(q.items) == 0 {
+		return nil
+	}
+	item := heap.Pop(q).(*queueItem)
+	delete(q.queued, item.node)
+	return item.node
+}
+
+// higher is dialed first
+func (q *nodeQueue) score(item *queueItem) int64 {
+	switch q.strategy {
+	case config.QueueSortAnnounces:
+		return int64(item.node.AnnounceCount())
+	case config.QueueSortFresh:
+		if item.announcedAt.IsZero() {
+			return 0
+		}
+		return item.announcedAt.UnixNano()
+	case config.QueueSortRandom:
+		return item.random
+	default:
+		return 0
+	}
+}
+
+// heap.Interface
+
+func (q *nodeQueue) Len() int { return len(q.items) }
+
+func (q *nodeQueue) Less(i, j int) bool {
+	si, sj := q.score(q.items[i]), q.score(q.items[j])
+	if si != sj {
+		return si > sj
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *nodeQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].pos = i
+	q.items[j].pos = j
+}
+
+func (q *nodeQueue) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.pos = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *nodeQueue) Pop() interface{} {
+	last := len(q.items) - 1
+	item := q.items[last]
+	q.items[last] = nil
+	q.items = q.items[:last]
+	return item
+}
+
+// retryHeap orders the delayed retries by the due time
+type retryHeap []*queueItem
+
+func (h retryHeap) Len() int           { return len(h) }
+func (h retryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h retryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *retryHeap) Push(x interface{}) {
+	*h = append(*h, x.(*queueItem))
+}
+
+func (h *retryHeap) Pop() interface{} {
+	old := *h
+	last := len(old) - 1
+	item := old[last]
+	old[last] = nil
+	*h = old[:last]
+	return item
+}
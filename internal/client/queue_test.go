@@ -0,0 +1,197 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(args ...interface{})                 {}
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Info(args ...interface{})                  {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Warn(args ...interface{})                  {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+func (nopLogger) Fatal(args ...interface{})                 {}
+
+func (l nopLogger) With(key string, value interface{}) logger.Interface { return l }
+
+func testNode(i int) *node.Node {
+	return node.NewNode(nopLogger{}, fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff), nil)
+}
+
+func TestNodeQueueOrder(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		strategy config.QueueSort
+		want     []int
+	}{
+		// in the order they came
+		{config.QueueSortFIFO, []int{0, 1, 2, 3}},
+		// 1 announced last, then 2 and the rest in the order they came
+		{config.QueueSortFresh, []int{1, 2, 0, 3}},
+		// 1 by three peers, 2 by two
+		{config.QueueSortAnnounces, []int{1, 2, 0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			// new nodes for every strategy, the announce counts are kept by the nodes
+			nodes := make([]*node.Node, 4)
+			for i := range nodes {
+				nodes[i] = testNode(i)
+			}
+			q := newNodeQueue(tt.strategy)
+			for i, n := range nodes[:3] {
+				q.push(n, start.Add(time.Duration(i)*time.Second))
+				n.Announced("peer0")
+			}
+			// a retry goes behind the announced ones with the fresh strategy
+			q.push(nodes[3], time.Time{})
+			for i, n := range []*node.Node{nodes[1], nodes[1], nodes[2], nodes[1]} {
+				if n.Announced(fmt.Sprintf("peer%d", i+1)) {
+					q.announced(n, start.Add(time.Duration(10+i)*time.Second))
+				}
+			}
+			// queued once
+			q.push(nodes[0], start)
+			var got []int
+			for n := q.pop(); n != nil; n = q.pop() {
+				for i := range nodes {
+					if nodes[i] == n {
+						got = append(got, i)
+					}
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+			if len(q.queued) != 0 {
+				t.Errorf("%d left in the queued map", len(q.queued))
+			}
+		})
+	}
+}
+
+// the retries wait for their delay and go in the order they are due
+func TestNodeQueueRetryDelay(t *testing.T) {
+	start := time.Now()
+	nodes := []*node.Node{testNode(0), testNode(1), testNode(2)}
+	q := newNodeQueue(config.QueueSortFIFO)
+	q.retry(nodes[0], start.Add(2*time.Second))
+	q.retry(nodes[1], start.Add(time.Second))
+	// queued once
+	q.retry(nodes[1], start)
+	q.push(nodes[1], start)
+	q.push(nodes[2], start)
+	if got := q.pending(); got != 3 {
+		t.Errorf("pending %d, want 3", got)
+	}
+	step := func(now time.Time, want ...*node.Node) {
+		t.Helper()
+		q.release(now)
+		for _, w := range want {
+			if n := q.pop(); n != w {
+				t.Fatalf("popped %v, want %s", n, w.Endpoint())
+			}
+		}
+		if n := q.pop(); n != nil {
+			t.Fatalf("popped %s before its delay", n.Endpoint())
+		}
+	}
+	step(start, nodes[2])
+	step(start.Add(time.Second), nodes[1])
+	// announced while delayed
+	q.announced(nodes[0], start.Add(1500*time.Millisecond))
+	step(start.Add(2*time.Second), nodes[0])
+	if q.pending() != 0 || len(q.queued) != 0 {
+		t.Errorf("%d pending and %d in the queued map left", q.pending(), len(q.queued))
+	}
+}
+
+// simulateCrawl returns the good nodes per dial of a crawl with the queue
+// strategy. the peers announce the live nodes more often than the dead ones
+// and the dials lag behind the announcements, the queue grows like in a crawl
+func simulateCrawl(strategy config.QueueSort, seed int64) float64 {
+	const (
+		addrs        = 20000
+		liveShare    = 0.2
+		liveWeight   = 4
+		peers        = 50
+		ticks        = 200
+		announcesPer = 200
+		dialsPer     = 20
+	)
+	rnd := rand.New(rand.NewSource(seed))
+	live := make([]bool, addrs)
+	var weighted []int
+	for i := range live {
+		live[i] = rnd.Float64() < liveShare
+		weighted = append(weighted, i)
+		if live[i] {
+			for w := 1; w < liveWeight; w++ {
+				weighted = append(weighted, i)
+			}
+		}
+	}
+	nodes := make([]*node.Node, addrs)
+	dialed := make(map[*node.Node]bool)
+	isLive := make(map[*node.Node]bool)
+	q := newNodeQueue(strategy)
+	q.rnd = rand.New(rand.NewSource(seed))
+	now := time.Unix(1700000000, 0)
+	good, dials := 0, 0
+	for tick := 0; tick < ticks; tick++ {
+		now = now.Add(time.Second)
+		for a := 0; a < announcesPer; a++ {
+			i := weighted[rnd.Intn(len(weighted))]
+			from := fmt.Sprintf("peer%d", rnd.Intn(peers))
+			n := nodes[i]
+			if n == nil {
+				n = testNode(i)
+				nodes[i] = n
+				isLive[n] = live[i]
+				q.push(n, now)
+			}
+			if dialed[n] {
+				continue
+			}
+			if n.Announced(from) {
+				q.announced(n, now)
+			}
+		}
+		for d := 0; d < dialsPer; d++ {
+			n := q.pop()
+			if n == nil {
+				break
+			}
+			dialed[n] = true
+			dials++
+			if isLive[n] {
+				good++
+			}
+		}
+	}
+	return float64(good) / float64(dials)
+}
+
+func TestQueueSortYield(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		fifo := simulateCrawl(config.QueueSortFIFO, seed)
+		random := simulateCrawl(config.QueueSortRandom, seed)
+		for _, strategy := range []config.QueueSort{config.QueueSortFresh, config.QueueSortAnnounces} {
+			yield := simulateCrawl(strategy, seed)
+			t.Logf("seed %d: %s %.3f good per dial, fifo %.3f, random %.3f", seed, strategy, yield, fifo, random)
+			if yield <= fifo {
+				t.Errorf("seed %d: %s yield %.3f is not over fifo %.3f", seed, strategy, yield, fifo)
+			}
+		}
+	}
+}
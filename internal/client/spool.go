@@ -0,0 +1,126 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// bytes read from the spool at once, about 3000 addresses
+const spoolChunk = 64 * 1024
+
+// spool is the dial queue on disk for DISK_QUEUE, the addresses are appended as lines
+// and read back in the same order, only a chunk is in memory. the file is truncated
+// every time the reader catches up. the disk is not touched under the client lock,
+// the spool has its own
+type spool struct {
+	mu       sync.Mutex
+	closed   bool
+	file     *os.File
+	path     string
+	readOff  int64
+	writeOff int64
+	// addresses read from the file, not popped yet
+	head []string
+	len  int
+}
+
+// openSpool creates a new file for every client, the daemon cycles may overlap on exit
+func openSpool() (*spool, error) {
+	file, err := os.CreateTemp(cfg.DataDir, cfg.QueueFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create queue file: %v", err)
+	}
+	return &spool{file: file, path: file.Name()}, nil
+}
+
+// push appends the addresses in one write, os.ErrClosed after close
+func (s *spool) push(addrs []string) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	data := []byte(strings.Join(addrs, "\n") + "\n")
+	if _, err := s.file.WriteAt(data, s.writeOff); err != nil {
+		return fmt.Errorf("failed to write queue file: %v", err)
+	}
+	s.writeOff += int64(len(data))
+	s.len += len(addrs)
+	return nil
+}
+
+// pop returns false if the spool is empty or closed
+func (s *spool) pop() (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return "", false, nil
+	}
+	if len(s.head) == 0 {
+		if err := s.fill(); err != nil {
+			return "", false, err
+		}
+		if len(s.head) == 0 {
+			return "", false, nil
+		}
+	}
+	addr := s.head[0]
+	s.head[0] = ""
+	s.head = s.head[1:]
+	s.len--
+	return addr, true, nil
+}
+
+// read the next chunk of whole lines, truncate the file when all is read.
+// called under the spool lock
+func (s *spool) fill() error {
+	if s.readOff == s.writeOff {
+		if s.writeOff > 0 {
+			if err := s.file.Truncate(0); err != nil {
+				return fmt.Errorf("failed to truncate queue file: %v", err)
+			}
+			s.readOff, s.writeOff = 0, 0
+		}
+		return nil
+	}
+	size := s.writeOff - s.readOff
+	if size > spoolChunk {
+		size = spoolChunk
+	}
+	buf := make([]byte, size)
+	if _, err := s.file.ReadAt(buf, s.readOff); err != nil {
+		return fmt.Errorf("failed to read queue file: %v", err)
+	}
+	end := bytes.LastIndexByte(buf, '\n')
+	if end < 0 {
+		return fmt.Errorf("queue file line at %d is over %d bytes", s.readOff, spoolChunk)
+	}
+	s.head = strings.Split(string(buf[:end]), "\n")
+	s.readOff += int64(end + 1)
+	return nil
+}
+
+// Len returns the addresses waiting on disk and in the read chunk
+func (s *spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.len
+}
+
+// close removes the file, the queue is useless without the known filter
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.file.Close()
+	return os.Remove(s.path)
+}
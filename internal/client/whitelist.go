@@ -0,0 +1,161 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/storage"
+)
+
+// FilterNotWhitelisted counts the addresses outside of WHITELIST in the summary
+const FilterNotWhitelisted = "not whitelisted"
+
+// whitelist of WHITELIST, the only addresses queued. an endpoint matches its port only,
+// a bare host and a cidr range match all the ports
+type whitelist struct {
+	mu        sync.RWMutex
+	endpoints map[string]struct{}
+	hosts     map[string]struct{}
+	nets      []*net.IPNet
+}
+
+// readWhitelist parses the file, one host:port, bare host or cidr per line, # starts a comment.
+// the list is nil on error, the lines of the malformed error are skipped
+func readWhitelist(path string) (*whitelist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := &whitelist{endpoints: make(map[string]struct{}), hosts: make(map[string]struct{})}
+	var malformed []int
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if !w.add(text) {
+			malformed = append(malformed, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(malformed) > 0 {
+		return w, fmt.Errorf("%s: %d malformed lines skipped, first on line %d", path, len(malformed), malformed[0])
+	}
+	return w, nil
+}
+
+func (w *whitelist) add(entry string) bool {
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return false
+		}
+		w.nets = append(w.nets, ipnet)
+		return true
+	}
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		if host == "" || port == "" {
+			return false
+		}
+		w.endpoints[storage.NormalizeEndpoint(entry)] = struct{}{}
+		return true
+	}
+	// bare ipv6 without the brackets has colons too
+	if strings.ContainsAny(entry, " []") {
+		return false
+	}
+	w.hosts[banKey(entry)] = struct{}{}
+	return true
+}
+
+func (w *whitelist) size() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.endpoints) + len(w.hosts) + len(w.nets)
+}
+
+// replace the entries with the ones of next
+func (w *whitelist) set(next *whitelist) {
+	w.mu.Lock()
+	w.endpoints, w.hosts, w.nets = next.endpoints, next.hosts, next.nets
+	w.mu.Unlock()
+}
+
+func (w *whitelist) allowed(addr string) bool {
+	key := storage.NormalizeEndpoint(addr)
+	host, _, _ := net.SplitHostPort(key)
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if _, ok := w.endpoints[key]; ok {
+		return true
+	}
+	if _, ok := w.hosts[host]; ok {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, ipnet := range w.nets {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nothing is queued if the file can't be read, the crawl stays in the closed world
+func (c *Client) loadWhitelist() {
+	list, err := readWhitelist(cfg.Whitelist)
+	if list == nil {
+		list = &whitelist{endpoints: make(map[string]struct{}), hosts: make(map[string]struct{})}
+	}
+	if err != nil {
+		c.log.Errorf("whitelist: %v", err)
+	}
+	c.whitelist = list
+	c.log.Infof("whitelist of %d entries", list.size())
+}
+
+// the batch without the addresses outside of WHITELIST
+func (c *Client) dropNotWhitelisted(batch node.AddrBatch) node.AddrBatch {
+	if c.whitelist == nil {
+		return batch
+	}
+	kept, dropped := filterBatch(batch, c.whitelist.allowed)
+	atomic.AddInt64(&c.notWhitelisted, int64(dropped))
+	return kept
+}
+
+// ReloadWhitelist reads WHITELIST again on SIGHUP, the queued addresses are kept.
+// a file with malformed lines or no entries at all may be half written, the list is kept
+func (c *Client) ReloadWhitelist() {
+	if c.whitelist == nil {
+		return
+	}
+	next, err := readWhitelist(cfg.Whitelist)
+	if err != nil {
+		c.log.Errorf("reload: whitelist not changed: %v", err)
+		return
+	}
+	if next.size() == 0 {
+		c.log.Warnf("reload: whitelist not changed: no entries in %s", cfg.Whitelist)
+		return
+	}
+	c.whitelist.set(next)
+	c.log.Infof("reload: whitelist of %d entries", next.size())
+}
@@ -1,123 +1,378 @@
 package client
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"sync/atomic"
 	"time"
 
-	"github.com/1F47E/go-btc-xray/internal/gui"
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/jitter"
+	"github.com/1F47E/go-btc-xray/internal/metrics"
+	"github.com/1F47E/go-btc-xray/internal/stats"
 	"github.com/1F47E/go-btc-xray/internal/storage"
 )
 
 // listen for new nodes from the connected nodes
 func (c *Client) wNewAddrListner() {
-	c.log.Debug("[CLIENT]: LISTENER worker started")
-	defer c.log.Debug("[CLIENT]: LISTNER worker exited")
-
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("LISTENER worker started")
+	defer c.log.Debug("LISTNER worker exited")
+	var graph *storage.GraphLog
+	if cfg.RecordGraph {
+		var err error
+		if graph, err = storage.NewGraphLog(); err != nil {
+			c.log.Errorf("failed to open graph: %v", err)
+		} else {
+			defer func() {
+				if graph != nil {
+					graph.Close()
+				}
+			}()
+		}
+	}
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
-		case ips := <-c.newAddrCh:
-			c.AddNodes(ips)
+		case batch := <-c.newAddrCh:
+			c.addAnnounced(batch)
+			if graph == nil {
+				continue
+			}
+			// the rest of the edges would be lost anyway, the crawl goes on
+			if err := graph.Append(batch.From, batch.Addrs, batch.At); err != nil {
+				c.log.Errorf("%v, graph recording stopped", err)
+				graph.Close()
+				graph = nil
+			}
 		}
 	}
 }
 
 // feed the queue with new nodes
 func (c *Client) wNodesFeeder() {
+	defer metrics.Track(metrics.RoleWorker)()
+	defer c.closeSpool()
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
-			if len(c.nodesNew) == 0 {
+			n := c.nextNode()
+			if n == nil {
 				// do not overload the cpu by spinning to fast
 				time.Sleep(time.Millisecond * 100)
 				continue
 			}
-			n := c.nodesNew[0]
-			// feed the first node from the new nodes
-			// pop it from the new slice for garbage collection
-			// will block if queue is full
-			c.nodesNew = c.nodesNew[1:]
-			c.queueCh <- n
+			// feed the best scored node, will block if queue is full
+			select {
+			case <-c.ctx.Done():
+				return
+			case c.queueCh <- n:
+			}
+		}
+	}
+}
+
+// stop the client when the queue is drained, all the connections are done
+// and no new addresses came for the drain timeout
+func (c *Client) wDrainWatcher() {
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("DRAIN worker started")
+	defer c.log.Debug("DRAIN worker exited")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if c.queued() > 0 || len(c.queueCh) > 0 || len(c.newAddrCh) > 0 || c.ActiveConns() > 0 {
+				continue
+			}
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastAddrAt)))
+			if idle < cfg.DrainTimeout {
+				continue
+			}
+			c.log.Infof("queue drained, no new addresses for %s", idle.Round(time.Second))
+			c.Stop("queue drained")
+			return
 		}
 	}
 }
 
 // get errors from the nodes connections
 func (c *Client) wNodeResultsHandler() {
-	c.log.Debug("[CLIENT]: ERRORS worker started")
-	defer c.log.Debug("[CLIENT]: ERRORS worker exited")
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("ERRORS worker started")
+	defer c.log.Debug("ERRORS worker exited")
+	// the only sender
+	defer close(c.goodCh)
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		case n := <-c.nodeResCh:
+			c.mu.Lock()
+			c.reachable[n.Endpoint()] = struct{}{}
+			// the node keeps running, only the good list skips it
+			if cfg.SkipLimited && n.Kind() == node.KindLimited {
+				c.limitedSkipped++
+				c.mu.Unlock()
+				c.log.Debugf("%s skipped, limited node", n.Endpoint())
+				continue
+			}
 			c.nodesGood = append(c.nodesGood, n)
+			if sc := c.seedCount(n.Origin()); sc != nil {
+				sc.Good++
+			}
+			c.goodKinds[n.Kind()]++
+			c.addrGood[n.AddrType()]++
+			c.ports[int(n.Port())]++
+			c.netgroups[n.NetGroup()]++
+			if c.geo != nil {
+				// the unknown ranges are not counted, the onions are an anonymous network
+				info := c.geo.Lookup(n.Host())
+				n.SetGeo(info)
+				if info.Country != "" {
+					c.countries[info.Country]++
+				}
+				if name := info.ASName(); name != "" {
+					c.asns[name]++
+				}
+			}
+			c.mu.Unlock()
+			if cfg.StreamFilename != "" {
+				if err := storage.AppendJSONL(filepath.Join(cfg.DataDir, cfg.StreamFilename), n); err != nil {
+					c.log.Errorf("failed to stream %s: %v", n.Endpoint(), err)
+				}
+			}
+			select {
+			case c.goodCh <- n:
+			default:
+				atomic.AddInt64(&c.goodDropped, 1)
+			}
 		}
 	}
 }
 
-// save good nodes to a file
+// append new good and rejected nodes to the nodes logs every second,
+// rewrite the whole nodes file less often
 func (c *Client) wNodeSaver() {
-	c.log.Debug("[CLIENT]: SAVER worker started")
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("SAVER worker started")
+	nodesLog, err := storage.NewNodesLog(c.log)
+	if err != nil {
+		c.log.Errorf("failed to open nodes log: %v", err)
+	}
+	rejectedLog, err := storage.NewRejectedLog(c.log)
+	if err != nil {
+		c.log.Errorf("failed to open rejected nodes log: %v", err)
+	}
 	ticker := time.NewTicker(time.Second * 1)
-	cnt := 0
+	saveInterval := time.Duration(atomic.LoadInt64(&c.saveInterval))
+	saveTicker := time.NewTicker(saveInterval)
+	appended, appendedRejected, saved := 0, 0, 0
+	// the disk is shared, a failed append also delays the save and the other way around
+	var retry backoff
+	appendNew := func() {
+		c.mu.Lock()
+		// the flags are saved with the nodes
+		if len(c.nodesGood) > appended {
+			c.classifyHeights()
+		}
+		nodes := c.nodesGood[appended:]
+		rejected := c.nodesRejected[appendedRejected:]
+		c.mu.Unlock()
+		if !retry.ready(time.Now()) {
+			return
+		}
+		if len(nodes) > 0 && nodesLog != nil {
+			if err := nodesLog.Append(nodes); err != nil {
+				c.log.Errorf("failed to append nodes: %v, next try in %s", err, retry.fail(time.Now()))
+				return
+			}
+			retry.reset()
+			appended += len(nodes)
+		}
+		if len(rejected) > 0 && rejectedLog != nil {
+			if err := rejectedLog.Append(rejected); err != nil {
+				c.log.Errorf("failed to append rejected nodes: %v, next try in %s", err, retry.fail(time.Now()))
+				return
+			}
+			retry.reset()
+			appendedRejected += len(rejected)
+		}
+	}
 	defer func() {
-		c.log.Debug("[CLIENT]: SAVER worker exited")
+		appendNew()
+		if nodesLog != nil {
+			nodesLog.Close()
+		}
+		if rejectedLog != nil {
+			rejectedLog.Close()
+		}
+		c.log.Debug("SAVER worker exited")
 		ticker.Stop()
+		saveTicker.Stop()
 	}()
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		case <-ticker.C:
-			if len(c.nodesGood) == cnt {
+			appendNew()
+			if d := time.Duration(atomic.LoadInt64(&c.saveInterval)); d != saveInterval {
+				saveInterval = d
+				saveTicker.Reset(d)
+			}
+		case <-saveTicker.C:
+			c.mu.Lock()
+			cnt := len(c.nodesGood)
+			c.mu.Unlock()
+			if cnt == saved || !retry.ready(time.Now()) {
 				continue
 			}
 			// save good nodes to a file
-			err := storage.Save(c.nodesGood)
-			if err != nil {
-				c.log.Errorf("[CLIENT]: STAT: failed to save nodes: %v\n", err)
-				continue
+			if c.SaveNodes() {
+				saved = cnt
+				retry.reset()
+			} else {
+				c.log.Warnf("next save try in %s", retry.fail(time.Now()))
 			}
-			c.log.Infof("[CLIENT]: saved %d nodes", len(c.nodesGood))
-			cnt = len(c.nodesGood)
 		}
 	}
 }
 
+// failed saves are retried with a doubling delay, a full disk
+// would fill the logs with the same error every second otherwise
+const (
+	saveBackoffMin = time.Second
+	saveBackoffMax = 5 * time.Minute
+)
+
+type backoff struct {
+	delay time.Duration
+	next  time.Time
+}
+
+func (b *backoff) ready(now time.Time) bool {
+	return !now.Before(b.next)
+}
+
+// fail doubles the delay and returns it with the jitter
+func (b *backoff) fail(now time.Time) time.Duration {
+	b.delay *= 2
+	if b.delay < saveBackoffMin {
+		b.delay = saveBackoffMin
+	}
+	if b.delay > saveBackoffMax {
+		b.delay = saveBackoffMax
+	}
+	delay := jitter.Apply(b.delay)
+	b.next = now.Add(delay)
+	return delay
+}
+
+func (b *backoff) reset() {
+	b.delay = 0
+	b.next = time.Time{}
+}
+
 // Connect to the nodes with a limit of connection
 // Number of workers = connections limit
 func (c *Client) wNodesConnector(n int) {
-	c.log.Debugf("[CLIENT]: CONN_%d worker started", n)
+	defer metrics.Track(metrics.RoleConnector)()
+	c.log.Debugf("CONN_%d worker started", n)
 	defer func() {
-		c.log.Debugf("[CLIENT]: CONN_%d worker exited", n)
+		c.log.Debugf("CONN_%d worker exited", n)
 	}()
 	for {
+		done, resized := c.connectorDone(n)
+		if done {
+			return
+		}
+		if !c.waitGoroutines() || !c.waitBandwidth() {
+			return
+		}
 		select {
 		case <-c.ctx.Done():
 			return
+		case <-resized:
+			// check the new limit
 		case n := <-c.queueCh:
+			// gossiped back to us, checked right before the dial
+			// as the address may be learned after the node was queued
+			if n.IsOurAddress() {
+				n.MarkSelf()
+				c.reject(n)
+				c.log.Debugf("%s skipped, our address", n.Endpoint())
+				continue
+			}
+			// banned after it was queued
+			if c.bans.banned(n.Host(), time.Now()) {
+				atomic.AddInt64(&c.banHits, 1)
+				c.log.Debugf("%s skipped, banned", n.Endpoint())
+				continue
+			}
 			atomic.AddInt32(&c.activeConns, 1)
+			c.connStarted(n)
 			err := n.Connect(c.ctx, c.nodeResCh)
-			if err != nil {
+			c.connFinished(n)
+			c.countDial(n.DialErr())
+			c.strike(n)
+			if err != nil && !errors.Is(err, node.ErrRejected) {
+				c.connFailed(n)
+			}
+			if errors.Is(err, node.ErrRejected) {
+				c.reject(n)
+			} else if err != nil && !c.retryDial(n) {
 				atomic.AddInt32(&c.nodesDeadCnt, 1)
-
+				c.mu.Lock()
+				c.deadReasons[n.DeadReason(err)]++
+				if sc := c.seedCount(n.Origin()); sc != nil {
+					sc.Dead++
+				}
+				c.mu.Unlock()
 			}
 			atomic.AddInt32(&c.activeConns, -1)
 		}
 	}
 }
 
-// Get stats of all the nodes, filter good ones, save them.
-func (c *Client) wGuiUpdater() {
-	c.log.Debug("[CLIENT]: STAT: worker started")
-	defer c.log.Debug("[CLIENT]: STAT: worker exited")
+// MAX_GOROUTINES, the connector does not take the next node while the process
+// is over the cap, the nodes wait in the queue. false on exit
+func (c *Client) waitGoroutines() bool {
+	if cfg.MaxGoroutines == 0 {
+		return true
+	}
+	for runtime.NumGoroutine() >= cfg.MaxGoroutines {
+		if atomic.CompareAndSwapInt32(&c.goroutinesCapped, 0, 1) {
+			c.log.Warnf("%d goroutines, dials wait for MAX_GOROUTINES", runtime.NumGoroutine())
+		}
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if atomic.CompareAndSwapInt32(&c.goroutinesCapped, 1, 0) {
+		c.log.Info("goroutines below MAX_GOROUTINES, dials resumed")
+	}
+	return true
+}
+
+// Collect stats of all the nodes and push them to the sink
+func (c *Client) wStatsUpdater() {
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("STAT: worker started")
+	defer c.log.Debug("STAT: worker exited")
 
-	// gui data update rate
+	// stats update rate
 	ticker := time.NewTicker(500 * time.Millisecond)
 	for {
 		select {
@@ -125,23 +380,296 @@ func (c *Client) wGuiUpdater() {
 			return
 		case <-ticker.C:
 
-			// send new data to gui
+			// send new stats to the sink
 			connCnt := c.ActiveConns()
 			deadCnt := atomic.LoadInt32(&c.nodesDeadCnt)
-			c.guiCh <- gui.IncomingData{
-				Connections: connCnt,
-				NodesTotal:  len(c.nodes),
-				NodesQueued: len(c.nodesNew),
-				NodesGood:   len(c.nodesGood),
-				NodesDead:   deadCnt,
+			c.mu.Lock()
+			rtts := make([]time.Duration, len(c.nodesGood))
+			good := make([]stats.NodeSummary, len(c.nodesGood))
+			heightMax, heightMedian, nodesStale, nodesLying := c.classifyHeights()
+			skews := make([]time.Duration, 0, len(c.nodesGood))
+			for i, n := range c.nodesGood {
+				rtts[i] = n.RTT()
+				if skew, ok := n.ClockSkew(); ok {
+					skews = append(skews, skew)
+				}
+				good[i] = stats.NodeSummary{
+					Endpoint:  n.Endpoint(),
+					RTT:       rtts[i],
+					UserAgent: n.UserAgent(),
+					Height:    n.Height(),
+				}
+			}
+			addrTotal := stats.AddrCounts{
+				IPv4:  c.addrTotal[node.AddrIPv4],
+				IPv6:  c.addrTotal[node.AddrIPv6],
+				Onion: c.addrTotal[node.AddrOnion],
+			}
+			addrGood := stats.AddrCounts{
+				IPv4:  c.addrGood[node.AddrIPv4],
+				IPv6:  c.addrGood[node.AddrIPv6],
+				Onion: c.addrGood[node.AddrOnion],
+			}
+			dialErrs := make(map[string]int, len(c.dialErrs))
+			for class, cnt := range c.dialErrs {
+				dialErrs[string(class)] = cnt
+			}
+			deadReasons := make(map[string]int, len(c.deadReasons))
+			for reason, cnt := range c.deadReasons {
+				deadReasons[string(reason)] = cnt
+			}
+			dialRetries := make(map[string]int, len(c.dialRetries))
+			for class, cnt := range c.dialRetries {
+				dialRetries[string(class)] = cnt
+			}
+			rejected := make(map[string]int, len(c.rejected))
+			for reason, cnt := range c.rejected {
+				rejected[string(reason)] = cnt
+			}
+			reachable := len(c.reachable)
+			goodKinds := stats.KindCounts{
+				Full:    c.goodKinds[node.KindFull],
+				Limited: c.goodKinds[node.KindLimited],
+				Neither: c.goodKinds[node.KindNeither],
+			}
+			limitedSkipped := c.limitedSkipped
+			queued := c.queueLen()
+			total := c.nodesCnt
+			known, evicted := len(c.nodes), c.evicted
+			connLimit, connMax := c.connLimit, c.connMax
+			var topCountries, topASNs []stats.GeoCount
+			if c.geo != nil {
+				topCountries = stats.TopGeo(c.countries)
+				topASNs = stats.TopGeo(c.asns)
+			}
+			diversity := stats.DiversityStats(c.netgroups)
+			monitorDrops, crawlPaused := c.monitorDrops, c.crawlPaused
+			fees := c.connectedFees()
+			seeds := c.seedCountsCopy()
+			c.mu.Unlock()
+			msgsIn, msgsOut := node.MessageCounts()
+			conns := c.Connections()
+			connsDone, connAvg := c.SlotTurnover()
+			metrics.SetDiversity(diversity.Groups, diversity.LargestShare, diversity.HHI)
+			addrsDepth := len(c.newAddrCh)
+			bytesIn, bytesOut := c.Traffic()
+			bytesRate := c.bandwidth.sample(time.Now())
+			metrics.SetTraffic(bytesIn, bytesOut, bytesRate)
+			metrics.SetChannelDepth(metrics.ChanAddrs, addrsDepth)
+			var monitored []stats.MonitorInfo
+			if cfg.Monitor > 0 {
+				monitored = c.Monitored()
+			}
+			c.sink.Push(stats.Stats{
+				Connections:         connCnt,
+				Goroutines:          runtime.NumGoroutine(),
+				ConnectionsLimit:    connLimit,
+				ConnectionsMax:      connMax,
+				NodesTotal:          total,
+				NodesReachable:      reachable,
+				NodesQueued:         queued,
+				NodesGood:           len(c.nodesGood),
+				NodesDead:           deadCnt,
+				Started:             c.started,
+				AddrTotal:           addrTotal,
+				AddrGood:            addrGood,
+				GoodKinds:           goodKinds,
+				NodesLimitedSkipped: limitedSkipped,
+				DeadReasons:         deadReasons,
+				DialErrors:          dialErrs,
+				DialRetries:         dialRetries,
+				Rejected:            rejected,
+				Latency:             stats.LatencyHistogram(rtts),
+				MsgsIn:              msgsIn,
+				MsgsOut:             msgsOut,
+				MsgTypes:            node.MessageTypes(),
+				TopNodes:            stats.TopNodes(good),
+				ActiveConns:         conns,
+				HeightMax:           heightMax,
+				HeightMedian:        heightMedian,
+				NodesStale:          nodesStale,
+				NodesLying:          nodesLying,
+				FeeFilter:           stats.FeeFilterStats(fees),
+				ClockSkew:           stats.ClockSkewStats(skews),
+				ConnsDone:           connsDone,
+				ConnAvg:             connAvg,
+				Diversity:           diversity,
+				TopCountries:        topCountries,
+				TopASNs:             topASNs,
+				Monitored:           monitored,
+				MonitorDrops:        monitorDrops,
+				Seeds:               seeds,
+				BytesIn:             bytesIn,
+				BytesOut:            bytesOut,
+				BytesRate:           bytesRate,
+				BandwidthCapped:     atomic.LoadInt32(&c.bandwidthCapped) == 1,
+				Inbound:             c.InboundCounts(),
+				Banned:              c.bans.count(time.Now()),
+				CrawlPaused:         crawlPaused,
+			})
+			c.log.Debugf("STAT: total:%d, connected:%d/%d, good:%d, dead:%d", total, connCnt, connLimit, len(c.nodesGood), c.nodesDeadCnt)
+			c.log.Debugf("STAT: addrs ch:%d/%d, full:%d", addrsDepth, cap(c.newAddrCh), metrics.ChannelFullCount(metrics.ChanAddrs))
+			if cfg.MaxKnownNodes > 0 {
+				c.log.Debugf("STAT: known:%d/%d, evicted:%d", known, cfg.MaxKnownNodes, evicted)
 			}
-			c.log.Debugf("[CLIENT]: STAT: total:%d, connected:%d/%d, good:%d, dead:%d", len(c.nodes), connCnt, cfg.ConnectionsLimit, len(c.nodesGood), c.nodesDeadCnt)
 
 			// report G count and memory used
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
-			c.log.Debugf("[CLIENT]: STAT: G:%d, MEM:%dKb\n", runtime.NumGoroutine(), m.Alloc/1024)
+			c.log.Debugf("STAT: G:%d, MEM:%dKb\n", runtime.NumGoroutine(), m.Alloc/1024)
 
 		}
 	}
 }
+
+// adaptive connections limit, see wConnTuner
+const (
+	tuneInterval = 5 * time.Second
+	// fewer dials in the window say nothing about the failure rate
+	tuneMinDials = 20
+)
+
+// refused means the node is down and the other family is not dialed,
+// the other errors may be our own limits
+func (c *Client) countDial(class node.DialErr) {
+	atomic.AddInt64(&c.dialsWindow, 1)
+	switch class {
+	case "", node.DialErrRefused, node.DialErrFamily:
+	case node.DialErrFiles:
+		atomic.AddInt64(&c.filesWindow, 1)
+		atomic.AddInt64(&c.failsWindow, 1)
+	default:
+		atomic.AddInt64(&c.failsWindow, 1)
+	}
+}
+
+// lower the connections by a quarter when out of files or the failure rate is over
+// the threshold, raise them back by a tenth of the max plus one while the rate is under the half of it
+func (c *Client) wConnTuner() {
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("TUNER worker started")
+	defer c.log.Debug("TUNER worker exited")
+	ticker := time.NewTicker(tuneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			dials := atomic.SwapInt64(&c.dialsWindow, 0)
+			fails := atomic.SwapInt64(&c.failsWindow, 0)
+			files := atomic.SwapInt64(&c.filesWindow, 0)
+
+			c.mu.Lock()
+			if c.crawlPaused {
+				c.mu.Unlock()
+				continue
+			}
+			limit, max := c.connLimit, c.connMax
+			next, reason := limit, ""
+			rate := 0.0
+			if dials > 0 {
+				rate = float64(fails) / float64(dials)
+			}
+			switch {
+			case files > 0:
+				next, reason = limit*3/4, fmt.Sprintf("%d dials out of files", files)
+			case dials < tuneMinDials:
+			case rate > cfg.AdaptiveErrorRate:
+				next, reason = limit*3/4, fmt.Sprintf("failure rate %.2f", rate)
+			case rate < cfg.AdaptiveErrorRate/2 && limit < max:
+				next, reason = limit+max/10+1, fmt.Sprintf("failure rate %.2f", rate)
+			}
+			if next < 1 {
+				next = 1
+			}
+			if next > max {
+				next = max
+			}
+			c.resize(next)
+			c.mu.Unlock()
+			if next != limit {
+				c.log.Infof("connections %d -> %d of %d, %s", limit, next, max, reason)
+			}
+		}
+	}
+}
+
+// how often wPreempter looks for the idle connections
+const preemptInterval = 5 * time.Second
+
+// every preemptInterval while all the slots are taken and nodes wait in the queue,
+// close the reported connections silent for PREEMPT_IDLE, the longest silent
+// and then the slowest first, up to PREEMPT_MAX a minute
+func (c *Client) wPreempter() {
+	defer metrics.Track(metrics.RoleWorker)()
+	c.log.Debug("PREEMPT worker started")
+	defer c.log.Debug("PREEMPT worker exited")
+	ticker := time.NewTicker(preemptInterval)
+	defer ticker.Stop()
+	windowStart, budget := time.Now(), cfg.PreemptMax
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(windowStart) >= time.Minute {
+				windowStart, budget = now, cfg.PreemptMax
+			}
+			if budget == 0 {
+				continue
+			}
+			budget -= c.preempt(now, budget)
+		}
+	}
+}
+
+// preempt closes up to max victims, their connectors see the listener exit
+// and free the slots. returns the closed count
+func (c *Client) preempt(now time.Time, max int) int {
+	closed := 0
+	for _, n := range c.preemptVictims(now, max) {
+		if n.Disconnect() {
+			closed++
+			atomic.AddInt64(&c.preempted, 1)
+			c.log.Debugf("%s preempted, idle %s, rtt %s", n.Endpoint(), now.Sub(n.LastRecv()).Round(time.Second), n.RTT())
+		}
+	}
+	return closed
+}
+
+// up to max reported connections idle for PREEMPT_IDLE, none while a slot is free
+// or the queue is empty. the monitored peers are not in the connectors
+func (c *Client) preemptVictims(now time.Time, max int) []*node.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.crawlPaused || c.queueLen()+len(c.queueCh) == 0 || int(atomic.LoadInt32(&c.activeConns)) < c.connLimit {
+		return nil
+	}
+	var victims []*node.Node
+	for n := range c.inFlight {
+		if n.Reported() && now.Sub(n.LastRecv()) >= cfg.PreemptIdle {
+			victims = append(victims, n)
+		}
+	}
+	// silent for about as long, by a tick, the slowest first. no pong yet is the slowest
+	idle := func(n *node.Node) time.Duration {
+		return now.Sub(n.LastRecv()) / preemptInterval
+	}
+	slowness := func(n *node.Node) time.Duration {
+		if n.RTT() == 0 {
+			return cfg.PingTimeout
+		}
+		return n.RTT()
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		if a, b := idle(victims[i]), idle(victims[j]); a != b {
+			return a > b
+		}
+		return slowness(victims[i]) > slowness(victims[j])
+	})
+	if len(victims) > max {
+		victims = victims[:max]
+	}
+	return victims
+}
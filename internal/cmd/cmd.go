@@ -6,17 +6,22 @@ import (
 
 	"github.com/1F47E/go-btc-xray/internal/config"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 )
 
-var cfg = config.New()
+var cfg = config.Shared()
 
 func SendVersion(conn net.Conn, nonce uint64) error {
 	msg := localVersionMsg(nonce)
 	return writeMessage(conn, msg)
 }
 
+// sendaddrv2 is only for version 70016 and later, see BIP155
 func SendAddrV2(conn net.Conn) error {
+	if ProtocolVersion(conn) < wire.AddrV2Version {
+		return nil
+	}
 	msg := wire.NewMsgSendAddrV2()
 	return writeMessage(conn, msg)
 }
@@ -30,16 +35,74 @@ func SendGetAddr(conn net.Conn) error {
 	return writeMessage(conn, msg)
 }
 
+// answer to getaddr, wire.MaxAddrPerMsg at most
+func SendAddr(conn net.Conn, addrs []*wire.NetAddress) error {
+	msg := wire.NewMsgAddr()
+	if err := msg.AddAddresses(addrs...); err != nil {
+		return err
+	}
+	return writeMessage(conn, msg)
+}
+
 func SendPing(conn net.Conn, nonce uint64) error {
 	msg := wire.NewMsgPing(nonce)
 	return writeMessage(conn, msg)
 }
 
+func SendPong(conn net.Conn, nonce uint64) error {
+	msg := wire.NewMsgPong(nonce)
+	return writeMessage(conn, msg)
+}
+
+// headers after the locator up to the peer tip, 2000 at most
+func SendGetHeaders(conn net.Conn, locator *chainhash.Hash) error {
+	msg := wire.NewMsgGetHeaders()
+	msg.ProtocolVersion = ProtocolVersion(conn)
+	if err := msg.AddBlockLocatorHash(locator); err != nil {
+		return err
+	}
+	return writeMessage(conn, msg)
+}
+
+func SendGetData(conn net.Conn, invs []*wire.InvVect) error {
+	msg := wire.NewMsgGetDataSizeHint(uint(len(invs)))
+	for _, inv := range invs {
+		if err := msg.AddInvVect(inv); err != nil {
+			return err
+		}
+	}
+	return writeMessage(conn, msg)
+}
+
 func writeMessage(conn net.Conn, msg wire.Message) error {
 	if conn == nil {
 		return fmt.Errorf("no connection")
 	}
-	return wire.WriteMessage(conn, msg, cfg.Pver, cfg.Btcnet)
+	return wire.WriteMessage(conn, msg, ProtocolVersion(conn), cfg.Btcnet)
+}
+
+// Versioned connections know the protocol version negotiated with the peer,
+// the lower of the two versions like the peers do
+type Versioned interface {
+	ProtocolVersion() uint32
+}
+
+// OurVersion encodes the messages until the version of the peer came, ADVERTISED_VERSION
+// up to the version of the wire package, the newer ones are encoded the same
+func OurVersion() uint32 {
+	if cfg.AdvertisedVersion < cfg.Pver {
+		return cfg.AdvertisedVersion
+	}
+	return cfg.Pver
+}
+
+// ProtocolVersion the messages on the connection are encoded with, OurVersion
+// for the connections that are not Versioned
+func ProtocolVersion(conn net.Conn) uint32 {
+	if v, ok := conn.(Versioned); ok {
+		return v.ProtocolVersion()
+	}
+	return OurVersion()
 }
 
 // localVersionMsg creates a version message that can be used to send to the
@@ -62,15 +125,15 @@ func localVersionMsg(nonce uint64) *wire.MsgVersion {
 		Services: wire.SFNodeNetwork,
 	}
 
-	// Generate a unique nonce for this peer so self connections can be
-	// detected.  This is accomplished by adding it to a size-limited map of
-	// recently seen nonces.
+	// The nonce is unique per connection so self connections can be
+	// detected, see the node package.
 
 	// Version message.
 	msg := wire.NewMsgVersion(ourNA, &theirNA, nonce, blockNum)
-	_ = msg.AddUserAgent("btcd", "0.23.3", "")
+	// validated by the config, AddUserAgent would append to the btcd one
+	msg.UserAgent = cfg.UserAgent
 	msg.Services = wire.SFNodeNetwork
-	msg.ProtocolVersion = int32(cfg.Pver)
+	msg.ProtocolVersion = int32(cfg.AdvertisedVersion)
 	// Advertise if inv messages for transactions are desired.
 	// msg.DisableRelayTx = p.cfg.DisableRelayTx
 
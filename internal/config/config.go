@@ -2,11 +2,13 @@ package config
 
 import (
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 )
 
@@ -15,36 +17,230 @@ type Network string
 const (
 	NetworkMainnet Network = "mainnet"
 	NetworkTestnet Network = "testnet"
+	NetworkRegtest Network = "regtest"
+)
+
+// order of the new nodes in the dial queue
+type QueueSort string
+
+const (
+	QueueSortRandom QueueSort = "random"
+	QueueSortFIFO   QueueSort = "fifo"
+	// most announced first
+	QueueSortAnnounces QueueSort = "announces"
+	// most recently announced first, retries go last
+	QueueSortFresh QueueSort = "fresh"
 )
 
 type Config struct {
-	Network          Network
-	NodesFilename    string
-	NodesPort        uint16
-	NodeTimeout      time.Duration
-	PingInterval     time.Duration
-	PingTimeout      time.Duration
-	PingRetrys       int
+	Network       Network
+	NodesFilename string
+	// good nodes appended as json lines while crawling
+	NodesLogFilename string
+	// same for the nodes rejected by their version
+	RejectedLogFilename string
+	// who sent which address, a json line per address of every addr message
+	RecordGraph   bool
+	GraphFilename string
+	// how often the whole nodes file is rewritten
+	SaveInterval time.Duration
+	// order of the saved nodes file, best first, and how many to keep, 0 for all
+	SaveSort []string
+	MaxSaved int
+	// binary copy of the nodes file, much faster to load, see storage.Load
+	NodesCache bool
+	// the nodes logs are appended across the runs and rotated on open over this size,
+	// 0 to never rotate
+	NodesLogMaxSizeMB int
+	// start from the nodes saved by the previous run, the ones not good again are saved
+	// until Retention passes since they were last good, 0 to only save the good ones
+	Resume          bool
+	Retention       time.Duration
+	SummaryFilename string
+	NodesPort       uint16
+	DialTimeout     time.Duration
+	// from the connect to the version and verack of the node
+	HandshakeTimeout time.Duration
+	// max wait for the next message, silent nodes are closed
+	MsgReadTimeout time.Duration
+	// the connectors do not dial while the process has this many goroutines, 0 for no cap
+	MaxGoroutines int
+	// the dials wait while the traffic of all the connections is over this, 0 for no cap
+	MaxBandwidthKBps int
+	// TCP keepalive probes period of the node connections, 0 to disable
+	TCPKeepAlive time.Duration
+	// how many times to redial a node after a timeout, refused is never retried
+	DialRetries int
+	// failed connects of an endpoint in the run before its addresses are not queued anymore, 0 for no cap
+	MaxAttempts  int
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	PingRetrys   int
+	// getdata for a sample of this many inv items, 0 to disable
+	InvSample int
+	// try the BIP324 v2 handshake before the v1 one, one more dial per node
+	V2Probe bool
+	// more handshakes from scratch before a node is good, one more dial each
+	ConfirmProbes int
+	// block the peers must extend, asked with getheaders after the handshake, nil to skip
+	ChainCheckpoint *chainhash.Hash
+	// close the connection after this many undecodable messages
+	MaxDecodeErrors int
+	// misbehaving ips are banned for BanTime after BanStrikes misbehaviors, 0 to never ban them.
+	// the bans are saved to BansFilename in the data dir, BanFile ips are banned while listed
+	BanTime      time.Duration
+	BanStrikes   int
+	BanFile      string
+	BansFilename string
+	// only these endpoints, hosts and cidr ranges are queued, empty for all
+	Whitelist string
+	// nodes without all of these service bits or below the version are rejected, 0 to keep all
+	RequiredServices   wire.ServiceFlag
+	MinProtocolVersion int32
+	// pruned nodes are not counted as good, their addresses are still crawled
+	SkipLimited bool
+	// good nodes behind the median height by more blocks are stale
+	StaleBlocks int
+	// keep the good nodes connected and repeat getaddr, 0 to ask once,
+	// at most GetAddrMax requests while the share of new addresses is above GetAddrMinYield
+	GetAddrInterval time.Duration
+	GetAddrMax      int
+	GetAddrMinYield float64
+	// addr entries last seen by the peer before this are dropped, 0 to keep all
+	AddrMaxAge time.Duration
+	// addresses gossiped by the nodes this many hops from the seeds are dropped, 0 for no limit
+	MaxDepth int
+	// close the good nodes on the first addr answer of FastMinAddrs or after FastTimeout
+	FastCrawl        bool
+	FastMinAddrs     int
+	FastTimeout      time.Duration
 	ListenInterval   time.Duration
 	ConnectionsLimit int
-	LogsDir          string
-	LogsFilename     string
-	DataDir          string
+	// buffer of the addr batches from the peers, a full one blocks their reads
+	AddrChSize int
+	// lower the connections when the dials fail, ConnectionsLimit is the max
+	AdaptiveConn bool
+	// share of the failed dials in a window to back off, refused is not counted
+	AdaptiveErrorRate float64
+	// close up to this many reported connections a minute silent for PreemptIdle
+	// when all the slots are taken and nodes wait in the queue, 0 to disable
+	PreemptMax  int
+	PreemptIdle time.Duration
+	QueueSort   QueueSort
+	// dial queue in a file and the known addresses in a bloom filter,
+	// keeps the memory flat on the huge crawls, the queue is fifo.
+	// the file is a temp one in the data dir, * is random per client
+	DiskQueue     bool
+	QueueFilename string
+	// known addresses in a bloom filter of BloomItems instead of the exact map,
+	// a false positive at BloomRate drops a new address
+	KnownBloom bool
+	BloomItems int
+	BloomRate  float64
+	// entries of the exact known map before the least recently announced dead ones are dropped, 0 for no cap
+	MaxKnownNodes int
+	LogsDir       string
+	LogsFilename  string
+	// default log level and per module overrides, module -> level
+	LogLevel  string
+	LogLevels map[string]string
+	// extra log file with all the output, independent of the gui, empty to disable
+	LogFile      string
+	LogFormat    string // text or json
+	LogMaxSizeMB int
+	LogMaxFiles  int
+	DataDir      string
+	// permissions of the created data and logs dirs and the data files
+	DirMode  os.FileMode
+	FileMode os.FileMode
 
 	DnsAddress string
 	DnsTimeout time.Duration
 	DnsSeeds   []string
+	// nodes to connect to in addition to the dns seeds, ip or host:port
+	Seeds []string
+	// more seed nodes, one per line, see the seeds package
+	SeedFile string
+	// retries while no seed node is found, negative for forever, 0 to fail fast
+	BootstrapRetries int
+	BootstrapDelay   time.Duration
+	// socks5 proxy host:port for all the node connections, empty to dial directly
+	Proxy string
+	// source ip of the outbound connections of its family, one of our interfaces, empty for the OS pick
+	LocalAddr string
+	// accept the peers on this address like :8333, empty to disable, at most InboundMax at once
+	ListenAddr string
+	InboundMax int
 
 	Gui bool
+	// web dashboard address like :8080, empty to disable, token is optional
+	HTTPAddr  string
+	HTTPToken string
+	// pprof and expvar server address like localhost:6060, empty to disable
+	DebugAddr string
+	// post milestones to this url, empty to disable, see the webhook package
+	WebhookURL     string
+	WebhookGood    []int
+	WebhookTimeout time.Duration
+	// ip2asn tsv for the countries and ASNs table, empty to disable, see the geo package
+	GeoFile string
+	// MaxMind databases instead of GeoFile, a country and an ASN one like GeoLite2
+	GeoMMDB []string
+	// render rate, chart points and log lines kept in the gui
+	GUIRefreshMs int
+	ChartHistory int
+	LogLines     int
+	// log lines added to the panes per render, the rest is dropped
+	LogRate int
+	// buffer of the gui stats and log lines, full drops them
+	GUIChSize int
+
+	// stop the crawl after this duration, 0 to run until stopped
+	MaxDuration time.Duration
+	// stop when the queue is empty and no addresses came for this long, 0 to disable
+	DrainTimeout time.Duration
+
+	// keep this many fastest good nodes connected and pause the crawl, 0 to disable,
+	// the crawl resumes every MonitorRefresh, 0 to never
+	Monitor        int
+	MonitorRefresh time.Duration
+
+	// Daemon mode, crawl in cycles forever
+	Daemon        bool
+	CycleDuration time.Duration
+	CycleInterval time.Duration
+	// jsonl file in the data dir with a summary line per cycle, empty to disable
+	HistoryFilename string
+	// jsonl file in the data dir every good node is appended to when found, empty to disable
+	StreamFilename string
 
 	// Wire
 	Pver uint32
+	// sent in our version message, Pver by default. the messages are encoded with
+	// the lower of it, Pver and the version of the peer
+	AdvertisedVersion uint32
+	// BIP14 user agent in our version message, the comment tells operators who we are
+	UserAgent string
 
 	// var btcnet = wire.MainNet
 	Btcnet wire.BitcoinNet
+
+	// values that failed to parse
+	errs []string
+}
+
+// New reads a config of its own and validates it, see Shared for the one of the packages
+func New() (*Config, error) {
+	cfg := parse()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
-func New() *Config {
+// parse reads the config, problems with the values are reported by Validate
+func parse() *Config {
+	p := &parser{}
 	cfg := &Config{
 		// var dnsAddress = "1.1.1.1:53" // cloudflare dns, 2x slower
 		// google dns
@@ -54,36 +250,160 @@ func New() *Config {
 		// quad dns
 		// DnsAddress:     "9.9.9.9:53",
 
-		Pver:           wire.ProtocolVersion, // 70016
-		NodeTimeout:    5 * time.Second,
-		PingInterval:   1 * time.Minute,
-		PingTimeout:    15 * time.Second,
-		PingRetrys:     3,
-		ListenInterval: 1 * time.Second,
-		LogsDir:        "logs",
-		LogsFilename:   fmt.Sprintf("logs_%s.log", time.Now().Format("2006-01-02_15-04-05")),
-		DataDir:        "data",
-		Gui:            os.Getenv("GUI") != "0", // enabled by default
+		Pver:             wire.ProtocolVersion, // 70016
+		DialTimeout:      p.envDuration("DIAL_TIMEOUT", 5*time.Second),
+		HandshakeTimeout: p.envDuration("HANDSHAKE_TIMEOUT", 10*time.Second),
+		MsgReadTimeout:   p.envDuration("MSG_READ_TIMEOUT", 30*time.Second),
+		TCPKeepAlive:     p.envDuration("TCP_KEEPALIVE", 15*time.Second),
+		MaxGoroutines:    p.envInt("MAX_GOROUTINES", 0),
+		MaxBandwidthKBps: p.envInt("MAX_BANDWIDTH", 0),
+		PingInterval:     1 * time.Minute,
+		PingTimeout:      15 * time.Second,
+		PingRetrys:       3,
+		ListenInterval:   1 * time.Second,
+		LogsDir:          p.envPath("LOGS_DIR", "logs"),
+		LogsFilename:     fmt.Sprintf("logs_%s.log", time.Now().Format("2006-01-02_15-04-05")),
+		DataDir:          p.envPath("DATA_DIR", "data"),
+		DirMode:          p.envMode("DIR_MODE", 0755),
+		FileMode:         p.envMode("FILE_MODE", 0644),
+		// .json and .txt are written on exit
+		SummaryFilename:   "summary",
+		Gui:               lookup("GUI") != "0", // enabled by default
+		DialRetries:       p.envInt("DIAL_RETRIES", 2),
+		MaxAttempts:       p.envInt("MAX_ATTEMPTS", 0),
+		QueueSort:         QueueSort(p.envString("QUEUE_SORT", string(QueueSortRandom))),
+		DiskQueue:         lookup("DISK_QUEUE") == "1",
+		KnownBloom:        lookup("KNOWN_BLOOM") == "1",
+		BloomItems:        p.envInt("BLOOM_ITEMS", 10_000_000),
+		MaxKnownNodes:     p.envInt("MAX_KNOWN_NODES", 0),
+		BloomRate:         p.envFloat("BLOOM_RATE", 0.001),
+		MaxDecodeErrors:   p.envInt("MAX_DECODE_ERRORS", 5),
+		BanTime:           p.envDuration("BAN_TIME", 24*time.Hour),
+		BanStrikes:        p.envInt("BAN_STRIKES", 3),
+		BanFile:           p.envPath("BAN_FILE", ""),
+		Whitelist:         p.envPath("WHITELIST", ""),
+		SaveInterval:      p.envDuration("SAVE_INTERVAL", 1*time.Minute),
+		SaveSort:          []string{SortLatency, SortServices, SortHeight},
+		MaxSaved:          p.envInt("MAX_SAVED", 0),
+		NodesCache:        lookup("NODES_CACHE") == "1",
+		NodesLogMaxSizeMB: p.envInt("NODES_LOG_MAX_SIZE", 0),
+		Resume:            lookup("RESUME") == "1",
+		Retention:         p.envDuration("RETENTION", 0),
+		GUIRefreshMs:      p.envInt("GUI_REFRESH_MS", 200),
+		ChartHistory:      p.envInt("GUI_CHART_HISTORY", 32),
+		LogLines:          p.envInt("GUI_LOG_LINES", 25),
+		LogRate:           p.envInt("GUI_LOG_RATE", 20),
+		GUIChSize:         p.envInt("GUI_CH_SIZE", 42),
+		MaxDuration:       p.envDuration("MAX_DURATION", 0),
+		DrainTimeout:      p.envDuration("DRAIN_TIMEOUT", 0),
+		Monitor:           p.envInt("MONITOR", 0),
+		MonitorRefresh:    p.envDuration("MONITOR_REFRESH", 0),
+		Daemon:            lookup("DAEMON") == "1",
+		V2Probe:           lookup("V2_PROBE") == "1",
+		ConfirmProbes:     p.envInt("CONFIRM_PROBES", 0),
+		SkipLimited:       lookup("SKIP_LIMITED") == "1",
+		StaleBlocks:       p.envInt("STALE_BLOCKS", 12),
+		AdaptiveConn:      lookup("ADAPTIVE_CONN") == "1",
+		AdaptiveErrorRate: p.envFloat("ADAPTIVE_ERROR_RATE", 0.9),
+		PreemptMax:        p.envInt("PREEMPT_MAX", 0),
+		PreemptIdle:       p.envDuration("PREEMPT_IDLE", 20*time.Second),
+		CycleDuration:     p.envDuration("CYCLE_DURATION", 30*time.Minute),
+		CycleInterval:     p.envDuration("CYCLE_INTERVAL", 3*time.Hour),
+		HistoryFilename:   lookup("HISTORY"),
+		StreamFilename:    lookup("STREAM"),
+		RecordGraph:       lookup("RECORD_GRAPH") == "1",
+		LogFile:           p.envPath("LOG_FILE", ""),
+		Proxy:             lookup("PROXY"),
+		LocalAddr:         lookup("LOCAL_ADDR"),
+		ListenAddr:        lookup("LISTEN_ADDR"),
+		InboundMax:        p.envInt("INBOUND_MAX", 16),
+		HTTPAddr:          lookup("HTTP_ADDR"),
+		HTTPToken:         lookup("HTTP_TOKEN"),
+		DebugAddr:         lookup("DEBUG_ADDR"),
+		WebhookURL:        lookup("WEBHOOK_URL"),
+		WebhookTimeout:    p.envDuration("WEBHOOK_TIMEOUT", 5*time.Second),
+		GeoFile:           p.envPath("GEO_FILE", ""),
+		SeedFile:          p.envPath("SEED_FILE", ""),
+		BootstrapRetries:  p.envInt("BOOTSTRAP_RETRIES", 0),
+		GetAddrInterval:   p.envDuration("GETADDR_INTERVAL", 0),
+		GetAddrMax:        p.envInt("GETADDR_MAX", 10),
+		GetAddrMinYield:   p.envFloat("GETADDR_MIN_YIELD", 0.1),
+		AddrMaxAge:        p.envDuration("ADDR_MAX_AGE", 0),
+		MaxDepth:          p.envInt("MAX_DEPTH", 0),
+		FastCrawl:         lookup("FAST_CRAWL") == "1",
+		FastMinAddrs:      p.envInt("FAST_MIN_ADDRS", 10),
+		FastTimeout:       p.envDuration("FAST_TIMEOUT", 10*time.Second),
+		BootstrapDelay:    p.envDuration("BOOTSTRAP_DELAY", 5*time.Second),
+		LogFormat:         "text",
+		LogMaxSizeMB:      10,
+		LogMaxFiles:       5,
 		// Pver: 70013,
 	}
-	if os.Getenv("DEBUG") == "1" {
+	if lookup("DEBUG") == "1" {
 		cfg.ConnectionsLimit = 10
+		cfg.LogLevel = "debug"
 	} else {
 		cfg.ConnectionsLimit = 50
+		cfg.LogLevel = "info"
 	}
-	// override connections limit
-	if os.Getenv("CONN") != "" {
-		conn, err := strconv.Atoi(os.Getenv("CONN"))
+	// log levels, "client=debug,gui=warn", entry without a module sets the default level
+	cfg.LogLevels = make(map[string]string)
+	if lookup("LOGS") != "" {
+		for _, entry := range strings.Split(lookup("LOGS"), ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			module, level, ok := strings.Cut(entry, "=")
+			if !ok {
+				cfg.LogLevel = entry
+				continue
+			}
+			cfg.LogLevels[strings.TrimSpace(module)] = strings.TrimSpace(level)
+		}
+	}
+	if lookup("LOG_FORMAT") != "" {
+		cfg.LogFormat = lookup("LOG_FORMAT")
+	}
+	cfg.LogMaxSizeMB = p.envInt("LOG_MAX_SIZE", cfg.LogMaxSizeMB)
+	cfg.LogMaxFiles = p.envInt("LOG_MAX_FILES", cfg.LogMaxFiles)
+	if lookup("REQUIRED_SERVICES") != "" {
+		services, err := parseServices(lookup("REQUIRED_SERVICES"))
 		if err != nil {
-			log.Fatalf("error converting CONN env variable to int: %v", err)
+			p.fail("error converting REQUIRED_SERVICES: %v", err)
+		} else {
+			cfg.RequiredServices = services
 		}
-		cfg.ConnectionsLimit = conn
 	}
-	if os.Getenv("TESTNET") == "1" {
+	cfg.MinProtocolVersion = int32(p.envInt("MIN_PROTOCOL_VERSION", 0))
+	// request a sample of announced invs, increases bandwidth
+	cfg.InvSample = p.envInt("INV_SAMPLE", cfg.InvSample)
+	// override connections limit
+	cfg.ConnectionsLimit = p.envInt("CONN", cfg.ConnectionsLimit)
+	// a batch per connection in flight by default
+	cfg.AddrChSize = p.envInt("ADDR_CH_SIZE", cfg.ConnectionsLimit)
+	if lookup("REGTEST") == "1" {
+		// local bitcoind -regtest, no dns seeds, nodes are set with SEEDS
+		cfg.Network = NetworkRegtest
+		cfg.Btcnet = wire.TestNet
+		cfg.DnsTimeout = 5 * time.Second
+		cfg.NodesFilename = "regtest.json"
+		cfg.NodesLogFilename = "regtest.jsonl"
+		cfg.RejectedLogFilename = "regtest_rejected.jsonl"
+		cfg.GraphFilename = "regtest_graph.jsonl"
+		cfg.QueueFilename = "regtest_queue_*.txt"
+		cfg.BansFilename = "regtest_bans.json"
+		cfg.NodesPort = 18444
+	} else if lookup("TESTNET") == "1" {
 		cfg.Network = NetworkTestnet
 		cfg.Btcnet = wire.TestNet3
 		cfg.DnsTimeout = 10 * time.Second
 		cfg.NodesFilename = "testnet.json"
+		cfg.NodesLogFilename = "testnet.jsonl"
+		cfg.RejectedLogFilename = "testnet_rejected.jsonl"
+		cfg.GraphFilename = "testnet_graph.jsonl"
+		cfg.QueueFilename = "testnet_queue_*.txt"
+		cfg.BansFilename = "testnet_bans.json"
 		cfg.NodesPort = 18333
 		cfg.DnsSeeds = []string{
 			"testnet-seed.bitcoin.jonasschnelli.ch",
@@ -97,6 +417,11 @@ func New() *Config {
 
 		cfg.DnsTimeout = 5 * time.Second
 		cfg.NodesFilename = "mainnet.json"
+		cfg.NodesLogFilename = "mainnet.jsonl"
+		cfg.RejectedLogFilename = "mainnet_rejected.jsonl"
+		cfg.GraphFilename = "mainnet_graph.jsonl"
+		cfg.QueueFilename = "mainnet_queue_*.txt"
+		cfg.BansFilename = "mainnet_bans.json"
 		cfg.NodesPort = 8333
 		cfg.DnsSeeds = []string{
 			"dnsseed.emzy.de",
@@ -111,5 +436,212 @@ func New() *Config {
 			"seed.bitnodes.io",
 		}
 	}
+	// custom network magic and default port
+	if lookup("MAGIC") != "" {
+		magic, err := strconv.ParseUint(lookup("MAGIC"), 0, 32)
+		if err != nil {
+			p.fail("error converting MAGIC to uint32: %v", err)
+		} else {
+			cfg.Btcnet = wire.BitcoinNet(magic)
+		}
+	}
+	if lookup("PORT") != "" {
+		port, err := strconv.ParseUint(lookup("PORT"), 10, 16)
+		if err != nil {
+			p.fail("error converting PORT to uint16: %v", err)
+		} else {
+			cfg.NodesPort = uint16(port)
+		}
+	}
+	cfg.AdvertisedVersion = cfg.Pver
+	cfg.UserAgent = p.envString("USER_AGENT", DefaultUserAgent)
+	if lookup("ADVERTISED_VERSION") != "" {
+		v, err := strconv.ParseUint(lookup("ADVERTISED_VERSION"), 10, 32)
+		if err != nil {
+			p.fail("error converting ADVERTISED_VERSION to uint32: %v", err)
+		} else {
+			cfg.AdvertisedVersion = uint32(v)
+		}
+	}
+	if lookup("CHAIN_CHECKPOINT") != "" {
+		hash, err := chainhash.NewHashFromStr(lookup("CHAIN_CHECKPOINT"))
+		if err != nil {
+			p.fail("error converting CHAIN_CHECKPOINT to block hash: %v", err)
+		} else {
+			cfg.ChainCheckpoint = hash
+		}
+	}
+	// PPROF=1 is the old way to enable the debug server
+	if lookup("PPROF") == "1" && cfg.DebugAddr == "" {
+		cfg.DebugAddr = "localhost:6060"
+	}
+	// "latency,height", "none" to keep the order the nodes were found
+	if lookup("SAVE_SORT") != "" {
+		cfg.SaveSort = nil
+		for _, key := range strings.Split(lookup("SAVE_SORT"), ",") {
+			if key = strings.TrimSpace(key); key != "" && key != "none" {
+				cfg.SaveSort = append(cfg.SaveSort, key)
+			}
+		}
+	}
+	// good nodes counts to post, "100,1000"
+	if lookup("WEBHOOK_GOOD") != "" {
+		for _, v := range strings.Split(lookup("WEBHOOK_GOOD"), ",") {
+			t, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				p.fail("error converting WEBHOOK_GOOD to int list: %v", err)
+				continue
+			}
+			cfg.WebhookGood = append(cfg.WebhookGood, t)
+		}
+	}
+	if lookup("GEO_MMDB") != "" {
+		for _, path := range strings.Split(lookup("GEO_MMDB"), ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				cfg.GeoMMDB = append(cfg.GeoMMDB, p.expandPath("GEO_MMDB", path))
+			}
+		}
+	}
+	if lookup("SEEDS") != "" {
+		for _, seed := range strings.Split(lookup("SEEDS"), ",") {
+			if seed = strings.TrimSpace(seed); seed != "" {
+				cfg.Seeds = append(cfg.Seeds, seed)
+			}
+		}
+	}
+	cfg.errs = append(p.errs, flagErrs...)
+	if fileErr != "" {
+		cfg.errs = append(cfg.errs, fileErr)
+	}
 	return cfg
 }
+
+// saved nodes sort keys
+const (
+	// lowest ping first, nodes without a pong go last
+	SortLatency = "latency"
+	// most service bits first
+	SortServices = "services"
+	// highest block first
+	SortHeight = "height"
+)
+
+const DefaultUserAgent = "/xray:0.1(crawler)/"
+
+// service bit names for REQUIRED_SERVICES
+var serviceNames = map[string]wire.ServiceFlag{
+	"network":         wire.SFNodeNetwork, // full node, serves the whole chain
+	"getutxo":         wire.SFNodeGetUTXO, // BIP64 getutxos
+	"bloom":           wire.SFNodeBloom,   // BIP111 bloom filters
+	"witness":         wire.SFNodeWitness, // BIP144 segwit blocks and txs
+	"xthin":           wire.SFNodeXthin,   // xthin blocks
+	"cf":              wire.SFNodeCF,      // BIP157 compact filters
+	"network_limited": 1 << 10,            // BIP159 last 288 blocks only
+}
+
+// comma separated service names or a number like 0x9
+func parseServices(v string) (wire.ServiceFlag, error) {
+	if n, err := strconv.ParseUint(v, 0, 64); err == nil {
+		return wire.ServiceFlag(n), nil
+	}
+	var services wire.ServiceFlag
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		flag, ok := serviceNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown service %q", name)
+		}
+		services |= flag
+	}
+	return services, nil
+}
+
+// collects the parse errors instead of exiting, the default is used
+type parser struct {
+	errs []string
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	p.errs = append(p.errs, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) envString(name, def string) string {
+	if v := lookup(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// read a path, ~ and $VARS are expanded for the flags and the config file too
+func (p *parser) envPath(name, def string) string {
+	return p.expandPath(name, p.envString(name, def))
+}
+
+func (p *parser) expandPath(name, v string) string {
+	v = os.ExpandEnv(v)
+	if v == "~" || strings.HasPrefix(v, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			p.fail("error expanding ~ in %s: %v", name, err)
+			return v
+		}
+		v = filepath.Join(home, v[1:])
+	}
+	return v
+}
+
+// read octal permissions like 0750
+func (p *parser) envMode(name string, def os.FileMode) os.FileMode {
+	v := lookup(name)
+	if v == "" {
+		return def
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil {
+		p.fail("error converting %s to octal permissions: %v", name, err)
+		return def
+	}
+	return os.FileMode(mode)
+}
+
+// read duration like "10m", fallback to default if not set or invalid
+func (p *parser) envDuration(name string, def time.Duration) time.Duration {
+	v := lookup(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.fail("error converting %s to duration: %v", name, err)
+		return def
+	}
+	return d
+}
+
+// read float, fallback to default if not set or invalid
+func (p *parser) envFloat(name string, def float64) float64 {
+	v := lookup(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		p.fail("error converting %s to float: %v", name, err)
+		return def
+	}
+	return f
+}
+
+// read int, fallback to default if not set or invalid
+func (p *parser) envInt(name string, def int) int {
+	v := lookup(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail("error converting %s to int: %v", name, err)
+		return def
+	}
+	return i
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// fields applied without a restart, everything else needs one
+var liveFields = map[string]bool{
+	"ConnectionsLimit": true,
+	"LogLevel":         true,
+	"LogLevels":        true,
+	"SaveInterval":     true,
+}
+
+// Reload reads the config file again and returns the new config.
+// flags and env are fixed for the process and still win over the file.
+// the current values are kept on error
+func Reload() (*Config, error) {
+	fileOnce.Do(func() { readConfigFile(os.Getenv("CONFIG")) })
+	if configFile == "" {
+		return nil, errors.New("no config file, set it with --config or CONFIG")
+	}
+	values, err := loadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", configFile, err)
+	}
+	fileMu.Lock()
+	prev := fileValues
+	fileValues = values
+	fileMu.Unlock()
+
+	next := parse()
+	if err := next.Validate(); err != nil {
+		fileMu.Lock()
+		fileValues = prev
+		fileMu.Unlock()
+		return nil, err
+	}
+	return next, nil
+}
+
+// Diff lists the changed fields like "ConnectionsLimit 50 -> 100",
+// live ones can be applied right away, ignored ones need a restart
+func (c *Config) Diff(next *Config) (live, ignored []string) {
+	a, b := reflect.ValueOf(*c), reflect.ValueOf(*next)
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// unexported and the log file name with the start time
+		if f.PkgPath != "" || f.Name == "LogsFilename" {
+			continue
+		}
+		va, vb := a.Field(i).Interface(), b.Field(i).Interface()
+		if reflect.DeepEqual(va, vb) {
+			continue
+		}
+		change := fmt.Sprintf("%s %v -> %v", f.Name, va, vb)
+		if liveFields[f.Name] {
+			live = append(live, change)
+		} else {
+			ignored = append(ignored, change)
+		}
+	}
+	return live, ignored
+}
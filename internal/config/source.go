@@ -0,0 +1,303 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settings come from flags, env, the config file and the defaults, first set wins.
+// every setting has the env name, the flag is the same name like --dial-timeout
+// and the key in the yaml file is dial_timeout
+var settings = []struct {
+	name  string
+	usage string
+	bool  bool
+}{
+	{"CONN", "connections limit", false},
+	{"ADDR_CH_SIZE", "buffered addr batches from the peers, CONN by default", false},
+	{"ADAPTIVE_CONN", "lower the connections when the dials fail", true},
+	{"ADAPTIVE_ERROR_RATE", "failed dials share to back off, 0.9 by default", false},
+	{"PREEMPT_MAX", "close this many idle connections a minute for the queued nodes", false},
+	{"PREEMPT_IDLE", "silence of a connection to be closed by PREEMPT_MAX", false},
+	{"DIAL_TIMEOUT", "tcp dial timeout like 5s", false},
+	{"HANDSHAKE_TIMEOUT", "version handshake timeout", false},
+	{"MSG_READ_TIMEOUT", "max wait for the next message", false},
+	{"MAX_GOROUTINES", "do not dial over this many goroutines, 0 for no cap", false},
+	{"MAX_BANDWIDTH", "do not dial over this traffic in KB/s, 0 for no cap", false},
+	{"TCP_KEEPALIVE", "TCP keepalive period of the connections, 0 to disable", false},
+	{"DIAL_RETRIES", "redials after a timeout", false},
+	{"MAX_ATTEMPTS", "failed connects of an endpoint before it is not retried, 0 for no cap", false},
+	{"QUEUE_SORT", "dial order, random, fifo, announces or fresh", false},
+	{"DISK_QUEUE", "dial queue in a file and a bloom filter for the known addresses", true},
+	{"KNOWN_BLOOM", "bloom filter instead of the exact map for the known addresses", true},
+	{"BLOOM_ITEMS", "expected addresses for the bloom filter", false},
+	{"BLOOM_RATE", "false positive rate of the bloom filter, 0.001 by default", false},
+	{"MAX_KNOWN_NODES", "known addresses kept in the exact map, 0 for no cap", false},
+	{"MAX_DECODE_ERRORS", "undecodable messages before the disconnect", false},
+	{"BAN_TIME", "ban of the misbehaving ips, 0 to never ban them", false},
+	{"BAN_STRIKES", "misbehaviors of an ip before the ban", false},
+	{"BAN_FILE", "ips banned while listed, one per line", false},
+	{"WHITELIST", "only queue these endpoints, hosts and cidr ranges, one per line", false},
+	{"REQUIRED_SERVICES", "service bits good nodes must advertise", false},
+	{"MIN_PROTOCOL_VERSION", "reject nodes below this protocol version", false},
+	{"SKIP_LIMITED", "do not count pruned nodes as good", true},
+	{"STALE_BLOCKS", "blocks behind the median height to flag a node stale", false},
+	{"GETADDR_INTERVAL", "repeat getaddr on the good nodes, 0 to ask once", false},
+	{"GETADDR_MAX", "getaddr requests per node", false},
+	{"GETADDR_MIN_YIELD", "stop asking a node below this share of new addresses", false},
+	{"ADDR_MAX_AGE", "drop the addresses last seen before this, 0 to keep all", false},
+	{"MAX_DEPTH", "max hops from the seeds to queue, 0 for no limit", false},
+	{"FAST_CRAWL", "close the good nodes on the first addr answer", true},
+	{"FAST_MIN_ADDRS", "smallest addr message taken as the answer", false},
+	{"FAST_TIMEOUT", "max wait for the addr answer in the fast crawl", false},
+	{"V2_PROBE", "detect the BIP324 v2 transport, one more dial per node", true},
+	{"CONFIRM_PROBES", "more handshakes before a node is good, one more dial each", false},
+	{"CHAIN_CHECKPOINT", "block hash the peers must answer headers after", false},
+	{"INV_SAMPLE", "getdata for a sample of inv items, 0 to disable", false},
+	{"SEEDS", "extra nodes to connect to, comma separated", false},
+	{"SEED_FILE", "extra nodes to connect to, one per line", false},
+	{"BOOTSTRAP_RETRIES", "retries while no seed node is found, -1 for forever", false},
+	{"BOOTSTRAP_DELAY", "first delay between the bootstrap retries", false},
+	{"PROXY", "socks5 proxy host:port", false},
+	{"LOCAL_ADDR", "source ip of the outbound connections", false},
+	{"LISTEN_ADDR", "accept the peers on this address like :8333", false},
+	{"INBOUND_MAX", "max peers connected to us at once", false},
+	{"TESTNET", "crawl the testnet", true},
+	{"REGTEST", "crawl a local regtest", true},
+	{"MAGIC", "custom network magic", false},
+	{"USER_AGENT", "BIP14 user agent in our version message", false},
+	{"ADVERTISED_VERSION", "protocol version in our version message", false},
+	{"PORT", "custom default port", false},
+	{"MAX_DURATION", "stop the crawl after this duration", false},
+	{"DRAIN_TIMEOUT", "stop when no addresses came for this long", false},
+	{"MONITOR", "keep this many fastest good nodes connected, 0 to disable", false},
+	{"MONITOR_REFRESH", "resume the crawl in the monitor mode, 0 to never", false},
+	{"DAEMON", "crawl in cycles forever", true},
+	{"CYCLE_DURATION", "daemon cycle duration", false},
+	{"CYCLE_INTERVAL", "pause between the daemon cycles", false},
+	{"HISTORY", "jsonl file with a summary line per cycle", false},
+	{"STREAM", "jsonl file every good node is appended to when found", false},
+	{"RECORD_GRAPH", "write who sent which address to the graph file", true},
+	{"DATA_DIR", "data dir", false},
+	{"LOGS_DIR", "logs dir", false},
+	{"DIR_MODE", "created dirs permissions, octal", false},
+	{"FILE_MODE", "data files permissions, octal", false},
+	{"SAVE_INTERVAL", "how often the nodes file is rewritten", false},
+	{"SAVE_SORT", "saved nodes order, latency,services,height by default, none to disable", false},
+	{"MAX_SAVED", "best nodes to keep in the nodes file, 0 for all", false},
+	{"NODES_CACHE", "also save the nodes file in a binary cache, faster to load", true},
+	{"NODES_LOG_MAX_SIZE", "nodes log size in MB before the rotation on start, 0 to never rotate", false},
+	{"RESUME", "start from the nodes saved by the previous run", true},
+	{"RETENTION", "keep the saved nodes not good again for this long, 0 to drop them", false},
+	{"DEBUG", "debug logs and fewer connections", true},
+	{"LOGS", "log levels like client=debug,gui=warn", false},
+	{"LOG_FILE", "extra log file with all the output", false},
+	{"LOG_FORMAT", "log file format, text or json", false},
+	{"LOG_MAX_SIZE", "log file size in MB before the rotation", false},
+	{"LOG_MAX_FILES", "rotated log files to keep", false},
+	{"GUI", "terminal gui, on by default", true},
+	{"GUI_REFRESH_MS", "gui render interval in ms", false},
+	{"GUI_CHART_HISTORY", "chart points kept in the gui", false},
+	{"GUI_LOG_LINES", "log lines kept in the gui", false},
+	{"GUI_LOG_RATE", "log lines added per render", false},
+	{"GUI_CH_SIZE", "buffered stats and log lines of the gui, full drops them", false},
+	{"HTTP_ADDR", "web dashboard address like :8080", false},
+	{"HTTP_TOKEN", "web dashboard bearer token", false},
+	{"DEBUG_ADDR", "pprof and expvar address", false},
+	{"WEBHOOK_URL", "post the milestones as json to this url", false},
+	{"WEBHOOK_GOOD", "good nodes counts to post, comma separated", false},
+	{"WEBHOOK_TIMEOUT", "webhook post timeout", false},
+	{"GEO_FILE", "ip2asn tsv for the countries and ASNs table", false},
+	{"GEO_MMDB", "MaxMind country and ASN databases instead of GEO_FILE, comma separated", false},
+	{"PPROF", "debug server on localhost:6060", true},
+}
+
+var (
+	fileOnce   sync.Once
+	flagValues = make(map[string]string)
+	// replaced on reload, see Reload
+	fileMu     sync.RWMutex
+	fileValues = make(map[string]string)
+	configFile string
+	// left after the flags, the subcommand and its args
+	args []string
+	// errors are reported by Validate
+	flagErrs []string
+	fileErr  string
+
+	sharedOnce sync.Once
+	shared     *Config
+)
+
+// Shared is the config of the packages, read on the first use from the env,
+// the CONFIG file and the defaults. Init adds the command line to it
+func Shared() *Config {
+	sharedOnce.Do(func() { shared = parse() })
+	return shared
+}
+
+// Args returns the command line arguments left after the flags, see Init
+func Args() []string {
+	return args
+}
+
+// value of the setting by precedence, empty if not set anywhere
+func lookup(name string) string {
+	fileOnce.Do(func() { readConfigFile(os.Getenv("CONFIG")) })
+	if v, ok := flagValues[name]; ok {
+		return v
+	}
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	fileMu.RLock()
+	defer fileMu.RUnlock()
+	return fileValues[name]
+}
+
+// readConfigFile replaces the file values, none for an empty path
+func readConfigFile(path string) {
+	configFile, fileErr = path, ""
+	values := make(map[string]string)
+	if path != "" {
+		var err error
+		values, err = loadFile(path)
+		if err != nil {
+			fileErr = fmt.Sprintf("config file %s: %v", path, err)
+		}
+	}
+	fileMu.Lock()
+	fileValues = values
+	fileMu.Unlock()
+}
+
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// bool flag stored as "1" or "0" like the env variables
+type boolValue struct {
+	name string
+}
+
+func (b boolValue) String() string { return "" }
+
+func (b boolValue) IsBoolFlag() bool { return true }
+
+func (b boolValue) Set(v string) error {
+	switch strings.ToLower(v) {
+	case "1", "true":
+		flagValues[b.name] = "1"
+	case "0", "false":
+		flagValues[b.name] = "0"
+	default:
+		return fmt.Errorf("expected true or false, got %q", v)
+	}
+	return nil
+}
+
+type stringValue struct {
+	name string
+}
+
+func (s stringValue) String() string { return "" }
+
+func (s stringValue) Set(v string) error {
+	flagValues[s.name] = v
+	return nil
+}
+
+// Init reads the command line flags and the --config file over CONFIG, main calls it
+// before anything else. the shared config is read again in place with them
+func Init(arguments []string) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG"), "yaml config file, env CONFIG")
+	probe := fs.String("probe", "", "probe a single host:port and exit, same as the probe command")
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s, env %s", s.usage, s.name)
+		if s.bool {
+			fs.Var(boolValue{s.name}, flagName(s.name), usage)
+		} else {
+			fs.Var(stringValue{s.name}, flagName(s.name), usage)
+		}
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] [probe [--json] host:port | diff [--out dir] old.json new.json]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	// stops at the first non-flag, the subcommand keeps its own flags
+	err := fs.Parse(arguments)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		flagErrs = append(flagErrs, err.Error())
+	}
+	args = fs.Args()
+	if *probe != "" {
+		args = append([]string{"probe", *probe}, args...)
+	}
+	fileOnce.Do(func() {})
+	readConfigFile(*file)
+	*Shared() = *parse()
+}
+
+// flat yaml with the setting names as keys, lists are joined with commas.
+// values are kept as written, 0750 stays octal and 5s a duration
+func loadFile(path string) (map[string]string, error) {
+	fileValues := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileValues, err
+	}
+	raw := make(map[string]yaml.Node)
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fileValues, err
+	}
+	bools := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		bools[s.name] = s.bool
+	}
+	var unknown []string
+	for key, node := range raw {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		isBool, ok := bools[name]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			v := node.Value
+			if isBool {
+				switch strings.ToLower(v) {
+				case "true":
+					v = "1"
+				case "false":
+					v = "0"
+				}
+			}
+			fileValues[name] = v
+		case yaml.SequenceNode:
+			items := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				items = append(items, item.Value)
+			}
+			fileValues[name] = strings.Join(items, ",")
+		default:
+			return fileValues, fmt.Errorf("%s must be a value or a list", key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fileValues, fmt.Errorf("unknown keys %s", strings.Join(unknown, ", "))
+	}
+	return fileValues, nil
+}
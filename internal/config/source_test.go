@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetSources drops the command line and the file of Init after the test
+func resetSources(t *testing.T) {
+	t.Cleanup(func() {
+		flagValues, args, flagErrs = make(map[string]string), nil, nil
+		readConfigFile("")
+		*Shared() = *parse()
+	})
+}
+
+func TestInitPrecedence(t *testing.T) {
+	resetSources(t)
+	file := filepath.Join(t.TempDir(), "xray.yaml")
+	data := "conn: 10\ndial_timeout: 7s\nhandshake_timeout: 20s\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DIAL_TIMEOUT", "9s")
+	t.Setenv("MSG_READ_TIMEOUT", "1m")
+
+	Init([]string{"--config", file, "--conn", "42", "probe", "--json", "1.2.3.4:8333"})
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flags over env over the file over the defaults
+	if c.ConnectionsLimit != 42 {
+		t.Errorf("CONN = %d, want the flag 42", c.ConnectionsLimit)
+	}
+	if c.DialTimeout != 9*time.Second {
+		t.Errorf("DIAL_TIMEOUT = %s, want the env 9s", c.DialTimeout)
+	}
+	if c.HandshakeTimeout != 20*time.Second {
+		t.Errorf("HANDSHAKE_TIMEOUT = %s, want the file 20s", c.HandshakeTimeout)
+	}
+	if c.MsgReadTimeout != time.Minute {
+		t.Errorf("MSG_READ_TIMEOUT = %s, want the env 1m", c.MsgReadTimeout)
+	}
+	// the subcommand keeps its flags
+	if want := []string{"probe", "--json", "1.2.3.4:8333"}; !reflect.DeepEqual(Args(), want) {
+		t.Errorf("Args() = %q, want %q", Args(), want)
+	}
+	// the packages see the command line too
+	if Shared().ConnectionsLimit != 42 {
+		t.Errorf("shared CONN = %d, want 42", Shared().ConnectionsLimit)
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"unknown flag", []string{"--no-such-flag"}, "no-such-flag"},
+		{"bad value", []string{"--conn", "many"}, "error converting CONN to int"},
+		{"missing file", []string{"--config", "/nonexistent/xray.yaml"}, "config file /nonexistent/xray.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSources(t)
+			Init(tt.args)
+			_, err := New()
+			if err == nil {
+				t.Fatalf("no error, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
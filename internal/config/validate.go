@@ -0,0 +1,360 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	minConnections = 1
+	maxConnections = 10000
+	// goroutines of the workers, the gui and the web over the connectors
+	goroutinesReserved = 100
+	// version with the addr lists, older peers are long gone
+	minAdvertisedVersion = wire.MultipleAddressVersion
+	maxAdvertisedVersion = 100000
+)
+
+// BIP14 /name:version(comments)/ one or more times, comments are ; separated
+var userAgentRe = regexp.MustCompile(`^(/[^/:()]+:[^/:()]+(\([^()/]*\))?)+/$`)
+
+// Validate checks ranges and required fields,
+// error lists all the problems with the settings to fix them
+func (c *Config) Validate() error {
+	errs := append([]string(nil), c.errs...)
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	positive := func(name, env string, d time.Duration) {
+		if d <= 0 {
+			add("%s must be > 0, got %s (%s)", name, d, env)
+		}
+	}
+
+	switch c.Network {
+	case NetworkMainnet, NetworkTestnet, NetworkRegtest:
+	default:
+		add("unknown network %q, expected %s, %s or %s", c.Network, NetworkMainnet, NetworkTestnet, NetworkRegtest)
+	}
+	if c.Btcnet == 0 {
+		add("network magic is not set (MAGIC)")
+	}
+	if c.NodesPort == 0 {
+		add("nodes port is not set (PORT)")
+	}
+	if c.ConnectionsLimit < minConnections || c.ConnectionsLimit > maxConnections {
+		add("connections limit must be between %d and %d, got %d (CONN)", minConnections, maxConnections, c.ConnectionsLimit)
+	}
+	if c.AddrChSize < 1 {
+		add("addr channel size must be >= 1, got %d (ADDR_CH_SIZE)", c.AddrChSize)
+	}
+
+	switch c.QueueSort {
+	case QueueSortRandom, QueueSortFIFO, QueueSortAnnounces, QueueSortFresh:
+	default:
+		add("unknown queue sort %q, expected %s, %s, %s or %s (QUEUE_SORT)", c.QueueSort, QueueSortRandom, QueueSortFIFO, QueueSortAnnounces, QueueSortFresh)
+	}
+
+	positive("dial timeout", "DIAL_TIMEOUT", c.DialTimeout)
+	positive("handshake timeout", "HANDSHAKE_TIMEOUT", c.HandshakeTimeout)
+	positive("message read timeout", "MSG_READ_TIMEOUT", c.MsgReadTimeout)
+	// the idle connectors count too, a lower cap would never dial
+	if c.MaxGoroutines != 0 && c.MaxGoroutines <= c.ConnectionsLimit+goroutinesReserved {
+		add("max goroutines must be 0 or > CONN + %d, got %d (MAX_GOROUTINES)", goroutinesReserved, c.MaxGoroutines)
+	}
+	if c.MaxBandwidthKBps < 0 {
+		add("max bandwidth must be >= 0, got %d (MAX_BANDWIDTH)", c.MaxBandwidthKBps)
+	}
+	if c.TCPKeepAlive < 0 {
+		add("tcp keepalive must be >= 0, got %s (TCP_KEEPALIVE)", c.TCPKeepAlive)
+	}
+	if c.HandshakeTimeout < c.DialTimeout {
+		add("handshake timeout %s must be >= dial timeout %s (HANDSHAKE_TIMEOUT)", c.HandshakeTimeout, c.DialTimeout)
+	}
+	positive("ping timeout", "PingTimeout", c.PingTimeout)
+	positive("ping interval", "PingInterval", c.PingInterval)
+	positive("listen interval", "ListenInterval", c.ListenInterval)
+	positive("save interval", "SAVE_INTERVAL", c.SaveInterval)
+	if len(c.DnsSeeds) > 0 {
+		positive("dns timeout", "DnsTimeout", c.DnsTimeout)
+	}
+	if c.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+			add("listen address must be host:port or :port, got %q (LISTEN_ADDR)", c.ListenAddr)
+		}
+		if c.InboundMax < 1 {
+			add("inbound max must be >= 1, got %d (INBOUND_MAX)", c.InboundMax)
+		}
+	}
+	if c.Proxy != "" {
+		if _, _, err := net.SplitHostPort(c.Proxy); err != nil {
+			add("proxy must be host:port, got %q (PROXY)", c.Proxy)
+		}
+	}
+	if c.LocalAddr != "" {
+		if err := checkLocalAddr(c.LocalAddr); err != nil {
+			add("%v (LOCAL_ADDR)", err)
+		}
+	}
+	if c.AdaptiveConn && (c.AdaptiveErrorRate <= 0 || c.AdaptiveErrorRate > 1) {
+		add("adaptive error rate must be in (0, 1], got %g (ADAPTIVE_ERROR_RATE)", c.AdaptiveErrorRate)
+	}
+	if c.PreemptMax < 0 {
+		add("preempt max must be >= 0, got %d (PREEMPT_MAX)", c.PreemptMax)
+	}
+	if c.PreemptMax > 0 && c.PreemptIdle <= 0 {
+		add("preempt idle must be > 0, got %s (PREEMPT_IDLE)", c.PreemptIdle)
+	}
+	if c.WebhookURL != "" {
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("webhook url must be http(s)://host/path, got %q (WEBHOOK_URL)", c.WebhookURL)
+		}
+		positive("webhook timeout", "WEBHOOK_TIMEOUT", c.WebhookTimeout)
+		for _, t := range c.WebhookGood {
+			if t <= 0 {
+				add("webhook good nodes thresholds must be > 0, got %d (WEBHOOK_GOOD)", t)
+			}
+		}
+	}
+	if c.MinProtocolVersion < 0 {
+		add("min protocol version must be >= 0, got %d (MIN_PROTOCOL_VERSION)", c.MinProtocolVersion)
+	}
+	if c.AdvertisedVersion < minAdvertisedVersion || c.AdvertisedVersion > maxAdvertisedVersion {
+		add("advertised version must be between %d and %d, got %d (ADVERTISED_VERSION)", minAdvertisedVersion, maxAdvertisedVersion, c.AdvertisedVersion)
+	}
+	if len(c.UserAgent) > wire.MaxUserAgentLen {
+		add("user agent must be up to %d bytes, got %d (USER_AGENT)", wire.MaxUserAgentLen, len(c.UserAgent))
+	}
+	if !userAgentRe.MatchString(c.UserAgent) {
+		add("user agent must be in the BIP14 format like /name:1.0(comment)/, got %q (USER_AGENT)", c.UserAgent)
+	}
+	if c.DialRetries < 0 {
+		add("dial retries must be >= 0, got %d (DIAL_RETRIES)", c.DialRetries)
+	}
+	if c.NodesLogMaxSizeMB < 0 {
+		add("nodes log max size must be >= 0, got %d (NODES_LOG_MAX_SIZE)", c.NodesLogMaxSizeMB)
+	}
+	if c.Retention < 0 {
+		add("retention must be >= 0, got %s (RETENTION)", c.Retention)
+	}
+	// the json has no last good times, the carried nodes would never expire
+	if c.Retention > 0 && !c.NodesCache {
+		add("retention needs the nodes cache for the last good times (RETENTION, NODES_CACHE)")
+	}
+	if c.MaxAttempts < 0 {
+		add("max attempts must be >= 0, got %d (MAX_ATTEMPTS)", c.MaxAttempts)
+	}
+	if c.StaleBlocks < 0 {
+		add("stale blocks must be >= 0, got %d (STALE_BLOCKS)", c.StaleBlocks)
+	}
+	if c.ConfirmProbes < 0 {
+		add("confirm probes must be >= 0, got %d (CONFIRM_PROBES)", c.ConfirmProbes)
+	}
+	if c.InvSample < 0 {
+		add("inv sample must be >= 0, got %d (INV_SAMPLE)", c.InvSample)
+	}
+	if c.MaxDecodeErrors < 1 {
+		add("max decode errors must be >= 1, got %d (MAX_DECODE_ERRORS)", c.MaxDecodeErrors)
+	}
+	if c.BanTime < 0 {
+		add("ban time must be >= 0, got %s (BAN_TIME)", c.BanTime)
+	}
+	if c.BanStrikes < 1 {
+		add("ban strikes must be >= 1, got %d (BAN_STRIKES)", c.BanStrikes)
+	}
+	if c.MaxDuration < 0 {
+		add("max duration must be >= 0, got %s (MAX_DURATION)", c.MaxDuration)
+	}
+	if c.DrainTimeout < 0 {
+		add("drain timeout must be >= 0, got %s (DRAIN_TIMEOUT)", c.DrainTimeout)
+	}
+	if c.Monitor < 0 {
+		add("monitor must be >= 0, got %d (MONITOR)", c.Monitor)
+	}
+	if c.MonitorRefresh < 0 {
+		add("monitor refresh must be >= 0, got %s (MONITOR_REFRESH)", c.MonitorRefresh)
+	}
+	if c.Monitor > 0 && c.Daemon {
+		add("monitor keeps the connections of a single client, unset DAEMON (MONITOR)")
+	}
+	if c.Daemon {
+		positive("cycle duration", "CYCLE_DURATION", c.CycleDuration)
+		if c.CycleInterval < 0 {
+			add("cycle interval must be >= 0, got %s (CYCLE_INTERVAL)", c.CycleInterval)
+		}
+	}
+
+	// storage
+	if c.DataDir == "" {
+		add("data dir is not set")
+	}
+	if c.LogsDir == "" {
+		add("logs dir is not set")
+	}
+	if c.DirMode&0700 != 0700 {
+		add("dir mode %o must allow the owner to read, write and enter (DIR_MODE)", c.DirMode)
+	}
+	if c.FileMode&0600 != 0600 {
+		add("file mode %o must allow the owner to read and write (FILE_MODE)", c.FileMode)
+	}
+	for _, key := range c.SaveSort {
+		switch key {
+		case SortLatency, SortServices, SortHeight:
+		default:
+			add("unknown save sort key %q, expected %s, %s or %s (SAVE_SORT)", key, SortLatency, SortServices, SortHeight)
+		}
+	}
+	if c.MaxSaved < 0 {
+		add("max saved must be >= 0, got %d (MAX_SAVED)", c.MaxSaved)
+	}
+	if c.NodesFilename == "" || c.NodesLogFilename == "" || c.RejectedLogFilename == "" {
+		add("nodes filename is not set")
+	}
+	if c.DiskQueue || c.KnownBloom {
+		if c.BloomItems < 1 {
+			add("bloom items must be >= 1, got %d (BLOOM_ITEMS)", c.BloomItems)
+		}
+		if c.BloomRate <= 0 || c.BloomRate >= 1 {
+			add("bloom rate must be in (0, 1), got %g (BLOOM_RATE)", c.BloomRate)
+		}
+	}
+	if c.MaxKnownNodes < 0 {
+		add("max known nodes must be >= 0, got %d (MAX_KNOWN_NODES)", c.MaxKnownNodes)
+	}
+	// the bloom filter keeps no nodes to evict
+	if c.MaxKnownNodes > 0 && (c.DiskQueue || c.KnownBloom) {
+		add("MAX_KNOWN_NODES is not supported with KNOWN_BLOOM or DISK_QUEUE")
+	}
+
+	if c.GetAddrInterval < 0 {
+		add("getaddr interval must be >= 0, got %s (GETADDR_INTERVAL)", c.GetAddrInterval)
+	}
+	if c.MaxDepth < 0 {
+		add("max depth must be >= 0, got %d (MAX_DEPTH)", c.MaxDepth)
+	}
+	// the spooled nodes lose their depth
+	if c.MaxDepth > 0 && c.DiskQueue {
+		add("MAX_DEPTH is not supported with DISK_QUEUE")
+	}
+	if c.AddrMaxAge < 0 {
+		add("addr max age must be >= 0, got %s (ADDR_MAX_AGE)", c.AddrMaxAge)
+	}
+	if c.GetAddrInterval > 0 {
+		if c.GetAddrMax < 1 {
+			add("getaddr max must be >= 1, got %d (GETADDR_MAX)", c.GetAddrMax)
+		}
+		if c.GetAddrMinYield < 0 || c.GetAddrMinYield > 1 {
+			add("getaddr min yield must be in [0, 1], got %g (GETADDR_MIN_YIELD)", c.GetAddrMinYield)
+		}
+	}
+	if c.FastCrawl {
+		if c.GetAddrInterval > 0 {
+			add("fast crawl closes the nodes after the first answer, unset GETADDR_INTERVAL (FAST_CRAWL)")
+		}
+		if c.FastMinAddrs < 1 {
+			add("fast min addrs must be >= 1, got %d (FAST_MIN_ADDRS)", c.FastMinAddrs)
+		}
+		positive("fast timeout", "FAST_TIMEOUT", c.FastTimeout)
+	}
+	if c.BootstrapRetries != 0 {
+		positive("bootstrap delay", "BOOTSTRAP_DELAY", c.BootstrapDelay)
+	}
+	if c.SeedFile != "" {
+		if f, err := os.Open(c.SeedFile); err != nil {
+			add("seed file is not readable: %v (SEED_FILE)", err)
+		} else {
+			f.Close()
+		}
+	}
+	if c.BanFile != "" {
+		if f, err := os.Open(c.BanFile); err != nil {
+			add("ban file is not readable: %v (BAN_FILE)", err)
+		} else {
+			f.Close()
+		}
+	}
+	if c.Whitelist != "" {
+		if f, err := os.Open(c.Whitelist); err != nil {
+			add("whitelist is not readable: %v (WHITELIST)", err)
+		} else {
+			f.Close()
+		}
+	}
+	if c.GeoFile != "" {
+		if f, err := os.Open(c.GeoFile); err != nil {
+			add("geo file is not readable: %v (GEO_FILE)", err)
+		} else {
+			f.Close()
+		}
+	}
+	for _, path := range c.GeoMMDB {
+		if f, err := os.Open(path); err != nil {
+			add("geo database is not readable: %v (GEO_MMDB)", err)
+		} else {
+			f.Close()
+		}
+	}
+	if c.GeoFile != "" && len(c.GeoMMDB) > 0 {
+		add("geo file and geo databases are exclusive (GEO_FILE, GEO_MMDB)")
+	}
+
+	// logs
+	if c.LogFile != "" {
+		if c.LogFormat != "text" && c.LogFormat != "json" {
+			add("log format must be text or json, got %q (LOG_FORMAT)", c.LogFormat)
+		}
+		if c.LogMaxSizeMB <= 0 {
+			add("log max size must be > 0, got %d (LOG_MAX_SIZE)", c.LogMaxSizeMB)
+		}
+		if c.LogMaxFiles < 0 {
+			add("log max files must be >= 0, got %d (LOG_MAX_FILES)", c.LogMaxFiles)
+		}
+	}
+
+	// gui, the web dashboard keeps the chart history and the log lines without it too
+	if c.GUIRefreshMs <= 0 {
+		add("gui refresh must be > 0, got %d (GUI_REFRESH_MS)", c.GUIRefreshMs)
+	}
+	if c.ChartHistory < 2 {
+		add("chart history must be >= 2, got %d (GUI_CHART_HISTORY)", c.ChartHistory)
+	}
+	if c.LogLines <= 0 {
+		add("log lines must be > 0, got %d (GUI_LOG_LINES)", c.LogLines)
+	}
+	if c.LogRate <= 0 {
+		add("log rate must be > 0, got %d (GUI_LOG_RATE)", c.LogRate)
+	}
+	if c.GUIChSize < 1 {
+		add("gui channel size must be >= 1, got %d (GUI_CH_SIZE)", c.GUIChSize)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// the ip must be on one of our interfaces, the dials would fail on every node otherwise
+func checkLocalAddr(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("local address must be an ip, got %q", addr)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed to list the interfaces for %s: %v", addr, err)
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("local address %s is not on any interface", addr)
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	if _, err := New(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"no connections", func(c *Config) { c.ConnectionsLimit = 0 }, "connections limit must be between 1 and 10000, got 0 (CONN)"},
+		{"too many connections", func(c *Config) { c.ConnectionsLimit = 10001 }, "got 10001 (CONN)"},
+		{"unknown network", func(c *Config) { c.Network = "signet" }, `unknown network "signet"`},
+		{"no magic", func(c *Config) { c.Btcnet = 0 }, "(MAGIC)"},
+		{"no port", func(c *Config) { c.NodesPort = 0 }, "(PORT)"},
+		{"zero dial timeout", func(c *Config) { c.DialTimeout = 0 }, "dial timeout must be > 0, got 0s (DIAL_TIMEOUT)"},
+		{"zero handshake timeout", func(c *Config) { c.HandshakeTimeout = 0 }, "(HANDSHAKE_TIMEOUT)"},
+		{"negative read timeout", func(c *Config) { c.MsgReadTimeout = -time.Second }, "(MSG_READ_TIMEOUT)"},
+		{"handshake under dial", func(c *Config) { c.DialTimeout, c.HandshakeTimeout = 10*time.Second, 5*time.Second }, "must be >= dial timeout"},
+		{"zero save interval", func(c *Config) { c.SaveInterval = 0 }, "(SAVE_INTERVAL)"},
+		{"unknown queue sort", func(c *Config) { c.QueueSort = "lifo" }, "(QUEUE_SORT)"},
+		{"goroutines under the connectors", func(c *Config) { c.MaxGoroutines = c.ConnectionsLimit }, "(MAX_GOROUTINES)"},
+		{"negative bandwidth", func(c *Config) { c.MaxBandwidthKBps = -1 }, "(MAX_BANDWIDTH)"},
+		{"no data dir", func(c *Config) { c.DataDir = "" }, "data dir is not set"},
+		{"no logs dir", func(c *Config) { c.LogsDir = "" }, "logs dir is not set"},
+		{"dir mode not writable", func(c *Config) { c.DirMode = 0500 }, "(DIR_MODE)"},
+		{"file mode not writable", func(c *Config) { c.FileMode = 0444 }, "(FILE_MODE)"},
+		{"unknown save sort", func(c *Config) { c.SaveSort = []string{"age"} }, "(SAVE_SORT)"},
+		{"retention without cache", func(c *Config) { c.Retention, c.NodesCache = time.Hour, false }, "(RETENTION, NODES_CACHE)"},
+		{"bloom rate", func(c *Config) { c.KnownBloom, c.BloomRate = true, 1 }, "(BLOOM_RATE)"},
+		{"known nodes cap with bloom", func(c *Config) { c.KnownBloom, c.MaxKnownNodes = true, 1000 }, "MAX_KNOWN_NODES is not supported"},
+		{"depth with disk queue", func(c *Config) { c.DiskQueue, c.MaxDepth = true, 3 }, "MAX_DEPTH is not supported"},
+		{"fast crawl with getaddr interval", func(c *Config) { c.FastCrawl, c.GetAddrInterval = true, time.Minute }, "(FAST_CRAWL)"},
+		{"ban strikes", func(c *Config) { c.BanStrikes = 0 }, "(BAN_STRIKES)"},
+		{"monitor with daemon", func(c *Config) { c.Monitor, c.Daemon = 8, true }, "(MONITOR)"},
+		{"listen address", func(c *Config) { c.ListenAddr = "8333" }, "(LISTEN_ADDR)"},
+		{"proxy", func(c *Config) { c.Proxy = "localhost" }, "(PROXY)"},
+		{"local address", func(c *Config) { c.LocalAddr = "not an ip" }, "(LOCAL_ADDR)"},
+		{"webhook url", func(c *Config) { c.WebhookURL = "ftp://example.com" }, "(WEBHOOK_URL)"},
+		{"advertised version", func(c *Config) { c.AdvertisedVersion = 100 }, "(ADVERTISED_VERSION)"},
+		{"user agent format", func(c *Config) { c.UserAgent = "xray" }, "BIP14"},
+		{"user agent length", func(c *Config) { c.UserAgent = "/" + strings.Repeat("x", 300) + ":1/" }, "up to 256 bytes"},
+		{"missing seed file", func(c *Config) { c.SeedFile = filepath.Join(t.TempDir(), "seeds.txt") }, "(SEED_FILE)"},
+		{"geo file and databases", func(c *Config) { c.GeoFile, c.GeoMMDB = "geo.csv", []string{"geo.mmdb"} }, "(GEO_FILE, GEO_MMDB)"},
+		{"log format", func(c *Config) { c.LogFile, c.LogFormat = "xray.log", "xml" }, "(LOG_FORMAT)"},
+		{"gui chart history", func(c *Config) { c.Gui, c.ChartHistory = true, 1 }, "(GUI_CHART_HISTORY)"},
+		{"gui channel", func(c *Config) { c.Gui, c.GUIChSize = true, 0 }, "(GUI_CH_SIZE)"},
+		// used by the web dashboard too
+		{"gui log lines without gui", func(c *Config) { c.Gui, c.LogLines = false, 0 }, "(GUI_LOG_LINES)"},
+		{"gui refresh without gui", func(c *Config) { c.Gui, c.GUIRefreshMs = false, 0 }, "(GUI_REFRESH_MS)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parse()
+			tt.modify(c)
+			err := c.Validate()
+			if err == nil {
+				t.Fatalf("no error, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateListsAll(t *testing.T) {
+	c := parse()
+	c.ConnectionsLimit = 0
+	c.DialTimeout = 0
+	c.DataDir = ""
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"(CONN)", "(DIAL_TIMEOUT)", "data dir is not set"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	tests := []struct {
+		env, value string
+		want       string
+	}{
+		{"CONN", "many", "error converting CONN to int"},
+		{"DIAL_TIMEOUT", "5", "error converting DIAL_TIMEOUT to duration"},
+		{"DIR_MODE", "rwx", "error converting DIR_MODE to octal permissions"},
+		{"CONN", "0", "(CONN)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			_, err := New()
+			if err == nil {
+				t.Fatalf("no error, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
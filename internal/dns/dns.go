@@ -10,44 +10,51 @@ import (
 	"github.com/miekg/dns"
 )
 
-var cfg = config.New()
+var cfg = config.Shared()
 
 type DNS struct {
-	log       *logger.Logger
+	log       logger.Interface
 	dnsSeeds  []string
 	dnsServer string
 	timeout   time.Duration
 }
 
-func New(log *logger.Logger) *DNS {
+func New(log logger.Interface) *DNS {
 	// check config vars
 	if cfg.DnsSeeds == nil || cfg.DnsAddress == "" || cfg.DnsTimeout == 0 {
 		log.Fatal("dns config is not set")
 	}
 	return &DNS{
-		log:       log,
+		log:       log.With(logger.FieldModule, "dns"),
 		dnsSeeds:  cfg.DnsSeeds,
 		dnsServer: cfg.DnsAddress,
 		timeout:   cfg.DnsTimeout,
 	}
 }
 
-func (d *DNS) Scan() []string {
+// Scan returns the new nodes of every seed, a node returned by many seeds
+// is only in the first one
+func (d *DNS) Scan() map[string][]string {
 	ips := make(map[string]struct{}, 0)
+	bySeed := make(map[string][]string, len(d.dnsSeeds))
 	c := new(dns.Client)
 	m := new(dns.Msg)
 	c.Net = "tcp"
+	failed := 0
 	for _, seed := range d.dnsSeeds {
-		d.log.Infof("[DNS]:[%s] asking for nodes\n", seed)
+		log := d.log.With("seed", seed)
+		log.Info("asking for nodes")
 		c.Timeout = cfg.DnsTimeout
 		m.SetQuestion(dns.Fqdn(seed), dns.TypeA)
 		in, _, err := c.Exchange(m, d.dnsServer)
 		if err != nil {
-			d.log.Warnf("[DNS]:[%s] error %v\n", seed, err)
+			log.Warnf("error %v\n", err)
+			failed++
 			continue
 		}
 		if len(in.Answer) == 0 {
-			d.log.Warnf("[DNS]:[%s] no nodes found\n", seed)
+			log.Warn("no nodes found")
+			failed++
 			continue
 		}
 		// loop through dns records
@@ -55,28 +62,24 @@ func (d *DNS) Scan() []string {
 		for _, ans := range in.Answer {
 			// check that record is valid
 			if _, ok := ans.(*dns.A); !ok {
-				d.log.Warnf("[DNS]:[%s] invalid dns record, skipping\n", seed)
+				log.Warn("invalid dns record, skipping")
 				continue
 			}
 			// only add new ones
 			ip := ans.(*dns.A).A.String()
 			if _, ok := ips[ip]; ok {
-				d.log.Debugf("[DNS]:[%s] got duplicate ip %v\n", seed, ip)
+				log.Debugf("got duplicate ip %v\n", ip)
 				continue
 			}
 			ips[ip] = struct{}{}
+			bySeed[seed] = append(bySeed[seed], ip)
 			new++
 		}
-		if new > 0 {
-			d.log.Infof("[DNS]:[%s] found %d new nodes\n", seed, new)
-		} else {
-			d.log.Debugf("[DNS]:[%s] no new nodes\n", seed)
-		}
+		log.Infof("found %d nodes, %d new\n", len(in.Answer), new)
 	}
-	d.log.Infof("[DNS]: finished scan. Got %d nodes from %d seeds\n", len(ips), len(d.dnsSeeds))
-	ret := make([]string, 0, len(ips))
-	for ip := range ips {
-		ret = append(ret, ip)
+	if failed == len(d.dnsSeeds) {
+		d.log.Errorf("all %d seeds failed, firewalled or offline?", failed)
 	}
-	return ret
+	d.log.Infof("finished scan. Got %d nodes from %d seeds\n", len(ips), len(d.dnsSeeds))
+	return bySeed
 }
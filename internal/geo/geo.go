@@ -0,0 +1,153 @@
+// geo looks up the country and the ASN of an ip in a local ip2asn file,
+// tab separated "range_start range_end AS_number country_code AS_description"
+// like https://iptoasn.com/data/ip2asn-combined.tsv.gz after gunzip,
+// or in MaxMind databases like GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AnonymousNetwork is the country and the AS name of the onion and i2p nodes
+const AnonymousNetwork = "anonymous network"
+
+// Info is empty for the unknown and the not routed ranges
+type Info struct {
+	ASN     int
+	Country string
+	Org     string
+	// onion or i2p, Country is AnonymousNetwork
+	Anonymous bool
+}
+
+// Known is false for the ips outside of the file ranges
+func (i Info) Known() bool {
+	return i.ASN != 0 || i.Country != ""
+}
+
+// AS name for the tables, "AS13335 CLOUDFLARENET"
+func (i Info) ASName() string {
+	if i.Anonymous {
+		return AnonymousNetwork
+	}
+	if i.ASN == 0 {
+		return ""
+	}
+	if i.Org == "" {
+		return fmt.Sprintf("AS%d", i.ASN)
+	}
+	return fmt.Sprintf("AS%d %s", i.ASN, i.Org)
+}
+
+type ipRange struct {
+	start, end net.IP // 16 bytes
+	info       Info
+}
+
+// DB is read only after Load, safe for concurrent use
+type DB struct {
+	ranges []ipRange
+	mmdbs  []*mmdb
+}
+
+// LoadMMDB reads the MaxMind databases, usually a country and an ASN one.
+// the first database with a field wins
+func LoadMMDB(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		m, err := loadMMDB(path)
+		if err != nil {
+			return nil, err
+		}
+		db.mmdbs = append(db.mmdbs, m)
+	}
+	return db, nil
+}
+
+func Load(path string) (*DB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db := &DB{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		start, end := net.ParseIP(fields[0]).To16(), net.ParseIP(fields[1]).To16()
+		asn, err := strconv.Atoi(fields[2])
+		if start == nil || end == nil || err != nil {
+			return nil, fmt.Errorf("%s:%d: bad range", path, line)
+		}
+		info := Info{ASN: asn, Country: fields[3]}
+		if len(fields) > 4 {
+			info.Org = fields[4]
+		}
+		// "None" is the not routed range
+		if info.Country == "None" {
+			info.Country = ""
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, info: info})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return db, nil
+}
+
+// Lookup accepts an ip or a host:port, the zero Info if not found
+func (db *DB) Lookup(addr string) Info {
+	if db == nil {
+		return Info{}
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if strings.HasSuffix(addr, ".onion") || strings.HasSuffix(addr, ".i2p") {
+		return Info{Country: AnonymousNetwork, Anonymous: true}
+	}
+	ip := net.ParseIP(strings.Trim(addr, "[]")).To16()
+	if ip == nil {
+		return Info{}
+	}
+	if len(db.mmdbs) > 0 {
+		var info Info
+		for _, m := range db.mmdbs {
+			m.fill(ip, &info)
+		}
+		return info
+	}
+	// last range starting at or before the ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return Info{}
+	}
+	return db.ranges[i].info
+}
+
+// Len returns the number of the loaded ranges, the search tree nodes for the MaxMind databases
+func (db *DB) Len() int {
+	n := len(db.ranges)
+	for _, m := range db.mmdbs {
+		n += int(m.reader.Metadata.NodeCount)
+	}
+	return n
+}
@@ -0,0 +1,43 @@
+package geo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	ranges := "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+		"2001:db8::\t2001:db8::ffff\t3320\tDE\tDTAG\n" +
+		"10.0.0.0\t10.255.255.255\t0\tNone\tNot routed\n"
+	if err := os.WriteFile(path, []byte(ranges), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloudflare := Info{ASN: 13335, Country: "US", Org: "CLOUDFLARENET"}
+	dtag := Info{ASN: 3320, Country: "DE", Org: "DTAG"}
+	tests := []struct {
+		addr string
+		want Info
+	}{
+		{"1.0.0.1", cloudflare},
+		{"1.0.0.1:8333", cloudflare},
+		{"2001:db8::1", dtag},
+		{"[2001:db8::1]:8333", dtag},
+		{"[2001:db8::1]", dtag},
+		{"2001:db8:1::1", Info{}},
+		{"10.1.2.3", Info{Org: "Not routed"}},
+		{"8.8.8.8", Info{}},
+		{"abcdefghijklmnop.onion", Info{Country: AnonymousNetwork, Anonymous: true}},
+		{"[abcdefghijklmnop.onion]:8333", Info{Country: AnonymousNetwork, Anonymous: true}},
+	}
+	for _, tt := range tests {
+		if got := db.Lookup(tt.addr); got != tt.want {
+			t.Errorf("Lookup(%s) = %+v, want %+v", tt.addr, got, tt.want)
+		}
+	}
+}
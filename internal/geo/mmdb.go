@@ -0,0 +1,45 @@
+package geo
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdb is a MaxMind DB file like GeoLite2-Country.mmdb or GeoLite2-ASN.mmdb
+type mmdb struct {
+	reader *maxminddb.Reader
+}
+
+// the fields of Info in the country and the ASN databases
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+func loadMMDB(path string) (*mmdb, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &mmdb{reader: reader}, nil
+}
+
+// fills the fields of info the database has, an ipv6 in an ipv4 database
+// or a corrupt record fill nothing
+func (db *mmdb) fill(ip net.IP, info *Info) {
+	var rec mmdbRecord
+	if err := db.reader.Lookup(ip, &rec); err != nil {
+		return
+	}
+	if info.Country == "" {
+		info.Country = rec.Country.ISOCode
+	}
+	if info.ASN == 0 && rec.ASN != 0 {
+		info.ASN, info.Org = int(rec.ASN), rec.Org
+	}
+}
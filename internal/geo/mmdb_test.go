@@ -0,0 +1,202 @@
+package geo
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fixture database writer, 24 bit records, see https://maxmind.github.io/MaxMind-DB/
+
+// start of the metadata at the end of the file
+var mmdbMetadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+func mmdbString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{2<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{byte(2<<5 | len(s))}, s...)
+}
+
+func mmdbUint(typ byte, v uint32) []byte {
+	var b []byte
+	for v > 0 {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+	}
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+func mmdbMap(size int) []byte {
+	return []byte{byte(7<<5 | size)}
+}
+
+// one record of DE, AS3320 DTAG at the data offset 0
+func mmdbData() []byte {
+	data := mmdbMap(3)
+	data = append(data, mmdbString("country")...)
+	data = append(data, mmdbMap(1)...)
+	data = append(data, mmdbString("iso_code")...)
+	data = append(data, mmdbString("DE")...)
+	data = append(data, mmdbString("autonomous_system_number")...)
+	data = append(data, mmdbUint(6, 3320)...)
+	data = append(data, mmdbString("autonomous_system_organization")...)
+	data = append(data, mmdbString("DTAG")...)
+	return data
+}
+
+// mmdbFile is the tree nodes as left and right records, a record over
+// the node count points to the data at record - node count - 16
+func mmdbFile(ipVersion, nodeCount uint32, tree [][2]uint32, data []byte) []byte {
+	var file []byte
+	for _, node := range tree {
+		for _, r := range node {
+			file = append(file, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	file = append(file, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, mmdbMetadataStart...)
+	file = append(file, mmdbMap(5)...)
+	file = append(file, mmdbString("binary_format_major_version")...)
+	file = append(file, mmdbUint(5, 2)...)
+	file = append(file, mmdbString("database_type")...)
+	file = append(file, mmdbString("xray-test")...)
+	file = append(file, mmdbString("node_count")...)
+	file = append(file, mmdbUint(6, nodeCount)...)
+	file = append(file, mmdbString("record_size")...)
+	file = append(file, mmdbUint(5, 24)...)
+	file = append(file, mmdbString("ip_version")...)
+	file = append(file, mmdbUint(5, ipVersion)...)
+	return file
+}
+
+func writeMMDB(t *testing.T, ipVersion uint32, tree [][2]uint32, data []byte) string {
+	t.Helper()
+	return writeFile(t, mmdbFile(ipVersion, uint32(len(tree)), tree, data))
+}
+
+func writeFile(t *testing.T, file []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.mmdb")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMMDBLookup(t *testing.T) {
+	// ipv4 tree: 0.0.0.0/1 has the record, 128.0.0.0/1 nothing
+	v4 := [][2]uint32{{1 + 16, 1}}
+	// ipv6 tree: ::/96 down the left records to the ipv4 node 96, the rest is empty
+	v6 := make([][2]uint32, 97)
+	for i := 0; i < 96; i++ {
+		v6[i] = [2]uint32{uint32(i + 1), 97}
+	}
+	v6[96] = [2]uint32{97 + 16, 97}
+	tests := []struct {
+		name      string
+		ipVersion uint32
+		tree      [][2]uint32
+		ip        string
+		want      Info
+	}{
+		{"ipv4 tree found", 4, v4, "1.2.3.4", Info{Country: "DE", ASN: 3320, Org: "DTAG"}},
+		{"ipv4 tree missing", 4, v4, "200.2.3.4", Info{}},
+		{"ipv4 tree skips ipv6", 4, v4, "2001:db8::1", Info{}},
+		{"ipv6 tree ipv4 found", 6, v6, "1.2.3.4", Info{Country: "DE", ASN: 3320, Org: "DTAG"}},
+		{"ipv6 tree ipv4 missing", 6, v6, "200.2.3.4", Info{}},
+		{"ipv6 tree ipv6 missing", 6, v6, "2001:db8::1", Info{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := loadMMDB(writeMMDB(t, tt.ipVersion, tt.tree, mmdbData()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Info
+			db.fill(net.ParseIP(tt.ip), &got)
+			if got != tt.want {
+				t.Errorf("fill(%s) = %+v, want %+v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMMDBFillKeepsFirst(t *testing.T) {
+	db, err := loadMMDB(writeMMDB(t, 4, [][2]uint32{{1 + 16, 1}}, mmdbData()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Info{Country: "FR", ASN: 1, Org: "first"}
+	db.fill(net.ParseIP("1.2.3.4"), &got)
+	if want := (Info{Country: "FR", ASN: 1, Org: "first"}); got != want {
+		t.Errorf("fill over a filled info = %+v, want %+v", got, want)
+	}
+}
+
+func TestMMDBCorruptData(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// a pointer to itself
+		{"pointer loop", []byte{1 << 5, 0}},
+		// two pointers to each other
+		{"pointer cycle", []byte{1 << 5, 2, 1 << 5, 0}},
+		// a map of 16M entries in a few bytes
+		{"map size", []byte{7<<5 | 31, 0xff, 0xff, 0xff}},
+		// an extended array of 16M entries
+		{"array size", []byte{31, 4, 0xff, 0xff, 0xff}},
+		{"truncated string", []byte{2<<5 | 10, 'a'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the lookups of a corrupt record find nothing
+			db, err := loadMMDB(writeMMDB(t, 4, [][2]uint32{{1 + 16, 1}}, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got Info
+			db.fill(net.ParseIP("1.2.3.4"), &got)
+			if got != (Info{}) {
+				t.Errorf("fill = %+v, want nothing", got)
+			}
+		})
+	}
+}
+
+func TestLoadMMDBErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"not a database", []byte("not a database")},
+		{"tree over the file size", mmdbFile(4, 1000, [][2]uint32{{1 + 16, 1}}, mmdbData())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadMMDB(writeFile(t, tt.file)); err == nil {
+				t.Error("loadMMDB succeeded")
+			}
+		})
+	}
+}
+
+// the lookups by host, bracketed or not, go through the databases
+func TestLoadMMDBLookupHost(t *testing.T) {
+	db, err := LoadMMDB(writeMMDB(t, 4, [][2]uint32{{1 + 16, 1}}, mmdbData()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{Country: "DE", ASN: 3320, Org: "DTAG"}
+	for _, addr := range []string{"1.2.3.4", "1.2.3.4:8333", "::ffff:1.2.3.4", "[::ffff:1.2.3.4]:8333"} {
+		if got := db.Lookup(addr); got != want {
+			t.Errorf("Lookup(%s) = %+v, want %+v", addr, got, want)
+		}
+	}
+	if got := db.Lookup("[2001:db8::1]:8333"); got != (Info{}) {
+		t.Errorf("ipv6 in an ipv4 database = %+v, want nothing", got)
+	}
+}
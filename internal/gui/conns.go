@@ -0,0 +1,70 @@
+package gui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/stats"
+
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+var connsHeader = []string{"Endpoint", "State", "In", "Out", "Age"}
+
+// active connections overlay, refreshed on every render until any key
+func newConnsView() *widgets.Table {
+	table := widgets.NewTable()
+	table.RowSeparator = false
+	table.FillRow = false
+	table.BorderStyle.Fg = tui.ColorCyan
+	table.TextStyle = tui.NewStyle(tui.ColorWhite)
+	table.RowStyles[0] = tui.NewStyle(tui.ColorCyan, tui.ColorClear, tui.ModifierBold)
+	return table
+}
+
+// oldest connections that fit the terminal, the rest is counted in the title
+func updateConnsView(table *widgets.Table, conns []stats.ConnInfo, termWidth, termHeight int) {
+	w, h := termWidth*3/4, termHeight*3/4
+	x, y := (termWidth-w)/2, (termHeight-h)/2
+	table.SetRect(x, y, x+w, y+h)
+
+	fixed := []int{12, 9, 9, 8}
+	endpointW := table.Inner.Dx() - fixed[0] - fixed[1] - fixed[2] - fixed[3]
+	if endpointW < 2 {
+		endpointW = 2
+	}
+	table.ColumnWidths = []int{endpointW, fixed[0], fixed[1], fixed[2], fixed[3]}
+
+	shown := conns
+	if max := table.Inner.Dy() - 1; max >= 0 && len(shown) > max {
+		shown = shown[:max]
+	}
+	table.Title = fmt.Sprintf("Connections %d, any key to close", len(conns))
+	if len(shown) < len(conns) {
+		table.Title = fmt.Sprintf("Connections %d (%d shown), any key to close", len(conns), len(shown))
+	}
+	rows := make([][]string, 0, len(shown)+1)
+	rows = append(rows, connsHeader)
+	for _, c := range shown {
+		rows = append(rows, []string{
+			truncateMiddle(c.Endpoint, endpointW-1),
+			c.State,
+			formatBytes(c.BytesIn),
+			formatBytes(c.BytesOut),
+			c.Age.Round(time.Second).String(),
+		})
+	}
+	table.Rows = rows
+}
+
+func formatBytes(b uint64) string {
+	switch {
+	case b >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(b)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", b)
+	}
+}
@@ -0,0 +1,55 @@
+package gui
+
+import (
+	"fmt"
+
+	"github.com/1F47E/go-btc-xray/internal/stats"
+
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+var geoHeader = []string{"Country", "Nodes", "ASN", "Nodes"}
+
+// shown only with GEO_FILE or GEO_MMDB, countries and ASNs are ranked separately side by side
+func newGeoTable() *widgets.Table {
+	table := widgets.NewTable()
+	table.Title = "Top countries and ASNs"
+	table.RowSeparator = false
+	table.FillRow = false
+	table.TextStyle = tui.NewStyle(tui.ColorWhite)
+	table.RowStyles[0] = tui.NewStyle(tui.ColorCyan, tui.ColorClear, tui.ModifierBold)
+	table.Rows = [][]string{geoHeader}
+	return table
+}
+
+// ASN name takes the space left from the country and the counts
+func updateGeoTable(table *widgets.Table, countries, asns []stats.GeoCount) {
+	width := table.Inner.Dx()
+	fixed := []int{8, 6, 6}
+	asnW := width - fixed[0] - fixed[1] - fixed[2]
+	if asnW < 2 {
+		asnW = 2
+	}
+	table.ColumnWidths = []int{fixed[0], fixed[1], asnW, fixed[2]}
+
+	lines := len(countries)
+	if len(asns) > lines {
+		lines = len(asns)
+	}
+	rows := make([][]string, 0, lines+1)
+	rows = append(rows, geoHeader)
+	for i := 0; i < lines; i++ {
+		row := make([]string, 4)
+		if i < len(countries) {
+			row[0] = countries[i].Name
+			row[1] = fmt.Sprintf("%d", countries[i].Count)
+		}
+		if i < len(asns) {
+			row[2] = truncateEnd(asns[i].Name, asnW-1)
+			row[3] = fmt.Sprintf("%d", asns[i].Count)
+		}
+		rows = append(rows, row)
+	}
+	table.Rows = rows
+}
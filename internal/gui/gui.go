@@ -3,58 +3,126 @@ package gui
 import (
 	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"runtime"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/metrics"
+	"github.com/1F47E/go-btc-xray/internal/stats"
 
 	tui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 )
 
-var cfg = config.New()
-
-const LEN_LOGS = 25
-const LEN_CONN = 14
-const LEN_NODES = 32
+var cfg = config.Shared()
 
+// stats or a log line, message rates are calculated by the gui
 type IncomingData struct {
-	Connections int
-	NodesTotal  int
-	NodesGood   int
-	NodesDead   int32
-	NodesQueued int
-	Log         string
-	Msg         string
+	stats.Stats
+	Log   string
+	Msg   string
+	Level Level
 }
 
 type GUI struct {
 	ctx             context.Context
 	ch              chan IncomingData
-	buffConnections []float64
-	buffNodesTotal  []float64
-	buffNodesQueued []float64
-	buffNodesGood   []float64
-	buffNodesDead   []float64
-	buffLogs        []string
-	buffMsgs        []string
+	dataConnections *queue
+	dataNodesTotal  *queue
+	dataNodesQueued *queue
+	dataNodesGood   *queue
+	dataNodesDead   *queue
+	dataMsgsIn      *queue
+	dataMsgsOut     *queue
+	buffLogs        []logLine
+	buffMsgs        []logLine
+	// log lines added since the last render and dropped over the limit
+	linesTick    int32
+	linesDropped int64
+	// logs below are hidden in the panes, file logs are not affected
+	minLevel Level
+	// widgets are not updated while paused, data is still consumed
+	paused bool
+	// last address type breakdown
+	addrTotal stats.AddrCounts
+	addrGood  stats.AddrCounts
+	// last dead nodes by reason, dial errors and retries by error class
+	deadReasons map[string]int
+	dialErrors  map[string]int
+	dialRetries map[string]int
+	rejected    map[string]int
+	// good nodes by kind and the skipped pruned ones
+	goodKinds      stats.KindCounts
+	limitedSkipped int
+	// crawl pace
+	started   time.Time
+	rates     rates
+	latency   []int
+	reachable int
+	connLimit int
+	connMax   int
+	top       []stats.NodeSummary
+	conns     []stats.ConnInfo
+	msgTypes  []stats.MsgTypeCount
+	// of the process against MAX_GOROUTINES
+	goroutines int
+	connsDone  int64
+	// bytes of the crawl, the rate per second and the dials waiting for MAX_BANDWIDTH
+	bytesIn, bytesOut uint64
+	bytesRate         float64
+	bandwidthCapped   bool
+	// max and median advertised height, zero until known
+	heightMax    int32
+	heightMedian int32
+	nodesStale   int
+	nodesLying   int
+	// feefilter of the connected peers
+	fees      stats.FeeStats
+	clockSkew stats.SkewStats
+	connAvg   time.Duration
+	countries []stats.GeoCount
+	asns      []stats.GeoCount
+	// MONITOR peers, see updateMonitorTable
+	monitored    []stats.MonitorInfo
+	monitorDrops int
+	crawlPaused  bool
+	// LISTEN_ADDR peers
+	inbound stats.InboundCounts
+	banned  int
+	// previous message counters for the per second rates
+	msgsAt  time.Time
+	msgsIn  uint64
+	msgsOut uint64
+	// stop closes the gui right away, done is closed after the terminal is restored
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	// module gui, set by SetLogger
+	log logger.Interface
 }
 
-func New(ctx context.Context, ch chan IncomingData) *GUI {
+func New(ctx context.Context) *GUI {
 	g := GUI{
 		ctx:             ctx,
-		ch:              ch,
-		buffConnections: make([]float64, LEN_CONN),
-		buffNodesTotal:  make([]float64, LEN_NODES),
-		buffNodesQueued: make([]float64, LEN_NODES),
-		buffNodesGood:   make([]float64, LEN_NODES),
-		buffNodesDead:   make([]float64, LEN_NODES),
-		buffLogs:        make([]string, LEN_LOGS),
-		buffMsgs:        make([]string, LEN_LOGS),
+		connLimit:       cfg.ConnectionsLimit,
+		ch:              make(chan IncomingData, cfg.GUIChSize),
+		dataConnections: newQueue(cfg.ChartHistory),
+		dataNodesTotal:  newQueue(cfg.ChartHistory),
+		dataNodesQueued: newQueue(cfg.ChartHistory),
+		dataNodesGood:   newQueue(cfg.ChartHistory),
+		dataNodesDead:   newQueue(cfg.ChartHistory),
+		dataMsgsIn:      newQueue(cfg.ChartHistory),
+		dataMsgsOut:     newQueue(cfg.ChartHistory),
+		buffLogs:        make([]logLine, cfg.LogLines),
+		buffMsgs:        make([]logLine, cfg.LogLines),
+		minLevel:        LevelDebug,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
 	}
 	return &g
 }
@@ -65,46 +133,118 @@ func (g *GUI) listner() {
 		case <-g.ctx.Done():
 			return
 		case d := <-g.ch:
-			g.buffConnections = buffAddFloat(g.buffConnections, float64(d.Connections))
-			g.buffNodesTotal = buffAddFloat(g.buffNodesTotal, float64(d.NodesTotal))
-			g.buffNodesQueued = buffAddFloat(g.buffNodesQueued, float64(d.NodesQueued))
-			g.buffNodesGood = buffAddFloat(g.buffNodesGood, float64(d.NodesGood))
-			g.buffNodesDead = buffAddFloat(g.buffNodesDead, float64(d.NodesDead))
-			g.buffLogs = buffAddString(g.buffLogs, d.Log)
-			g.buffMsgs = buffAddString(g.buffMsgs, d.Msg)
+			// logs come without stats, stats come without logs
+			if d.Log != "" || d.Msg != "" {
+				now := time.Now()
+				if buffRepeat(g.buffLogs, d.Level, d.Log, now) || buffRepeat(g.buffMsgs, d.Level, d.Msg, now) {
+					continue
+				}
+				// limit the lines per render, addr storms make the panes unreadable
+				if atomic.AddInt32(&g.linesTick, 1) > int32(cfg.LogRate) {
+					atomic.AddInt64(&g.linesDropped, 1)
+					continue
+				}
+				g.buffLogs = buffAddLine(g.buffLogs, d.Level, d.Log, now)
+				g.buffMsgs = buffAddLine(g.buffMsgs, d.Level, d.Msg, now)
+				continue
+			}
+			// all the series are updated together, the zeros are skipped
+			g.dataConnections.PushNonZero(float64(d.Connections))
+			g.dataNodesTotal.PushNonZero(float64(d.NodesTotal))
+			g.dataNodesQueued.PushNonZero(float64(d.NodesQueued))
+			g.dataNodesGood.PushNonZero(float64(d.NodesGood))
+			g.dataNodesDead.PushNonZero(float64(d.NodesDead))
+			g.addrTotal = d.AddrTotal
+			g.addrGood = d.AddrGood
+			g.deadReasons = d.DeadReasons
+			g.dialErrors = d.DialErrors
+			g.dialRetries = d.DialRetries
+			g.rejected = d.Rejected
+			g.goodKinds = d.GoodKinds
+			g.limitedSkipped = d.NodesLimitedSkipped
+			g.latency = d.Latency
+			g.reachable = d.NodesReachable
+			if d.ConnectionsLimit > 0 {
+				g.connLimit = d.ConnectionsLimit
+				g.connMax = d.ConnectionsMax
+			}
+			g.top = d.TopNodes
+			g.conns = d.ActiveConns
+			g.msgTypes = stats.MsgTypeCounts(d.MsgTypes)
+			g.goroutines = d.Goroutines
+			g.bytesIn, g.bytesOut, g.bytesRate, g.bandwidthCapped = d.BytesIn, d.BytesOut, d.BytesRate, d.BandwidthCapped
+			g.connsDone = d.ConnsDone
+			g.heightMax, g.heightMedian = d.HeightMax, d.HeightMedian
+			g.nodesStale, g.nodesLying = d.NodesStale, d.NodesLying
+			g.fees = d.FeeFilter
+			g.clockSkew = d.ClockSkew
+			g.connAvg = d.ConnAvg
+			g.countries = d.TopCountries
+			g.asns = d.TopASNs
+			g.monitored, g.monitorDrops, g.crawlPaused = d.Monitored, d.MonitorDrops, d.CrawlPaused
+			g.inbound, g.banned = d.Inbound, d.Banned
+			g.pushMsgRates(d)
+			// new crawl cycle in the daemon mode
+			if !d.Started.Equal(g.started) {
+				g.rates = rates{}
+			}
+			g.started = d.Started
+			g.rates.add(d)
 		}
 	}
 }
 
-func buffAddFloat(buff []float64, v float64) []float64 {
-	if v == 0 {
-		return buff
+// Push implements stats.Sink, the stats are dropped while the gui is behind,
+// the next tick has newer ones. the client never waits for the render
+func (g *GUI) Push(s stats.Stats) {
+	select {
+	case g.ch <- IncomingData{Stats: s}:
+	default:
+		metrics.ChannelFull(metrics.ChanGUI)
 	}
-	buff = append(buff, v)
-	buff = buff[1:]
-	return buff
 }
 
-func buffAddString(buff []string, v string) []string {
-	if v == "" {
-		return buff
+// PushLog implements logger.Sink, peer logs go to the messages pane
+func (g *GUI) PushLog(level logger.Level, line string, peer bool) {
+	d := IncomingData{Level: levelFromLogger(level)}
+	if peer {
+		d.Msg = line
+	} else {
+		d.Log = line
+	}
+	select {
+	case g.ch <- d:
+	default:
+		metrics.ChannelFull(metrics.ChanGUI)
 	}
-	buff = append(buff, v)
-	buff = buff[1:]
-	return buff
 }
 
+// Stop closes the gui without waiting for a key, see Done
 func (g *GUI) Stop() {
-	tui.Close()
+	g.stopOnce.Do(func() { close(g.stop) })
 }
 
-func (g *GUI) Start() {
+// SetLogger is called before Start, the gui is a sink of the logger
+// so it is created first
+func (g *GUI) SetLogger(log logger.Interface) {
+	g.log = log.With(logger.FieldModule, "gui")
+}
+
+// Done is closed when the gui is closed and the terminal is restored
+func (g *GUI) Done() <-chan struct{} {
+	return g.done
+}
+
+// Start blocks until quit, cancel is called on the quit keys to stop the crawler.
+// When the context is done the last state stays on the screen until any key.
+func (g *GUI) Start(cancel context.CancelFunc) {
+	defer close(g.done)
 	if err := tui.Init(); err != nil {
-		log.Fatalf("failed to initialize termui: %v", err)
+		g.log.Fatal(fmt.Errorf("failed to initialize termui: %v", err))
 	}
 	defer func() {
 		tui.Close()
-		log.Println("ready to exit")
+		g.log.Info("ready to exit")
 	}()
 
 	// start incoming data listner
@@ -121,49 +261,69 @@ func (g *GUI) Start() {
 
 	// CONNECTIONS
 	chartConn := widgets.NewSparkline()
-	// max connections
-	chartConn.MaxVal = float64(cfg.ConnectionsLimit)
+	// scaled on every tick, see updateConnSparkline
+	chartConn.MaxVal = float64(cfg.ConnectionsLimit) * 1.1
 	chartConn.Data = []float64{0}
 	chartConn.LineColor = tui.ColorMagenta
 	chartConn.TitleStyle.Fg = tui.ColorWhite
 	chartConnWrap := widgets.NewSparklineGroup(chartConn)
 	chartConnWrap.Title = "Connections"
 
+	// MESSAGES
+	chartMsgsIn := widgets.NewSparkline()
+	chartMsgsIn.Data = []float64{0}
+	chartMsgsIn.LineColor = tui.ColorGreen
+	chartMsgsIn.TitleStyle.Fg = tui.ColorWhite
+	chartMsgsOut := widgets.NewSparkline()
+	chartMsgsOut.Data = []float64{0}
+	chartMsgsOut.LineColor = tui.ColorBlue
+	chartMsgsOut.TitleStyle.Fg = tui.ColorWhite
+	chartMsgsWrap := widgets.NewSparklineGroup(chartMsgsIn, chartMsgsOut)
+	chartMsgsWrap.Title = "Msg/s"
+
 	// STATS
 	stats := widgets.NewTable()
 	stats.RowSeparator = false
 	stats.FillRow = false
-	stats.RowStyles[1] = tui.NewStyle(tui.ColorGreen)
-	stats.RowStyles[2] = tui.NewStyle(tui.ColorRed)
-	stats.RowStyles[3] = tui.NewStyle(tui.ColorYellow)
-	stats.RowStyles[4] = tui.NewStyle(tui.ColorMagenta)
+	stats.RowStyles[1] = tui.NewStyle(tui.ColorCyan)
+	stats.RowStyles[2] = tui.NewStyle(tui.ColorGreen)
+	stats.RowStyles[3] = tui.NewStyle(tui.ColorRed)
+	stats.RowStyles[4] = tui.NewStyle(tui.ColorYellow)
+	stats.RowStyles[5] = tui.NewStyle(tui.ColorMagenta)
 	stats.Rows = g.getInfo()
 	stats.TextStyle = tui.NewStyle(tui.ColorWhite)
+	stats.Title = "Stats"
 	tui.Render(stats)
 
-	// TOTAL
-	chartNodesTotal := widgets.NewPlot()
-	chartNodesTotal.ShowAxes = false
-	chartNodesTotal.Data = [][]float64{make([]float64, LEN_NODES)}
-	chartNodesTotal.LineColors = []tui.Color{tui.ColorWhite} // force the collor, bug
-
-	// QUEUE
-	chartNodesQueue := widgets.NewPlot()
-	chartNodesQueue.ShowAxes = false
-	chartNodesQueue.Data = [][]float64{make([]float64, LEN_NODES)}
-	chartNodesQueue.LineColors = []tui.Color{tui.ColorYellow} // force the collor, bug
-
-	// good
-	chartNodesGood := widgets.NewPlot()
-	chartNodesGood.ShowAxes = false
-	chartNodesGood.Data = [][]float64{make([]float64, LEN_NODES)}
-	chartNodesGood.LineColors = []tui.Color{tui.ColorGreen} // force the collor, bug
-
-	// dead
-	chartNodesDead := widgets.NewPlot()
-	chartNodesDead.ShowAxes = false
-	chartNodesDead.Data = [][]float64{make([]float64, LEN_NODES)}
-	chartNodesDead.LineColors = []tui.Color{tui.ColorRed} // force the collor, bug
+	// NODES
+	chartNodesTotal := newNodesPlot(tui.ColorWhite)
+	chartNodesQueue := newNodesPlot(tui.ColorYellow)
+	chartNodesGood := newNodesPlot(tui.ColorGreen)
+	chartNodesDead := newNodesPlot(tui.ColorRed)
+
+	// LATENCY
+	chartLatency := newLatencyChart()
+
+	// TOP NODES
+	top := newTopTable()
+	// MONITOR
+	// the kept peers are more interesting than the top nodes in the monitor mode
+	monitor := newMonitorTable()
+	var topWidget tui.Drawable = top
+	if cfg.Monitor > 0 {
+		topWidget = monitor
+	}
+	// TOP COUNTRIES AND ASNS
+	// hidden without the geo file, the top nodes take the whole row
+	geoTable := newGeoTable()
+	withGeo := cfg.GeoFile != "" || len(cfg.GeoMMDB) > 0
+	tables := tui.NewRow(0.45, topWidget)
+	if withGeo {
+		tables = tui.NewRow(0.45,
+			tui.NewCol(0.5, topWidget),
+			tui.NewCol(0.5, geoTable),
+		)
+	}
 
 	// LOGS
 	log := widgets.NewParagraph()
@@ -192,9 +352,16 @@ func (g *GUI) Start() {
 		),
 		// logs
 		tui.NewRow(0.65,
-			tui.NewCol(0.45, log),
-			tui.NewCol(0.45, msg),
+			tui.NewCol(0.6,
+				tui.NewRow(0.55,
+					tui.NewCol(0.5, log),
+					tui.NewCol(0.5, msg),
+				),
+				tables,
+			),
+			tui.NewCol(0.2, chartLatency),
 			tui.NewCol(0.1, chartConnWrap),
+			tui.NewCol(0.1, chartMsgsWrap),
 		),
 		// progress
 		tui.NewRow(0.1,
@@ -208,38 +375,133 @@ func (g *GUI) Start() {
 		go g.sendDebugData()
 	}
 
+	// KEYS
+	// help overlay is shown on top of the grid until any key is pressed
+	var help *widgets.Paragraph
+	// active connections overlay, closed by any key like the help
+	var connsView *widgets.Table
+	// received messages by type overlay, same
+	var msgTypesView *widgets.Table
+	var bindings []keyBinding
+	bindings = []keyBinding{
+		{keys: []string{"q", "<C-c>"}, desc: "quit", action: func() bool {
+			cancel()
+			return true
+		}},
+		{keys: []string{"p"}, desc: "pause/resume updates", action: func() bool {
+			// freeze the widgets, resume jumps to the current data on the next tick
+			g.paused = !g.paused
+			if g.paused {
+				stats.Title = "Stats [PAUSED]"
+			} else {
+				stats.Title = "Stats"
+			}
+			tui.Render(stats)
+			return false
+		}},
+		{keys: []string{"l"}, desc: "cycle minimum log level", action: func() bool {
+			// cycle minimum level shown in the logs panes
+			g.minLevel = g.minLevel.next()
+			return false
+		}},
+		{keys: []string{"c"}, desc: "show active connections", action: func() bool {
+			w, h := tui.TerminalDimensions()
+			connsView = newConnsView()
+			updateConnsView(connsView, g.conns, w, h)
+			tui.Render(connsView)
+			return false
+		}},
+		{keys: []string{"m"}, desc: "show received messages by type", action: func() bool {
+			w, h := tui.TerminalDimensions()
+			msgTypesView = newMsgTypesView()
+			updateMsgTypesView(msgTypesView, g.msgTypes, w, h)
+			tui.Render(msgTypesView)
+			return false
+		}},
+		{keys: []string{"?"}, desc: "show this help", action: func() bool {
+			w, h := tui.TerminalDimensions()
+			help = newHelp(bindings, w, h)
+			tui.Render(help)
+			return false
+		}},
+	}
+
 	// UPDATER
 	uiEvents := tui.PollEvents()
-	ticker := time.NewTicker(200 * time.Millisecond)
+	// render rate only, the client sends the data at its own pace
+	ticker := time.NewTicker(time.Duration(cfg.GUIRefreshMs) * time.Millisecond)
 	for {
 		select {
+		case <-g.stop:
+			return
 		case <-g.ctx.Done():
+			// crawler is done, keep the last state until any key
+			progress.Title = "Finished"
+			progress.Label = "press any key to exit"
+			tui.Render(grid)
+			g.waitKey(uiEvents, grid)
 			return
 		case e := <-uiEvents:
-			switch e.ID {
-			case "q", "<C-c>":
-				return
-			case "<Resize>":
+			if e.ID == "<Resize>" {
 				payload := e.Payload.(tui.Resize)
 				grid.SetRect(0, 0, payload.Width, payload.Height)
 				tui.Clear()
 				tui.Render(grid)
+				if help != nil {
+					help = newHelp(bindings, payload.Width, payload.Height)
+					tui.Render(help)
+				}
+				if connsView != nil {
+					updateConnsView(connsView, g.conns, payload.Width, payload.Height)
+					tui.Render(connsView)
+				}
+				if msgTypesView != nil {
+					updateMsgTypesView(msgTypesView, g.msgTypes, payload.Width, payload.Height)
+					tui.Render(msgTypesView)
+				}
+				continue
+			}
+			if e.Type != tui.KeyboardEvent {
+				continue
+			}
+			// any key closes the help and the overlays
+			if help != nil || connsView != nil || msgTypesView != nil {
+				help = nil
+				connsView = nil
+				msgTypesView = nil
+				tui.Clear()
+				tui.Render(grid)
+				continue
+			}
+			if b := findBinding(bindings, e.ID); b != nil && b.action() {
+				return
 			}
 		case <-ticker.C:
+			atomic.StoreInt32(&g.linesTick, 0)
+			if g.paused {
+				continue
+			}
 
 			// update logs
-			log.Text = strings.Join(g.buffLogs, "\n")
-			msg.Text = strings.Join(g.buffMsgs, "\n")
+			log.Text = renderLines(g.buffLogs, g.minLevel)
+			msg.Text = renderLines(g.buffMsgs, g.minLevel)
+			dropped := atomic.LoadInt64(&g.linesDropped)
+			log.Title = paneTitle("Logs", g.minLevel, dropped)
+			msg.Title = paneTitle("Messages", g.minLevel, 0)
 
 			// connections update
-			chartConnWrap.Sparklines[0].Data = g.buffConnections
+			updateConnSparkline(chartConn, g.dataConnections.Tail(chartConnWrap.Inner.Dx()), g.connLimit)
+
+			// messages update
+			updateSparkline(chartMsgsIn, g.dataMsgsIn.Tail(chartMsgsWrap.Inner.Dx()), "In")
+			updateSparkline(chartMsgsOut, g.dataMsgsOut.Tail(chartMsgsWrap.Inner.Dx()), "Out")
 
 			// calc progress
-			conn := g.buffConnections[LEN_CONN-1]
-			total := g.buffNodesTotal[LEN_NODES-1]
-			queued := g.buffNodesQueued[LEN_NODES-1]
-			good := g.buffNodesGood[LEN_NODES-1]
-			dead := g.buffNodesDead[LEN_NODES-1]
+			conn := g.dataConnections.Last()
+			total := g.dataNodesTotal.Last()
+			queued := g.dataNodesQueued.Last()
+			good := g.dataNodesGood.Last()
+			dead := g.dataNodesDead.Last()
 			left := good + dead
 			if left > 0 {
 				prog := float64(left) / float64(total) * 100
@@ -252,10 +514,20 @@ func (g *GUI) Start() {
 			}
 
 			// update charts
-			chartNodesTotal.Data[0] = g.buffNodesTotal
-			chartNodesQueue.Data[0] = g.buffNodesQueued
-			chartNodesGood.Data[0] = g.buffNodesGood
-			chartNodesDead.Data[0] = g.buffNodesDead
+			updateLatencyChart(chartLatency, g.latency)
+			if cfg.Monitor > 0 {
+				updateMonitorTable(monitor, g.monitored, g.monitorDrops, g.crawlPaused)
+			} else {
+				updateTopTable(top, g.top)
+			}
+			if withGeo {
+				updateGeoTable(geoTable, g.countries, g.asns)
+			}
+			// clamped to the widget width, extra points are drawn outside
+			chartNodesTotal.Data[0] = g.dataNodesTotal.Tail(chartNodesTotal.Inner.Dx())
+			chartNodesQueue.Data[0] = g.dataNodesQueued.Tail(chartNodesQueue.Inner.Dx())
+			chartNodesGood.Data[0] = g.dataNodesGood.Tail(chartNodesGood.Inner.Dx())
+			chartNodesDead.Data[0] = g.dataNodesDead.Tail(chartNodesDead.Inner.Dx())
 
 			//  update titles
 			updateTitlePlot(chartNodesTotal, total, "Total")
@@ -269,13 +541,12 @@ func (g *GUI) Start() {
 
 			// debug info to logs
 			if os.Getenv("GUI_MEM") == "1" {
-				text := fmt.Sprintf("buffNodesTotal: len %d, cap %d\n", len(g.buffNodesTotal), cap(g.buffNodesTotal))
-				text += fmt.Sprintf("buffNodesQueued: len %d, cap %d\n", len(g.buffNodesQueued), cap(g.buffNodesQueued))
-				text += fmt.Sprintf("buffNodesGood: len %d, cap %d\n", len(g.buffNodesGood), cap(g.buffNodesGood))
-				text += fmt.Sprintf("buffNodesDead: len %d, cap %d\n", len(g.buffNodesDead), cap(g.buffNodesDead))
-				text += fmt.Sprintf("buffConnections: len %d, cap %d\n", len(g.buffConnections), cap(g.buffConnections))
+				text := fmt.Sprintf("dataNodesTotal: %s\n", g.dataNodesTotal)
+				text += fmt.Sprintf("dataNodesQueued: %s\n", g.dataNodesQueued)
+				text += fmt.Sprintf("dataNodesGood: %s\n", g.dataNodesGood)
+				text += fmt.Sprintf("dataNodesDead: %s\n", g.dataNodesDead)
+				text += fmt.Sprintf("dataConnections: %s\n", g.dataConnections)
 
-				// msg += fmt.Sprintf("dataNodesTotalLL: %d\n", g.dataNodesTotalList.Len())
 				// report G count and memory used
 				var m runtime.MemStats
 				runtime.ReadMemStats(&m)
@@ -283,18 +554,204 @@ func (g *GUI) Start() {
 				msg.Text = text
 			}
 			tui.Render(grid)
+			if help != nil {
+				tui.Render(help)
+			}
+			if connsView != nil {
+				w, h := tui.TerminalDimensions()
+				updateConnsView(connsView, g.conns, w, h)
+				tui.Render(connsView)
+			}
+			if msgTypesView != nil {
+				w, h := tui.TerminalDimensions()
+				updateMsgTypesView(msgTypesView, g.msgTypes, w, h)
+				tui.Render(msgTypesView)
+			}
 		}
 	}
 }
 
 func (g *GUI) getInfo() [][]string {
-	return [][]string{
-		{"Total nodes", fmt.Sprintf("%.0f", g.buffNodesTotal[LEN_NODES-1])},
-		{"Good nodes", fmt.Sprintf("%.0f", g.buffNodesGood[LEN_NODES-1])},
-		{"Dead nodes", fmt.Sprintf("%.0f", g.buffNodesDead[LEN_NODES-1])},
-		{"Queue", fmt.Sprintf("%.0f", g.buffNodesQueued[LEN_NODES-1])},
-		{"Connections", fmt.Sprintf("%.0f/%d", g.buffConnections[LEN_CONN-1], cfg.ConnectionsLimit)},
+	goodRate, goodOk := g.rates.goodPerMin()
+	drainRate, drainOk := g.rates.drainPerMin()
+	eta, etaOk := g.rates.eta(g.started)
+	info := [][]string{
+		{"Discovered", fmt.Sprintf("%.0f", g.dataNodesTotal.Last())},
+		{"Reachable", fmt.Sprintf("%d", g.reachable)},
+		{"Good nodes", fmt.Sprintf("%.0f", g.dataNodesGood.Last())},
+		{"Dead nodes", fmt.Sprintf("%.0f", g.dataNodesDead.Last())},
+		// closed after the version by MIN_PROTOCOL_VERSION and REQUIRED_SERVICES
+		{"Rejected", fmt.Sprintf("%d old, %d svc", g.rejected["old protocol"], g.rejected["missing services"])},
+		{"Queue", fmt.Sprintf("%.0f", g.dataNodesQueued.Last())},
+		{"Connections", connections(g.dataConnections.Last(), g.connLimit, g.connMax)},
+		{"Goroutines", goroutines(g.goroutines)},
+		// in/out, rate per second against MAX_BANDWIDTH
+		{"Traffic", fmt.Sprintf("%s/%s", formatBytes(g.bytesIn), formatBytes(g.bytesOut))},
+		{"Rate", trafficRate(g.bytesRate, g.bandwidthCapped)},
+		// good/total by address type
+		{"IPv4", fmt.Sprintf("%d/%d", g.addrGood.IPv4, g.addrTotal.IPv4)},
+		{"IPv6", fmt.Sprintf("%d/%d", g.addrGood.IPv6, g.addrTotal.IPv6)},
+		{"Onion", fmt.Sprintf("%d/%d", g.addrGood.Onion, g.addrTotal.Onion)},
+		// advertised by the good nodes, a max far above the median is a lying node
+		{"Height", heights(g.heightMax, g.heightMedian, g.dataNodesGood.Last() > 0)},
+		// behind the median by STALE_BLOCKS, far above it
+		{"Stale/lying", fmt.Sprintf("%d/%d", g.nodesStale, g.nodesLying)},
+		// min/median/p90 sat/kvB of the connected peers, a rough view of the relay fees
+		{"Feefilter", feeFilter(g.fees)},
+		// median of the good nodes, over 70 minutes off
+		{"Clock skew", clockSkew(g.clockSkew)},
+		// good by the services, pruned are limited
+		{"Full/pruned", kinds(g.goodKinds, g.limitedSkipped)},
+		// dead nodes by reason, network level first (dial retries)
+		{"Refused", fmt.Sprintf("%d", g.deadReasons["refused"])},
+		{"Timeout", fmt.Sprintf("%d (%d)", g.deadReasons["timeout"], g.dialRetries["timeout"])},
+		{"Unroutable", fmt.Sprintf("%d", g.deadReasons["unroutable"])},
+		{"Reset", fmt.Sprintf("%d", g.deadReasons["reset"])},
+		{"Reject", fmt.Sprintf("%d", g.deadReasons["reject"])},
+		{"No handshake", fmt.Sprintf("%d", g.deadReasons["handshake incomplete"])},
+		// misbehaving and BAN_FILE hosts
+		{"Banned", fmt.Sprintf("%d", g.banned)},
+		// pace
+		{"Elapsed", formatDuration(time.Since(g.started), !g.started.IsZero())},
+		{"Good rate", formatRate(goodRate, goodOk)},
+		{"Drain rate", formatRate(drainRate, drainOk)},
+		// average connector time per node, lower with FAST_CRAWL
+		{"Slot time", slotTime(g.connAvg, g.connsDone)},
+		{"ETA", formatDuration(eta, etaOk)},
+	}
+	// active/accepted, not in the connections
+	if cfg.ListenAddr != "" {
+		info = append(info, []string{"Inbound", fmt.Sprintf("%d/%d", g.inbound.Active, g.inbound.Accepted)})
+	}
+	return info
+}
+
+// line chart without axes, filled on every tick from the series queue
+func newNodesPlot(color tui.Color) *widgets.Plot {
+	chart := widgets.NewPlot()
+	chart.ShowAxes = false
+	chart.Data = [][]float64{make([]float64, 2)}
+	chart.LineColors = []tui.Color{color} // force the collor, bug
+	return chart
+}
+
+// wait for any key, stop or the terminal resize to render the grid again
+func (g *GUI) waitKey(uiEvents <-chan tui.Event, grid *tui.Grid) {
+	for {
+		select {
+		case <-g.stop:
+			return
+		case e := <-uiEvents:
+			if e.ID == "<Resize>" {
+				payload := e.Payload.(tui.Resize)
+				grid.SetRect(0, 0, payload.Width, payload.Height)
+				tui.Clear()
+				tui.Render(grid)
+				continue
+			}
+			if e.Type == tui.KeyboardEvent {
+				return
+			}
+		}
+	}
+}
+
+// per second message rates from the counters
+func (g *GUI) pushMsgRates(d IncomingData) {
+	now := time.Now()
+	if !g.msgsAt.IsZero() && d.MsgsIn >= g.msgsIn && d.MsgsOut >= g.msgsOut {
+		secs := now.Sub(g.msgsAt).Seconds()
+		if secs > 0 {
+			g.dataMsgsIn.Push(float64(d.MsgsIn-g.msgsIn) / secs)
+			g.dataMsgsOut.Push(float64(d.MsgsOut-g.msgsOut) / secs)
+		}
+	}
+	g.msgsAt = now
+	g.msgsIn = d.MsgsIn
+	g.msgsOut = d.MsgsOut
+}
+
+// scale to the visible window and show the current value in the title
+func updateSparkline(line *widgets.Sparkline, data []float64, title string) {
+	max := 1.0
+	for _, v := range data {
+		if v > max {
+			max = v
+		}
+	}
+	line.Data = data
+	line.MaxVal = max * 1.1
+	line.Title = fmt.Sprintf("%s: %.0f", title, data[len(data)-1])
+}
+
+// the max is shown only while the adaptive limit is lower
+func connections(active float64, limit, max int) string {
+	if max > limit {
+		return fmt.Sprintf("%.0f/%d of %d", active, limit, max)
+	}
+	return fmt.Sprintf("%.0f/%d", active, limit)
+}
+
+// against the cap, the dials wait at it
+func goroutines(n int) string {
+	if cfg.MaxGoroutines == 0 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%d/%d", n, cfg.MaxGoroutines)
+}
+
+// bytes per second, the dials wait over MAX_BANDWIDTH
+func trafficRate(rate float64, capped bool) string {
+	s := formatBytes(uint64(rate)) + "/s"
+	if cfg.MaxBandwidthKBps > 0 {
+		s += fmt.Sprintf(" of %dK/s", cfg.MaxBandwidthKBps)
+	}
+	if capped {
+		s += " capped"
+	}
+	return s
+}
+
+// full/limited good nodes, skipped pruned nodes are not good with SKIP_LIMITED
+func kinds(k stats.KindCounts, skipped int) string {
+	if skipped > 0 {
+		return fmt.Sprintf("%d/%d (%d skipped)", k.Full, k.Limited, skipped)
+	}
+	return fmt.Sprintf("%d/%d", k.Full, k.Limited)
+}
+
+func heights(max, median int32, known bool) string {
+	if !known {
+		return "—"
+	}
+	return fmt.Sprintf("%d max, %d median", max, median)
+}
+
+func feeFilter(f stats.FeeStats) string {
+	if f.Peers == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%d/%d/%d (%d)", f.Min, f.Median, f.P90, f.Peers)
+}
+
+func clockSkew(s stats.SkewStats) string {
+	if s.Peers == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%s (%d off)", s.Median.Round(time.Second), s.Off)
+}
+
+// scale to the connections limit or the visible max if the limit was raised
+func updateConnSparkline(line *widgets.Sparkline, data []float64, limit int) {
+	max := float64(limit)
+	for _, v := range data {
+		if v > max {
+			max = v
+		}
 	}
+	line.Data = data
+	line.MaxVal = max * 1.1
+	line.Title = fmt.Sprintf("max %.0f", max)
 }
 
 // update titles
@@ -327,14 +784,29 @@ func (g *GUI) sendDebugData() {
 			rQueued := rand.Intn(cfg.ConnectionsLimit)
 			rGood := rand.Intn(cfg.ConnectionsLimit)
 			rDead := rand.Intn(cfg.ConnectionsLimit)
+			g.Push(stats.Stats{
+				Connections:  rConn,
+				NodesTotal:   rTotal,
+				NodesQueued:  rQueued,
+				NodesGood:    rGood,
+				NodesDead:    int32(rDead),
+				HeightMax:    810000,
+				HeightMedian: 809998,
+				TopNodes: stats.TopNodes([]stats.NodeSummary{
+					{Endpoint: "1.2.3.4:8333", RTT: time.Duration(rand.Intn(500)) * time.Millisecond, UserAgent: "/Satoshi:25.0.0/", Height: 810000},
+					{Endpoint: "vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd.onion:8333", RTT: 320 * time.Millisecond, UserAgent: "/Satoshi:24.0.1/", Height: 809998},
+				}),
+				TopCountries: stats.TopGeo(map[string]int{"US": rGood, "DE": rGood / 2, "NL": rGood / 3}),
+				ActiveConns: []stats.ConnInfo{
+					{Endpoint: "1.2.3.4:8333", State: "established", BytesIn: uint64(rand.Intn(100000)), BytesOut: 420, Age: time.Duration(cnt) * time.Second},
+					{Endpoint: "[2001:db8::1]:8333", State: "handshake", BytesIn: 130, BytesOut: 170, Age: time.Second},
+				},
+				TopASNs:  stats.TopGeo(map[string]int{"AS24940 HETZNER-AS": rGood / 2, "AS16509 AMAZON-02": rGood / 4}),
+				MsgTypes: map[string]uint64{"version": uint64(rTotal), "verack": uint64(rGood), "inv": uint64(cnt * 10), "addr": uint64(cnt)},
+			})
 			g.ch <- IncomingData{
-				Connections: rConn,
-				NodesTotal:  rTotal,
-				NodesQueued: rQueued,
-				NodesGood:   rGood,
-				NodesDead:   int32(rDead),
-				Log:         fmt.Sprintf("test log %d", cnt),
-				Msg:         fmt.Sprintf("test msg %d", cnt),
+				Log: fmt.Sprintf("test log %d", cnt),
+				Msg: fmt.Sprintf("test msg %d", cnt),
 			}
 		}
 	}
@@ -0,0 +1,153 @@
+package gui
+
+import (
+	"context"
+	"image"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+	tui "github.com/gizak/termui/v3"
+)
+
+// update runs the listener until all the stats are taken,
+// the gui fields are safe to read after
+func update(t *testing.T, g *GUI, data ...stats.Stats) {
+	t.Helper()
+	if len(data) > cap(g.ch) {
+		t.Fatalf("%d stats over the channel size %d", len(data), cap(g.ch))
+	}
+	for _, s := range data {
+		g.Push(s)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.ctx = ctx
+	done := make(chan struct{})
+	go func() {
+		g.listner()
+		close(done)
+	}()
+	// the last one is handled before the listener sees the cancel
+	for len(g.ch) > 0 {
+		runtime.Gosched()
+	}
+	cancel()
+	<-done
+}
+
+// ticks of a growing crawl, total = queued + good + dead
+func crawlTicks(n int) []stats.Stats {
+	ticks := make([]stats.Stats, n)
+	for i := range ticks {
+		v := i + 1
+		ticks[i] = stats.Stats{
+			Connections: v,
+			NodesTotal:  10 * v,
+			NodesQueued: 10*v - 3*v,
+			NodesGood:   v,
+			NodesDead:   int32(2 * v),
+		}
+	}
+	return ticks
+}
+
+func TestUpdateSeries(t *testing.T) {
+	g := New(context.Background())
+	update(t, g, crawlTicks(5)...)
+
+	// oldest first, all the series in step
+	series := map[string]struct {
+		q    *queue
+		want []float64
+	}{
+		"connections": {g.dataConnections, []float64{3, 4, 5}},
+		"total":       {g.dataNodesTotal, []float64{30, 40, 50}},
+		"queued":      {g.dataNodesQueued, []float64{21, 28, 35}},
+		"good":        {g.dataNodesGood, []float64{3, 4, 5}},
+		"dead":        {g.dataNodesDead, []float64{6, 8, 10}},
+	}
+	for name, s := range series {
+		if got := s.q.Tail(3); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s tail = %v, want %v", name, got, s.want)
+		}
+		if got := s.q.Last(); got != s.want[2] {
+			t.Errorf("%s last = %v, want %v", name, got, s.want[2])
+		}
+	}
+	// the window keeps its size and starts with the zeros before the crawl
+	if got := len(g.dataNodesTotal.Tail(1000)); got != cfg.ChartHistory {
+		t.Errorf("window = %d, want %d", got, cfg.ChartHistory)
+	}
+	if got := g.dataNodesTotal.Tail(6); got[0] != 0 || got[1] != 10 {
+		t.Errorf("tail before the crawl = %v, want 0 then 10", got)
+	}
+}
+
+// an empty interval does not draw a drop to zero
+func TestUpdateSkipsZero(t *testing.T) {
+	g := New(context.Background())
+	ticks := crawlTicks(2)
+	ticks = append(ticks, stats.Stats{NodesTotal: 30, NodesQueued: 30})
+	update(t, g, ticks...)
+	if got := g.dataConnections.Tail(2); !reflect.DeepEqual(got, []float64{1, 2}) {
+		t.Errorf("connections = %v, want [1 2]", got)
+	}
+	if got := g.dataNodesTotal.Tail(3); !reflect.DeepEqual(got, []float64{10, 20, 30}) {
+		t.Errorf("total = %v, want [10 20 30]", got)
+	}
+}
+
+func TestUpdateLogsSkipSeries(t *testing.T) {
+	g := New(context.Background())
+	update(t, g, crawlTicks(2)...)
+	g.PushLog(logger.Info, "a log line", false)
+	g.PushLog(logger.Info, "a peer line", true)
+	update(t, g)
+	if got := g.dataNodesTotal.Tail(2); !reflect.DeepEqual(got, []float64{10, 20}) {
+		t.Errorf("total after the logs = %v, want [10 20]", got)
+	}
+}
+
+// render as in the ticker of Start, the plots are filled up to their width
+func renderNodesPlot(q *queue, title string) string {
+	chart := newNodesPlot(tui.ColorGreen)
+	chart.SetRect(0, 0, 14, 6)
+	chart.Data[0] = q.Tail(chart.Inner.Dx())
+	updateTitlePlot(chart, q.Last(), title)
+	buf := tui.NewBuffer(chart.GetRect())
+	chart.Draw(buf)
+	var b strings.Builder
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 14; x++ {
+			b.WriteRune(buf.GetCell(image.Pt(x, y)).Rune)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestRenderNodesPlot(t *testing.T) {
+	g := New(context.Background())
+	update(t, g, crawlTicks(20)...)
+	got := renderNodesPlot(g.dataNodesGood, "Good")
+	want := `┌─Good (20)──┐
+│          ⡰ │
+│    ⡰⠉⠉⠉⠉⠉⠁ │
+│⠉⠉⠉⠉⠁       │
+│            │
+└────────────┘
+`
+	if got != want {
+		t.Errorf("good nodes plot:\n%s\nwant:\n%s", got, want)
+	}
+	// a widget wider than the history gets the whole window
+	chart := newNodesPlot(tui.ColorGreen)
+	chart.SetRect(0, 0, 100, 6)
+	chart.Data[0] = g.dataNodesGood.Tail(chart.Inner.Dx())
+	if len(chart.Data[0]) != cfg.ChartHistory {
+		t.Errorf("points = %d, want %d", len(chart.Data[0]), cfg.ChartHistory)
+	}
+}
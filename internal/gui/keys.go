@@ -0,0 +1,61 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// keyBinding is a key handler, the help overlay is built from the same table.
+// action returns true to exit the gui.
+type keyBinding struct {
+	keys   []string
+	desc   string
+	action func() bool
+}
+
+func findBinding(bindings []keyBinding, id string) *keyBinding {
+	for i := range bindings {
+		for _, k := range bindings[i].keys {
+			if k == id {
+				return &bindings[i]
+			}
+		}
+	}
+	return nil
+}
+
+// help overlay centered in the terminal
+func newHelp(bindings []keyBinding, termWidth, termHeight int) *widgets.Paragraph {
+	lines := make([]string, 0, len(bindings)+2)
+	width := 0
+	for _, b := range bindings {
+		line := fmt.Sprintf(" %-10s %s", strings.Join(b.keys, ", "), b.desc)
+		lines = append(lines, line)
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	lines = append(lines, "", " press any key to close")
+
+	help := widgets.NewParagraph()
+	help.Title = "Keys"
+	help.Text = strings.Join(lines, "\n")
+	help.BorderStyle.Fg = tui.ColorCyan
+	placeHelp(help, width+4, len(lines)+2, termWidth, termHeight)
+	return help
+}
+
+func placeHelp(help *widgets.Paragraph, w, h, termWidth, termHeight int) {
+	if w > termWidth {
+		w = termWidth
+	}
+	if h > termHeight {
+		h = termHeight
+	}
+	x := (termWidth - w) / 2
+	y := (termHeight - h) / 2
+	help.SetRect(x, y, x+w, y+h)
+}
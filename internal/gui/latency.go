@@ -0,0 +1,37 @@
+package gui
+
+import (
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// labels for stats.LatencyBounds buckets
+var latencyLabels = []string{"<50", "<100", "<200", "<500", "500+"}
+
+func newLatencyChart() *widgets.BarChart {
+	chart := widgets.NewBarChart()
+	chart.Title = "Latency, ms"
+	chart.Labels = latencyLabels
+	chart.BarWidth = 4
+	chart.BarColors = []tui.Color{tui.ColorCyan}
+	chart.LabelStyles = []tui.Style{tui.NewStyle(tui.ColorWhite)}
+	chart.NumStyles = []tui.Style{tui.NewStyle(tui.ColorBlack)}
+	updateLatencyChart(chart, nil)
+	return chart
+}
+
+// empty histogram is rendered as zeros
+func updateLatencyChart(chart *widgets.BarChart, buckets []int) {
+	data := make([]float64, len(latencyLabels))
+	max := 1.0
+	for i := range data {
+		if i < len(buckets) {
+			data[i] = float64(buckets[i])
+		}
+		if data[i] > max {
+			max = data[i]
+		}
+	}
+	chart.Data = data
+	chart.MaxVal = max
+}
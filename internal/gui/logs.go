@@ -0,0 +1,144 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/logger"
+
+	tui "github.com/gizak/termui/v3"
+)
+
+type Level int
+
+// zero value is info so data without a level is shown as info
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+func levelFromLogger(l logger.Level) Level {
+	switch l {
+	case logger.Debug:
+		return LevelDebug
+	case logger.Warn:
+		return LevelWarn
+	case logger.Error:
+		return LevelError
+	case logger.Fatal:
+		return LevelFatal
+	default:
+		return LevelInfo
+	}
+}
+
+func init() {
+	// termui has no gray in the style parser
+	tui.StyleParserColorMap["gray"] = tui.Color(8)
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
+
+// next minimum level for the logs pane filter, wraps around after error
+func (l Level) next() Level {
+	if l >= LevelError {
+		return LevelDebug
+	}
+	return l + 1
+}
+
+func (l Level) color() string {
+	switch l {
+	case LevelDebug:
+		return "gray"
+	case LevelInfo:
+		return "white"
+	case LevelWarn:
+		return "yellow"
+	default:
+		return "red"
+	}
+}
+
+// same consecutive lines within this window are collapsed
+const repeatWindow = time.Second
+
+type logLine struct {
+	level Level
+	text  string
+	// times the line came in a row and when it came the last time
+	count int
+	at    time.Time
+}
+
+func buffAddLine(buff []logLine, level Level, v string, now time.Time) []logLine {
+	if v == "" {
+		return buff
+	}
+	buff = append(buff, logLine{level: level, text: v, count: 1, at: now})
+	buff = buff[1:]
+	return buff
+}
+
+// count the line as a repeat of the last one if it's the same within the window
+func buffRepeat(buff []logLine, level Level, v string, now time.Time) bool {
+	if v == "" || len(buff) == 0 {
+		return false
+	}
+	last := &buff[len(buff)-1]
+	if last.text != v || last.level != level || now.Sub(last.at) > repeatWindow {
+		return false
+	}
+	last.count++
+	last.at = now
+	return true
+}
+
+// pane title with the level filter and the dropped lines
+func paneTitle(name string, minLevel Level, dropped int64) string {
+	if minLevel > LevelDebug {
+		name += fmt.Sprintf(" (%s+)", minLevel)
+	}
+	if dropped > 0 {
+		name += fmt.Sprintf(", dropped %d", dropped)
+	}
+	return name
+}
+
+// render lines with at least minLevel, colored by level
+func renderLines(buff []logLine, minLevel Level) string {
+	lines := make([]string, 0, len(buff))
+	for _, l := range buff {
+		if l.text == "" || l.level < minLevel {
+			continue
+		}
+		text := l.text
+		if l.count > 1 {
+			text += fmt.Sprintf(" (x%d)", l.count)
+		}
+		// unbalanced brackets would break the style parser, render them as is
+		if strings.Count(text, "[") != strings.Count(text, "]") {
+			lines = append(lines, text)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("[%s](fg:%s)", text, l.level.color()))
+	}
+	return strings.Join(lines, "\n")
+}
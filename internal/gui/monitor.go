@@ -0,0 +1,63 @@
+package gui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/stats"
+
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+var monitorHeader = []string{"Endpoint", "Ping", "Uptime", "Fee", "Inv/m"}
+
+// takes the place of the top nodes with MONITOR
+func newMonitorTable() *widgets.Table {
+	table := widgets.NewTable()
+	table.Title = "Monitor"
+	table.RowSeparator = false
+	table.FillRow = false
+	table.TextStyle = tui.NewStyle(tui.ColorWhite)
+	table.RowStyles[0] = tui.NewStyle(tui.ColorCyan, tui.ColorClear, tui.ModifierBold)
+	table.Rows = [][]string{monitorHeader}
+	return table
+}
+
+// peers still connecting have no uptime yet
+func updateMonitorTable(table *widgets.Table, peers []stats.MonitorInfo, drops int, paused bool) {
+	fixed := []int{7, 10, 8, 7}
+	endpointW := table.Inner.Dx() - fixed[0] - fixed[1] - fixed[2] - fixed[3]
+	if endpointW < 2 {
+		endpointW = 2
+	}
+	table.ColumnWidths = []int{endpointW, fixed[0], fixed[1], fixed[2], fixed[3]}
+	table.Title = fmt.Sprintf("Monitor %d/%d, %d dropped", len(peers), cfg.Monitor, drops)
+	if paused {
+		table.Title += ", crawl paused"
+	}
+
+	rows := make([][]string, 0, len(peers)+1)
+	rows = append(rows, monitorHeader)
+	for _, p := range peers {
+		ping, uptime, fee, inv := "—", "connecting", "—", "—"
+		if p.RTT > 0 {
+			ping = fmt.Sprintf("%dms", p.RTT.Milliseconds())
+		}
+		if p.Uptime > 0 {
+			uptime = p.Uptime.Round(time.Second).String()
+			inv = fmt.Sprintf("%.0f", p.InvRate)
+		}
+		if p.FeeFilter >= 0 {
+			fee = fmt.Sprintf("%d", p.FeeFilter)
+		}
+		rows = append(rows, []string{
+			truncateMiddle(p.Endpoint, endpointW-1),
+			ping,
+			uptime,
+			fee,
+			inv,
+		})
+	}
+	table.Rows = rows
+}
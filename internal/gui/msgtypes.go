@@ -0,0 +1,52 @@
+package gui
+
+import (
+	"fmt"
+
+	"github.com/1F47E/go-btc-xray/internal/stats"
+
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+var msgTypesHeader = []string{"Command", "Received", "Share"}
+
+// received messages by type overlay, refreshed on every render until any key
+func newMsgTypesView() *widgets.Table {
+	table := widgets.NewTable()
+	table.RowSeparator = false
+	table.FillRow = false
+	table.BorderStyle.Fg = tui.ColorCyan
+	table.TextStyle = tui.NewStyle(tui.ColorWhite)
+	table.RowStyles[0] = tui.NewStyle(tui.ColorCyan, tui.ColorClear, tui.ModifierBold)
+	return table
+}
+
+// the commands are few, the overlay is sized to fit them
+func updateMsgTypesView(table *widgets.Table, counts []stats.MsgTypeCount, termWidth, termHeight int) {
+	w, h := 44, len(counts)+3
+	if w > termWidth {
+		w = termWidth
+	}
+	if h > termHeight {
+		h = termHeight
+	}
+	x, y := (termWidth-w)/2, (termHeight-h)/2
+	table.SetRect(x, y, x+w, y+h)
+	table.Title = "Messages by type, any key to close"
+
+	var total uint64
+	for _, c := range counts {
+		total += c.Count
+	}
+	rows := make([][]string, 0, len(counts)+1)
+	rows = append(rows, msgTypesHeader)
+	for _, c := range counts {
+		rows = append(rows, []string{
+			c.Command,
+			fmt.Sprintf("%d", c.Count),
+			fmt.Sprintf("%.1f%%", float64(c.Count)/float64(total)*100),
+		})
+	}
+	table.Rows = rows
+}
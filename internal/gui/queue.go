@@ -0,0 +1,48 @@
+package gui
+
+import "fmt"
+
+// queue is a fixed size window of the last values for a chart, oldest first
+type queue struct {
+	data []float64
+}
+
+func newQueue(size int) *queue {
+	return &queue{data: make([]float64, size)}
+}
+
+// Push drops the oldest value and adds the new one to the end
+func (q *queue) Push(v float64) {
+	copy(q.data, q.data[1:])
+	q.data[len(q.data)-1] = v
+}
+
+// PushNonZero skips the zeros, an empty interval keeps the line
+// at the last value instead of a drop to zero
+func (q *queue) PushNonZero(v float64) {
+	if v == 0 {
+		return
+	}
+	q.Push(v)
+}
+
+func (q *queue) Last() float64 {
+	return q.data[len(q.data)-1]
+}
+
+// Tail returns a copy of the last n values, at least 2 for the line charts
+func (q *queue) Tail(n int) []float64 {
+	if n < 2 {
+		n = 2
+	}
+	if n > len(q.data) {
+		n = len(q.data)
+	}
+	ret := make([]float64, n)
+	copy(ret, q.data[len(q.data)-n:])
+	return ret
+}
+
+func (q *queue) String() string {
+	return fmt.Sprintf("len %d, cap %d", len(q.data), cap(q.data))
+}
@@ -0,0 +1,110 @@
+package gui
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	rateWindow     = 5 * time.Minute
+	rateMinSamples = 30 * time.Second
+)
+
+type rateSample struct {
+	at     time.Time
+	good   int
+	done   int // good + dead
+	queued int
+}
+
+// rates keeps the last samples of the stats to calculate the crawl pace
+type rates struct {
+	samples []rateSample
+}
+
+func (r *rates) add(d IncomingData) {
+	now := time.Now()
+	r.samples = append(r.samples, rateSample{
+		at:     now,
+		good:   d.NodesGood,
+		done:   d.NodesGood + int(d.NodesDead),
+		queued: d.NodesQueued,
+	})
+	// drop samples older than the window
+	i := 0
+	for i < len(r.samples)-1 && now.Sub(r.samples[i].at) > rateWindow {
+		i++
+	}
+	r.samples = r.samples[i:]
+}
+
+// first and last samples, false if the window is too short for a rate
+func (r *rates) span() (rateSample, rateSample, bool) {
+	if len(r.samples) < 2 {
+		return rateSample{}, rateSample{}, false
+	}
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	if last.at.Sub(first.at) < rateMinSamples {
+		return rateSample{}, rateSample{}, false
+	}
+	return first, last, true
+}
+
+// good nodes per minute
+func (r *rates) goodPerMin() (float64, bool) {
+	first, last, ok := r.span()
+	if !ok {
+		return 0, false
+	}
+	return float64(last.good-first.good) / last.at.Sub(first.at).Minutes(), true
+}
+
+// processed nodes per minute
+func (r *rates) drainPerMin() (float64, bool) {
+	first, last, ok := r.span()
+	if !ok {
+		return 0, false
+	}
+	return float64(last.done-first.done) / last.at.Sub(first.at).Minutes(), true
+}
+
+// time left until the queue is drained, capped by the deadline
+func (r *rates) eta(started time.Time) (time.Duration, bool) {
+	if cfg.MaxDuration == 0 || started.IsZero() {
+		return 0, false
+	}
+	eta := time.Until(started.Add(cfg.MaxDuration))
+	if eta < 0 {
+		eta = 0
+	}
+	if drain, ok := r.drainPerMin(); ok && drain > 0 {
+		last := r.samples[len(r.samples)-1]
+		queue := time.Duration(float64(last.queued) / drain * float64(time.Minute))
+		if queue < eta {
+			eta = queue
+		}
+	}
+	return eta, true
+}
+
+func formatRate(v float64, ok bool) string {
+	if !ok {
+		return "—"
+	}
+	return fmt.Sprintf("%.1f/min", v)
+}
+
+// dead nodes take milliseconds, good ones seconds
+func slotTime(avg time.Duration, done int64) string {
+	if done == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%s (%d)", avg.Round(10*time.Millisecond), done)
+}
+
+func formatDuration(d time.Duration, ok bool) string {
+	if !ok {
+		return "—"
+	}
+	return d.Round(time.Second).String()
+}
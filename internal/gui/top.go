@@ -0,0 +1,67 @@
+package gui
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/1F47E/go-btc-xray/internal/stats"
+
+	tui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+var topHeader = []string{"Endpoint", "Ping", "Height", "User agent"}
+
+func newTopTable() *widgets.Table {
+	table := widgets.NewTable()
+	table.Title = "Top nodes"
+	table.RowSeparator = false
+	table.FillRow = false
+	table.TextStyle = tui.NewStyle(tui.ColorWhite)
+	table.RowStyles[0] = tui.NewStyle(tui.ColorCyan, tui.ColorClear, tui.ModifierBold)
+	table.Rows = [][]string{topHeader}
+	return table
+}
+
+// endpoint and user agent share the space left from ping and height
+func updateTopTable(table *widgets.Table, nodes []stats.NodeSummary) {
+	width := table.Inner.Dx()
+	fixed := []int{7, 8}
+	rest := width - fixed[0] - fixed[1]
+	if rest < 2 {
+		rest = 2
+	}
+	endpointW := rest * 3 / 5
+	agentW := rest - endpointW
+	table.ColumnWidths = []int{endpointW, fixed[0], fixed[1], agentW}
+
+	rows := make([][]string, 0, len(nodes)+1)
+	rows = append(rows, topHeader)
+	for _, n := range nodes {
+		rows = append(rows, []string{
+			truncateMiddle(n.Endpoint, endpointW-1),
+			fmt.Sprintf("%dms", n.RTT.Milliseconds()),
+			fmt.Sprintf("%d", n.Height),
+			truncateEnd(n.UserAgent, agentW-1),
+		})
+	}
+	table.Rows = rows
+}
+
+// long onion endpoints keep the start and the port visible
+func truncateMiddle(s string, max int) string {
+	if max < 3 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	r := []rune(s)
+	head := (max - 1) / 2
+	tail := max - 1 - head
+	return string(r[:head]) + "…" + string(r[len(r)-tail:])
+}
+
+func truncateEnd(s string, max int) string {
+	if max < 2 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-1]) + "…"
+}
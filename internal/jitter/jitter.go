@@ -0,0 +1,41 @@
+// jitter spreads the retries and the periodic requests of many connections
+// so they do not fire at the same instant
+package jitter
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Fraction is the max deviation from the base duration, ±20%
+const Fraction = 0.2
+
+var (
+	mu  sync.Mutex
+	rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Apply returns d randomized uniformly within ±Fraction, never negative
+func Apply(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	mu.Lock()
+	f := rnd.Float64()
+	mu.Unlock()
+	return time.Duration(float64(d) * (1 + Fraction*(2*f-1)))
+}
+
+// Backoff returns the delay before the retry of the attempt, 1 for the first retry.
+// base is doubled on every attempt up to max and the jitter is applied
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return Apply(d)
+}
@@ -0,0 +1,116 @@
+package jitter
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestApplyNotPositive(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		if got := Apply(d); got != d {
+			t.Errorf("Apply(%s) = %s, want %s", d, got, d)
+		}
+	}
+}
+
+// uniform within ±20%: the bounds, the mean, the variance and the spread over buckets
+func TestApplyDistribution(t *testing.T) {
+	defer func(r *rand.Rand) { rnd = r }(rnd)
+	rnd = rand.New(rand.NewSource(1))
+
+	const (
+		samples = 100000
+		buckets = 20
+		base    = time.Second
+	)
+	low := time.Duration(float64(base) * (1 - Fraction))
+	high := time.Duration(float64(base) * (1 + Fraction))
+	var sum, sumSq float64
+	var counts [buckets]int
+	for i := 0; i < samples; i++ {
+		d := Apply(base)
+		if d < low || d > high {
+			t.Fatalf("Apply(%s) = %s, out of [%s, %s]", base, d, low, high)
+		}
+		// relative deviation in [-1, 1]
+		x := (float64(d)/float64(base) - 1) / Fraction
+		sum += x
+		sumSq += x * x
+		b := int((x + 1) / 2 * buckets)
+		if b == buckets {
+			b--
+		}
+		counts[b]++
+	}
+	// uniform on [-1, 1]: mean 0, variance 1/3
+	mean := sum / samples
+	variance := sumSq/samples - mean*mean
+	if math.Abs(mean) > 0.01 {
+		t.Errorf("mean deviation %.4f, want 0", mean)
+	}
+	if math.Abs(variance-1.0/3) > 0.01 {
+		t.Errorf("variance %.4f, want %.4f", variance, 1.0/3)
+	}
+	// chi-squared of 19 degrees of freedom, 43.8 is p = 0.001
+	expected := float64(samples) / buckets
+	var chi2 float64
+	for _, c := range counts {
+		chi2 += (float64(c) - expected) * (float64(c) - expected) / expected
+	}
+	if chi2 > 43.8 {
+		t.Errorf("chi-squared %.1f over 43.8, not uniform: %v", chi2, counts)
+	}
+	// both ends are reached, the jitter is not narrower than ±20%
+	if counts[0] == 0 || counts[buckets-1] == 0 {
+		t.Errorf("edge buckets are empty: %v", counts)
+	}
+}
+
+// many connections retrying together are spread over the window
+func TestApplySpreadsBursts(t *testing.T) {
+	defer func(r *rand.Rand) { rnd = r }(rnd)
+	rnd = rand.New(rand.NewSource(2))
+
+	const conns = 1000
+	base := 10 * time.Second
+	// retries in the same 100ms slot
+	slots := make(map[time.Duration]int)
+	for i := 0; i < conns; i++ {
+		slots[Apply(base)/(100*time.Millisecond)]++
+	}
+	busiest := 0
+	for _, n := range slots {
+		if n > busiest {
+			busiest = n
+		}
+	}
+	// 4s window of 40 slots, 25 per slot on average
+	if busiest > 60 {
+		t.Errorf("%d of %d retries in one 100ms slot", busiest, conns)
+	}
+}
+
+// the delay doubles on every attempt up to max, within the jitter
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		got := Backoff(time.Second, 10*time.Second, tt.attempt)
+		low := time.Duration(float64(tt.want) * (1 - Fraction))
+		high := time.Duration(float64(tt.want) * (1 + Fraction))
+		if got < low || got > high {
+			t.Errorf("Backoff(attempt %d) = %s, want %s ±20%%", tt.attempt, got, tt.want)
+		}
+	}
+}
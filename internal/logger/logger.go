@@ -7,35 +7,133 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/1F47E/go-btc-xray/internal/config"
-	"github.com/1F47E/go-btc-xray/internal/gui"
 
 	"github.com/sirupsen/logrus"
 )
 
-var cfg = config.New()
+var cfg = config.Shared()
 
-type level string
+type Level string
 
 const (
-	Debug level = "DEBUG"
-	Info  level = "INFO"
-	Warn  level = "WARN"
-	Error level = "ERROR"
-	Fatal level = "FATAL"
+	Debug Level = "DEBUG"
+	Info  Level = "INFO"
+	Warn  Level = "WARN"
+	Error Level = "ERROR"
+	Fatal Level = "FATAL"
 )
 
+// Sink receives the rendered log lines, e.g. the gui.
+// Should not block, lines can be dropped.
+type Sink interface {
+	PushLog(level Level, line string, peer bool)
+}
+
+// MultiSink ships to all the sinks, nil sinks are skipped.
+// Returns nil if there is no sink at all.
+func MultiSink(sinks ...Sink) Sink {
+	var m multiSink
+	for _, s := range sinks {
+		if s != nil {
+			m = append(m, s)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
+}
+
+type multiSink []Sink
+
+func (m multiSink) PushLog(level Level, line string, peer bool) {
+	for _, sink := range m {
+		sink.PushLog(level, line, peer)
+	}
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(level Level, line string, peer bool)
+
+func (f SinkFunc) PushLog(level Level, line string, peer bool) {
+	f(level, line, peer)
+}
+
+// field keys
+const (
+	FieldModule = "module"
+	FieldPeer   = "peer"
+)
+
+// Interface is the logging of the client and the nodes, *Logger implements it.
+// Other loggers like zap or zerolog plug in with an adapter, tests can capture the lines
+type Interface interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Warn(args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatal(args ...interface{})
+	// With returns a copy logging the field too, FieldModule and FieldPeer scope the logs
+	With(key string, value interface{}) Interface
+}
+
+// Logger is shared between modules, every module gets a copy
+// with its own fields and level via WithModule/WithPeer/WithField.
+// Underlying logrus logger and the sink are shared.
 type Logger struct {
 	*logrus.Logger
-	guiCh chan gui.IncomingData
+	sink   Sink
+	fields logrus.Fields
+	module string
+	// levelSet shared by all the copies, replaced on SetLevels
+	levels *atomic.Value
+	// optional rotating copy of all the logs
+	file *rotatingFile
 }
 
-func New(guiCh chan gui.IncomingData) *Logger {
+// sink is optional, nil to log to the output only
+func New(sink Sink) *Logger {
 
 	log := initLogger()
-	return &Logger{log, guiCh}
+
+	l := &Logger{
+		Logger: log,
+		sink:   sink,
+		fields: logrus.Fields{},
+		levels: &atomic.Value{},
+	}
+	if err := l.SetLevels(cfg.LogLevel, cfg.LogLevels); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.LogFile != "" {
+		file, err := newRotatingFile(cfg.LogFile, int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var format logrus.Formatter
+		switch cfg.LogFormat {
+		case "json":
+			format = &logrus.JSONFormatter{}
+		case "text":
+			format = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+		default:
+			log.Fatalf("invalid log format %q, expected text or json", cfg.LogFormat)
+		}
+		log.AddHook(&fileHook{file: file, formatter: format})
+		l.file = file
+	}
+	return l
 }
 
 func initLogger() *logrus.Logger {
@@ -62,117 +160,216 @@ func initLogger() *logrus.Logger {
 		log.SetFormatter(&format)
 	}
 
-	if os.Getenv("DEBUG") == "1" {
-		log.SetLevel(logrus.DebugLevel)
-	} else {
-		log.SetLevel(logrus.InfoLevel)
-	}
+	// levels are filtered per module by the wrapper
+	log.SetLevel(logrus.DebugLevel)
 
 	return log
 }
 
+// switch the output from the gui log file back to stdout,
+// extra log file keeps working
 func (l *Logger) ResetToStdout() {
-	os.Setenv("GUI", "0")
-	l.Logger = initLogger()
+	if file, ok := l.Out.(*os.File); ok && file != os.Stdout && file != os.Stderr {
+		_ = file.Close()
+	}
+	var format logrus.TextFormatter
+	format.ForceColors = true
+	format.DisableTimestamp = true
+	l.SetOutput(os.Stdout)
+	l.SetFormatter(&format)
 }
 
 func (l *Logger) Close() error {
-	if file, ok := l.Out.(*os.File); ok {
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+	if file, ok := l.Out.(*os.File); ok && file != os.Stdout && file != os.Stderr {
 		return file.Close()
 	}
 	return nil
 }
 
+// ===== fields
+
+// WithModule returns a logger for the module with the module level applied
+func (l *Logger) WithModule(module string) *Logger {
+	c := l.WithField(FieldModule, module)
+	c.module = module
+	return c
+}
+
+// WithPeer returns a logger for the connection with the peer endpoint
+func (l *Logger) WithPeer(endpoint string) *Logger {
+	return l.WithField(FieldPeer, endpoint)
+}
+
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	c := *l
+	c.fields = make(logrus.Fields, len(l.fields)+1)
+	for k, v := range l.fields {
+		c.fields[k] = v
+	}
+	c.fields[key] = value
+	return &c
+}
+
+// With implements Interface, the module field applies the module level like WithModule
+func (l *Logger) With(key string, value interface{}) Interface {
+	if module, ok := value.(string); ok && key == FieldModule {
+		return l.WithModule(module)
+	}
+	return l.WithField(key, value)
+}
+
+type levelSet struct {
+	def     logrus.Level
+	modules map[string]logrus.Level
+}
+
+// SetLevels changes the default and the per module levels of all the loggers,
+// nothing is changed on error
+func (l *Logger) SetLevels(def string, modules map[string]string) error {
+	set := levelSet{modules: make(map[string]logrus.Level, len(modules))}
+	var err error
+	set.def, err = logrus.ParseLevel(def)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: %v", def, err)
+	}
+	for module, lvl := range modules {
+		set.modules[module], err = logrus.ParseLevel(lvl)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q for %s: %v", lvl, module, err)
+		}
+	}
+	l.levels.Store(set)
+	return nil
+}
+
+func (l *Logger) enabled(lvl logrus.Level) bool {
+	set := l.levels.Load().(levelSet)
+	max := set.def
+	if m, ok := set.modules[l.module]; ok {
+		max = m
+	}
+	return lvl <= max
+}
+
+func (l *Logger) entry() *logrus.Entry {
+	return l.Logger.WithFields(l.fields)
+}
+
 // ===== logrus wrapper
 
 // debug
 func (l *Logger) Debug(args ...interface{}) {
-	if os.Getenv("DEBUG") == "1" {
-		l.Logger.Debug(args...)
-		l.Ship("DEBUG", args...)
+	if l.enabled(logrus.DebugLevel) {
+		l.entry().Debug(args...)
+		l.Ship(Debug, args...)
 	}
 }
 
 func (l *Logger) Debugf(format string, args ...interface{}) {
-	if os.Getenv("DEBUG") == "1" {
-		if !strings.HasSuffix(format, "\n") {
-			format += "\n"
-		}
-		l.Logger.Debugf(format, args...)
-		l.Shipf("DEBUG", format, args...)
+	if l.enabled(logrus.DebugLevel) {
+		l.entry().Debugf(format, args...)
+		l.Shipf(Debug, format, args...)
 	}
 }
 
 // info
 func (l *Logger) Info(args ...interface{}) {
-	l.Logger.Info(args...)
-	l.Ship(Info, args...)
+	if l.enabled(logrus.InfoLevel) {
+		l.entry().Info(args...)
+		l.Ship(Info, args...)
+	}
 }
 
 func (l *Logger) Infof(format string, args ...interface{}) {
-	l.Logger.Infof(format, args...)
-	l.Shipf(Info, format, args...)
+	if l.enabled(logrus.InfoLevel) {
+		l.entry().Infof(format, args...)
+		l.Shipf(Info, format, args...)
+	}
 }
 
 // warn
 func (l *Logger) Warn(args ...interface{}) {
-	l.Logger.Warn(args...)
-	l.Ship(Warn, args...)
+	if l.enabled(logrus.WarnLevel) {
+		l.entry().Warn(args...)
+		l.Ship(Warn, args...)
+	}
 }
 
 func (l *Logger) Warnf(format string, args ...interface{}) {
-	l.Logger.Warnf(format, args...)
-	l.Shipf(Warn, format, args...)
+	if l.enabled(logrus.WarnLevel) {
+		l.entry().Warnf(format, args...)
+		l.Shipf(Warn, format, args...)
+	}
 }
 
 // error
 func (l *Logger) Error(args ...interface{}) {
-	l.Logger.Error(args...)
-	l.Ship(Error, args...)
+	if l.enabled(logrus.ErrorLevel) {
+		l.entry().Error(args...)
+		l.Ship(Error, args...)
+	}
 }
 
 func (l *Logger) Errorf(format string, args ...interface{}) {
-	l.Logger.Errorf(format, args...)
-	l.Shipf(Error, format, args...)
+	if l.enabled(logrus.ErrorLevel) {
+		l.entry().Errorf(format, args...)
+		l.Shipf(Error, format, args...)
+	}
 }
 
-// fatal
+// fatal, always logged
 func (l *Logger) Fatal(args ...interface{}) {
-	l.Logger.Fatal(args...)
 	l.Ship(Fatal, args...)
+	l.entry().Fatal(args...)
 }
 
 func (l *Logger) Fatalf(format string, args ...interface{}) {
-	l.Logger.Fatalf(format, args...)
 	l.Shipf(Fatal, format, args...)
+	l.entry().Fatalf(format, args...)
 }
 
-// ===== Ship logs to the chan to be displayed in the GUI
+// ===== Ship logs to the sink to be displayed in the GUI
 
-func (l *Logger) Ship(t level, args ...interface{}) {
-	msg := fmt.Sprintf("%s: ", t)
-	msg += fmt.Sprint(args...)
-	l.ship(msg)
+func (l *Logger) Ship(t Level, args ...interface{}) {
+	l.ship(t, fmt.Sprint(args...))
 }
 
-func (l *Logger) Shipf(t level, format string, args ...interface{}) {
-	msg := fmt.Sprintf("%s: ", t)
-	msg += fmt.Sprintf(format, args...)
-	l.ship(msg)
+func (l *Logger) Shipf(t Level, format string, args ...interface{}) {
+	l.ship(t, fmt.Sprintf(format, args...))
 }
 
-// ship to gui logs chan if it's not full
-func (l *Logger) ship(msg string) {
+// ship to the sink rendered as a single line: "LEVEL: [module] msg key=value"
+func (l *Logger) ship(t Level, msg string) {
+	if l.sink == nil {
+		return
+	}
 	// strip newlines, logs for gui will be in a array and then joined with newlines
-	msg = strings.TrimSuffix(msg, "\n")
-	if l.guiCh != nil && len(l.guiCh) < cap(l.guiCh) {
-		// detect if node msg or log
-		d := gui.IncomingData{}
-		if strings.Contains(msg, "▶︎") || strings.Contains(msg, "◀︎") {
-			d.Msg = msg
-		} else {
-			d.Log = msg
+	msg = strings.TrimSpace(msg)
+
+	line := fmt.Sprintf("%s: ", t)
+	if module, ok := l.fields[FieldModule]; ok {
+		line += fmt.Sprintf("[%v] ", module)
+	}
+	if peer, ok := l.fields[FieldPeer]; ok {
+		line += fmt.Sprintf("%v ", peer)
+	}
+	line += msg
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		if k == FieldModule || k == FieldPeer {
+			continue
 		}
-		l.guiCh <- d
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line += fmt.Sprintf(" %s=%v", k, l.fields[k])
+	}
+
+	// connection logs are marked to go to the messages pane
+	_, peer := l.fields[FieldPeer]
+	l.sink.PushLog(t, line, peer)
 }
@@ -0,0 +1,98 @@
+// expvar counters for the debug server, see cfg.DebugAddr
+package metrics
+
+import (
+	"expvar"
+	"runtime"
+)
+
+// goroutine roles
+const (
+	RoleConnector = "connectors"
+	RoleListener  = "listeners"
+	RoleWorker    = "workers"
+)
+
+// running goroutines by role, a growing role is the one leaking
+var goroutines = expvar.NewMap("goroutines")
+
+// channels watched for the back pressure
+const (
+	ChanAddrs = "addrs"
+	ChanGUI   = "gui"
+)
+
+// sends that found the channel full by channel, the addrs sender waits,
+// the gui one drops. the depth is set on every stats tick
+var (
+	channelFull  = expvar.NewMap("channel_full")
+	channelDepth = expvar.NewMap("channel_depth")
+)
+
+// bytes of the crawl connections and the rate in bytes per second, see SetTraffic
+var traffic = expvar.NewMap("traffic")
+
+// netgroups of the good nodes, see stats.Diversity
+var diversity = expvar.NewMap("diversity")
+
+func init() {
+	expvar.Publish("goroutines_total", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// Track counts a running goroutine of the role, call the returned func on exit:
+//
+//	defer metrics.Track(metrics.RoleWorker)()
+func Track(role string) func() {
+	goroutines.Add(role, 1)
+	return func() {
+		goroutines.Add(role, -1)
+	}
+}
+
+// SetDiversity publishes the netgroups of the good nodes
+func SetDiversity(groups int, largestShare, hhi float64) {
+	g := new(expvar.Int)
+	g.Set(int64(groups))
+	diversity.Set("netgroups", g)
+	share := new(expvar.Float)
+	share.Set(largestShare)
+	diversity.Set("largest_share", share)
+	h := new(expvar.Float)
+	h.Set(hhi)
+	diversity.Set("hhi", h)
+}
+
+// ChannelFull counts a send that found the channel full
+func ChannelFull(name string) {
+	channelFull.Add(name, 1)
+}
+
+// ChannelFullCount returns the full events of the channel so far
+func ChannelFullCount(name string) int64 {
+	if v, ok := channelFull.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// SetChannelDepth publishes the queued items of the channel
+func SetChannelDepth(name string, depth int) {
+	d := new(expvar.Int)
+	d.Set(int64(depth))
+	channelDepth.Set(name, d)
+}
+
+// SetTraffic publishes the bytes of the crawl connections
+func SetTraffic(in, out uint64, rate float64) {
+	i := new(expvar.Int)
+	i.Set(int64(in))
+	traffic.Set("bytes_in", i)
+	o := new(expvar.Int)
+	o.Set(int64(out))
+	traffic.Set("bytes_out", o)
+	r := new(expvar.Float)
+	r.Set(rate)
+	traffic.Set("bytes_per_sec", r)
+}
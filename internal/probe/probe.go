@@ -0,0 +1,149 @@
+// probe a single node: connect, handshake, ping and getaddr,
+// then report what the node told us about itself
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+)
+
+var cfg = config.Shared()
+
+type Result struct {
+	Endpoint   string `json:"endpoint"`
+	Handshake  bool   `json:"handshake"`
+	Version    int32  `json:"version"`
+	UserAgent  string `json:"user_agent"`
+	Services   string `json:"services"`
+	Height     int32  `json:"height"`
+	PingMs     int64  `json:"ping_ms"`
+	Addresses  int    `json:"addresses"`
+	InvCount   int    `json:"inv_count"`
+	ServesData bool   `json:"serves_data"`
+	SupportsV2 bool   `json:"supports_v2"`
+	Chain      string `json:"chain,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+	Rejected   string `json:"rejected,omitempty"`
+	PeerReject string `json:"peer_reject,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Run connects to the target (host:port or bare ip) and blocks
+// until the node answered getaddr, disconnected or the timeout is reached.
+// Returned error means the handshake failed.
+func Run(ctx context.Context, log logger.Interface, target string) (*Result, error) {
+	start := time.Now()
+	// dial + handshake sleeps + waiting for the getaddr answer
+	timeout := cfg.DialTimeout + cfg.HandshakeTimeout + cfg.PingTimeout
+	if cfg.ChainCheckpoint != nil {
+		// getheaders waits up to HANDSHAKE_TIMEOUT again
+		timeout += cfg.HandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addrCh := make(chan node.AddrBatch, 1)
+	resCh := make(chan *node.Node, 1)
+	errCh := make(chan error, 1)
+	n := node.NewNode(log, target, addrCh)
+	go func() {
+		errCh <- n.Connect(ctx, resCh)
+	}()
+
+	res := &Result{Endpoint: n.Endpoint()}
+	var err error
+
+	// wait for the handshake
+	select {
+	case <-ctx.Done():
+		err = fmt.Errorf("handshake timeout")
+	case err = <-errCh:
+		if err == nil {
+			err = fmt.Errorf("connection closed during handshake")
+		}
+	case <-resCh:
+	}
+
+	// wait for the addresses
+	if err == nil {
+		select {
+		case <-ctx.Done():
+		case <-errCh:
+		case batch := <-addrCh:
+			res.Addresses = len(batch.Addrs)
+		}
+	}
+	n.Disconnect()
+
+	if err == nil && !n.IsHandshaked() {
+		err = fmt.Errorf("no version/verack received")
+	}
+	res.Handshake = err == nil
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Version = n.Version()
+	res.UserAgent = n.UserAgent()
+	res.Services = n.Services().String()
+	res.Height = n.Height()
+	res.PingMs = n.RTT().Milliseconds()
+	res.InvCount = n.InvCount()
+	res.ServesData = n.ServesData()
+	res.SupportsV2 = n.SupportsV2()
+	res.Chain = string(n.Chain())
+	res.Timeout = string(n.TimedOut())
+	res.Rejected = string(n.Rejected())
+	res.PeerReject = n.PeerReject()
+	res.DurationMs = time.Since(start).Milliseconds()
+	return res, err
+}
+
+func (r *Result) Print(w io.Writer) {
+	fmt.Fprintf(w, "endpoint:   %s\n", r.Endpoint)
+	fmt.Fprintf(w, "handshake:  %t\n", r.Handshake)
+	if r.Error != "" {
+		fmt.Fprintf(w, "error:      %s\n", r.Error)
+	}
+	if r.Timeout != "" {
+		fmt.Fprintf(w, "timeout:    %s\n", r.Timeout)
+	}
+	if r.Rejected != "" {
+		fmt.Fprintf(w, "rejected:   %s\n", r.Rejected)
+	}
+	if r.PeerReject != "" {
+		fmt.Fprintf(w, "reject msg: %s\n", r.PeerReject)
+	}
+	fmt.Fprintf(w, "version:    %d\n", r.Version)
+	fmt.Fprintf(w, "user agent: %s\n", r.UserAgent)
+	fmt.Fprintf(w, "services:   %s\n", r.Services)
+	fmt.Fprintf(w, "height:     %d\n", r.Height)
+	if r.PingMs > 0 {
+		fmt.Fprintf(w, "ping:       %dms\n", r.PingMs)
+	} else {
+		fmt.Fprintf(w, "ping:       -\n")
+	}
+	fmt.Fprintf(w, "addresses:  %d\n", r.Addresses)
+	fmt.Fprintf(w, "invs:       %d\n", r.InvCount)
+	if cfg.InvSample > 0 {
+		fmt.Fprintf(w, "serves:     %t\n", r.ServesData)
+	}
+	if cfg.V2Probe {
+		fmt.Fprintf(w, "v2:         %t\n", r.SupportsV2)
+	}
+	if cfg.ChainCheckpoint != nil {
+		fmt.Fprintf(w, "chain:      %s\n", r.Chain)
+	}
+	fmt.Fprintf(w, "took:       %dms\n", r.DurationMs)
+}
+
+func (r *Result) PrintJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
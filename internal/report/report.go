@@ -0,0 +1,402 @@
+// crawl summary with totals and breakdowns of the good nodes
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+)
+
+type Summary struct {
+	Network string `json:"network"`
+	// user agent we presented to the nodes
+	OurUserAgent string    `json:"our_user_agent"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+	// addr entries from the peers, repeats included
+	AddrsSeen int64 `json:"addrs_seen"`
+	// every address heard about, including unreachable ones
+	NodesTotal int `json:"nodes_total"`
+	// unique endpoints that completed the handshake
+	NodesReachable int `json:"nodes_reachable"`
+	NodesGood      int `json:"nodes_good"`
+	NodesDead      int `json:"nodes_dead"`
+	// reachable but closed after the version, by reason, not in the good list
+	NodesRejected int            `json:"nodes_rejected,omitempty"`
+	Rejected      map[string]int `json:"rejected,omitempty"`
+	UserAgents    map[string]int `json:"user_agents"`
+	Versions      map[int32]int  `json:"versions"`
+	Services      map[string]int `json:"services"`
+	// good nodes by kind, full, limited (pruned) or neither
+	Kinds map[string]int `json:"kinds"`
+	// pruned nodes not counted as good with SKIP_LIMITED
+	NodesLimitedSkipped int `json:"nodes_limited_skipped,omitempty"`
+	// nodes heard about and good ones by the address type, ipv4, ipv6 or onion
+	Networks map[string]NetCount `json:"networks"`
+	// good nodes by port, non default ports are often tor or custom setups
+	Ports map[int]int `json:"ports"`
+	// dead nodes by the reason of the last failed connect, see node.DeadReason
+	DeadReasons map[string]int `json:"dead_reasons"`
+	// dial errors and retries by error class
+	DialErrors  map[string]int `json:"dial_errors"`
+	DialRetries map[string]int `json:"dial_retries"`
+	// bytes of all the connections, the probes and the inbound peers included
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	// finished connections and their average time from the dial to the close
+	ConnsDone int64 `json:"conns_done"`
+	ConnAvgMs int64 `json:"conn_avg_ms"`
+	// FAST_CRAWL nodes closed by FAST_TIMEOUT without an addr answer
+	AddrTimeouts int64 `json:"addr_timeouts,omitempty"`
+	// handshaked nodes that failed a CONFIRM_PROBES handshake, not in the good list
+	Unconfirmed uint64 `json:"unconfirmed,omitempty"`
+	// addr entries dropped by ADDR_MAX_AGE
+	AddrsTooOld uint64 `json:"addrs_too_old,omitempty"`
+	// addresses dropped by MAX_DEPTH
+	AddrsTooDeep int64 `json:"addrs_too_deep,omitempty"`
+	// peers connected to us, only set with LISTEN_ADDR
+	Inbound *Inbound `json:"inbound,omitempty"`
+	// idle connections closed by PREEMPT_MAX for the queued nodes
+	Preempted int64 `json:"preempted,omitempty"`
+	// addresses not queued by the reason, see client.FilterNotWhitelisted
+	Filtered map[string]int64 `json:"filtered,omitempty"`
+	// retries dropped as the endpoint failed MAX_ATTEMPTS times
+	AttemptsCapped int64 `json:"attempts_capped,omitempty"`
+	// known nodes dropped from the memory over MAX_KNOWN_NODES, see client.evictKnown
+	Evicted int64 `json:"evicted,omitempty"`
+	// hosts banned at the end and the addresses or dials skipped for the bans
+	Banned  int   `json:"banned,omitempty"`
+	BanHits int64 `json:"ban_hits,omitempty"`
+	// spread of the good nodes over the netgroups, only set if there is one
+	Diversity *Diversity `json:"diversity,omitempty"`
+	// good nodes by country and ASN, at most stats.TopGeoLimit, only set with GEO_FILE or GEO_MMDB
+	Countries []stats.GeoCount `json:"countries,omitempty"`
+	ASNs      []stats.GeoCount `json:"asns,omitempty"`
+	// nodes by the dns seed or the seed list they came through, the first one to reach them
+	Seeds map[string]SeedCount `json:"seeds,omitempty"`
+	// hops from the seeds of the good nodes, only set if there is one
+	DepthMax    *int32 `json:"depth_max,omitempty"`
+	DepthMedian *int32 `json:"depth_median,omitempty"`
+	// good nodes that answered the BIP324 v2 handshake, only set with V2_PROBE
+	NodesV2 *int `json:"nodes_v2,omitempty"`
+	// good nodes by the answer to getheaders, only set with CHAIN_CHECKPOINT
+	Chain map[string]int `json:"chain,omitempty"`
+	// best chain height advertised by the good nodes, bogus ones skipped, see stats.HeightStats
+	HeightMax    *int32 `json:"height_max,omitempty"`
+	HeightMedian *int32 `json:"height_median,omitempty"`
+	// good nodes behind the median by STALE_BLOCKS or far above it, see stats.Lying
+	NodesStale int `json:"nodes_stale"`
+	NodesLying int `json:"nodes_lying,omitempty"`
+	// only set if at least one good node answered the ping
+	LatencyMedianMs *int64 `json:"latency_median_ms,omitempty"`
+	LatencyP90Ms    *int64 `json:"latency_p90_ms,omitempty"`
+	LatencyP99Ms    *int64 `json:"latency_p99_ms,omitempty"`
+	// latest feefilter of the connected nodes, only set if at least one sent it, see SetFeeFilter
+	FeeFilter *FeeFilter `json:"fee_filter,omitempty"`
+	// version timestamps of the good nodes against our clock
+	ClockSkew *ClockSkew `json:"clock_skew,omitempty"`
+	// paths of the files written by the crawl, the summary files included
+	Files []string `json:"files"`
+}
+
+type NetCount struct {
+	Total int `json:"total"`
+	Good  int `json:"good"`
+}
+
+// see stats.InboundCounts
+type Inbound struct {
+	Accepted   int `json:"accepted"`
+	Handshaked int `json:"handshaked"`
+	Refused    int `json:"refused"`
+}
+
+// see stats.Diversity
+type Diversity struct {
+	Netgroups    int     `json:"netgroups"`
+	LargestShare float64 `json:"largest_share"`
+	HHI          float64 `json:"hhi"`
+}
+
+type SeedCount struct {
+	Queued int `json:"queued"`
+	Good   int `json:"good"`
+	Dead   int `json:"dead"`
+}
+
+// see stats.ClockSkewStats
+type ClockSkew struct {
+	Peers    int   `json:"peers"`
+	MedianMs int64 `json:"median_ms"`
+	// off by more than stats.MaxClockSkew, bitcoind would disconnect them
+	Off int `json:"off"`
+}
+
+// BIP133 min fee rates in sat/kvB, see stats.FeeFilterStats
+type FeeFilter struct {
+	Peers  int   `json:"peers"`
+	Min    int64 `json:"min"`
+	Median int64 `json:"median"`
+	P90    int64 `json:"p90"`
+}
+
+// SetFeeFilter sets the percentiles of the fees of the connected peers, -1 for the ones
+// that sent none, left unset if none sent it
+func (s *Summary) SetFeeFilter(fees []int64) {
+	if f := stats.FeeFilterStats(fees); f.Peers > 0 {
+		s.FeeFilter = &FeeFilter{Peers: f.Peers, Min: f.Min, Median: f.Median, P90: f.P90}
+	}
+}
+
+func New(network string, started time.Time, total, dead int, good []*node.Node) *Summary {
+	s := &Summary{
+		Network:    network,
+		Started:    started,
+		Finished:   time.Now(),
+		NodesTotal: total,
+		NodesGood:  len(good),
+		NodesDead:  dead,
+		UserAgents: make(map[string]int),
+		Versions:   make(map[int32]int),
+		Services:   make(map[string]int),
+		Kinds:      make(map[string]int),
+		Networks:   make(map[string]NetCount),
+		Ports:      make(map[int]int),
+
+		DeadReasons: make(map[string]int),
+		DialErrors:  make(map[string]int),
+		DialRetries: make(map[string]int),
+	}
+	rtts := make([]time.Duration, 0, len(good))
+	heights := make([]int32, 0, len(good))
+	skews := make([]time.Duration, 0, len(good))
+	depths := make([]int32, 0, len(good))
+	for _, n := range good {
+		depths = append(depths, n.Depth())
+		if skew, ok := n.ClockSkew(); ok {
+			skews = append(skews, skew)
+		}
+		heights = append(heights, n.Height())
+		ua := n.UserAgent()
+		if ua == "" {
+			ua = "unknown"
+		}
+		s.UserAgents[ua]++
+		s.Versions[n.Version()]++
+		s.Services[n.Services().String()]++
+		if rtt := n.RTT(); rtt > 0 {
+			rtts = append(rtts, rtt)
+		}
+	}
+	if max, median, ok := stats.HeightStats(heights); ok {
+		s.HeightMax, s.HeightMedian = &max, &median
+	}
+	if len(depths) > 0 {
+		sort.Slice(depths, func(i, j int) bool { return depths[i] < depths[j] })
+		max, median := depths[len(depths)-1], depths[len(depths)/2]
+		s.DepthMax, s.DepthMedian = &max, &median
+	}
+	if sk := stats.ClockSkewStats(skews); sk.Peers > 0 {
+		s.ClockSkew = &ClockSkew{Peers: sk.Peers, MedianMs: sk.Median.Milliseconds(), Off: sk.Off}
+	}
+	if len(rtts) > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		median := rtts[len(rtts)/2].Milliseconds()
+		p90 := rtts[(len(rtts)*90+99)/100-1].Milliseconds()
+		p99 := rtts[(len(rtts)*99+99)/100-1].Milliseconds()
+		s.LatencyMedianMs, s.LatencyP90Ms, s.LatencyP99Ms = &median, &p90, &p99
+	}
+	return s
+}
+
+// human readable version of the summary
+func (s *Summary) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "network:     %s\n", s.Network)
+	fmt.Fprintf(w, "sent as:     %s\n", s.OurUserAgent)
+	fmt.Fprintf(w, "started:     %s\n", s.Started.Format(time.RFC3339))
+	fmt.Fprintf(w, "finished:    %s (%s)\n", s.Finished.Format(time.RFC3339), s.Finished.Sub(s.Started).Round(time.Second))
+	fmt.Fprintf(w, "addresses:   %d seen\n", s.AddrsSeen)
+	fmt.Fprintf(w, "total nodes: %d\n", s.NodesTotal)
+	fmt.Fprintf(w, "reachable:   %d\n", s.NodesReachable)
+	fmt.Fprintf(w, "good nodes:  %d\n", s.NodesGood)
+	fmt.Fprintf(w, "dead nodes:  %d\n", s.NodesDead)
+	if s.NodesRejected > 0 {
+		fmt.Fprintf(w, "rejected:    %d\n", s.NodesRejected)
+	}
+	if s.NodesLimitedSkipped > 0 {
+		fmt.Fprintf(w, "pruned:      %d skipped\n", s.NodesLimitedSkipped)
+	}
+	if s.NodesV2 != nil {
+		fmt.Fprintf(w, "v2:          %d\n", *s.NodesV2)
+	}
+	if s.Chain != nil {
+		fmt.Fprintf(w, "chain:       %d verified, %d fork, %d unknown, %d unanswered\n",
+			s.Chain["verified"], s.Chain["fork"], s.Chain["unknown"], s.Chain["unanswered"])
+	}
+	if s.HeightMax != nil {
+		fmt.Fprintf(w, "height:      %d max, %d median, %d stale, %d lying\n", *s.HeightMax, *s.HeightMedian, s.NodesStale, s.NodesLying)
+	} else {
+		fmt.Fprintf(w, "height:      -\n")
+	}
+	fmt.Fprintf(w, "connections: %d, %dms avg\n", s.ConnsDone, s.ConnAvgMs)
+	fmt.Fprintf(w, "traffic:     %.1fMB in, %.1fMB out\n", float64(s.BytesIn)/(1<<20), float64(s.BytesOut)/(1<<20))
+	if s.AddrTimeouts > 0 {
+		fmt.Fprintf(w, "no addrs:    %d timed out\n", s.AddrTimeouts)
+	}
+	if s.Unconfirmed > 0 {
+		fmt.Fprintf(w, "unconfirmed: %d nodes\n", s.Unconfirmed)
+	}
+	if s.AddrsTooOld > 0 {
+		fmt.Fprintf(w, "too old:     %d addresses\n", s.AddrsTooOld)
+	}
+	if s.DepthMax != nil {
+		fmt.Fprintf(w, "depth:       %d max, %d median\n", *s.DepthMax, *s.DepthMedian)
+	}
+	if s.AddrsTooDeep > 0 {
+		fmt.Fprintf(w, "too deep:    %d addresses\n", s.AddrsTooDeep)
+	}
+	if s.Inbound != nil {
+		fmt.Fprintf(w, "inbound:     %d accepted, %d handshaked, %d refused\n", s.Inbound.Accepted, s.Inbound.Handshaked, s.Inbound.Refused)
+	}
+	if s.Preempted > 0 {
+		fmt.Fprintf(w, "preempted:   %d connections\n", s.Preempted)
+	}
+	for reason, cnt := range s.Filtered {
+		fmt.Fprintf(w, "filtered:    %d addresses, %s\n", cnt, reason)
+	}
+	if s.AttemptsCapped > 0 {
+		fmt.Fprintf(w, "capped:      %d retries over MAX_ATTEMPTS\n", s.AttemptsCapped)
+	}
+	if s.Evicted > 0 {
+		fmt.Fprintf(w, "evicted:     %d known nodes over MAX_KNOWN_NODES\n", s.Evicted)
+	}
+	if s.Banned > 0 || s.BanHits > 0 {
+		fmt.Fprintf(w, "banned:      %d hosts, %d addresses skipped\n", s.Banned, s.BanHits)
+	}
+	if s.Diversity != nil {
+		fmt.Fprintf(w, "netgroups:   %d, largest %.1f%%, hhi %.4f\n", s.Diversity.Netgroups, s.Diversity.LargestShare*100, s.Diversity.HHI)
+	}
+	if s.LatencyMedianMs != nil {
+		fmt.Fprintf(w, "latency:     %dms median, %dms p90, %dms p99\n", *s.LatencyMedianMs, *s.LatencyP90Ms, *s.LatencyP99Ms)
+	} else {
+		fmt.Fprintf(w, "latency:     -\n")
+	}
+	if s.FeeFilter != nil {
+		fmt.Fprintf(w, "feefilter:   %d min, %d median, %d p90 sat/kvB of %d\n", s.FeeFilter.Min, s.FeeFilter.Median, s.FeeFilter.P90, s.FeeFilter.Peers)
+	} else {
+		fmt.Fprintf(w, "feefilter:   -\n")
+	}
+	if s.ClockSkew != nil {
+		median := time.Duration(s.ClockSkew.MedianMs) * time.Millisecond
+		fmt.Fprintf(w, "clock skew:  %s median, %d off by over %s of %d\n", median, s.ClockSkew.Off, stats.MaxClockSkew, s.ClockSkew.Peers)
+	}
+
+	versions := make(map[string]int, len(s.Versions))
+	for v, cnt := range s.Versions {
+		versions[fmt.Sprint(v)] = cnt
+	}
+	writeBreakdown(w, "user agents", s.UserAgents)
+	writeBreakdown(w, "versions", versions)
+	writeBreakdown(w, "services", s.Services)
+	writeBreakdown(w, "kinds", s.Kinds)
+	writeNetworks(w, s.Networks)
+	ports := make(map[string]int, len(s.Ports))
+	for p, cnt := range s.Ports {
+		ports[fmt.Sprint(p)] = cnt
+	}
+	writeBreakdown(w, "ports", ports)
+	writeBreakdown(w, "dead nodes", s.DeadReasons)
+	writeBreakdown(w, "dial errors", s.DialErrors)
+	writeBreakdown(w, "dial retries", s.DialRetries)
+	if s.NodesRejected > 0 {
+		writeBreakdown(w, "rejected", s.Rejected)
+	}
+	if s.Countries != nil {
+		writeTop(w, "countries", s.Countries)
+		writeTop(w, "ASNs", s.ASNs)
+	}
+	if len(s.Seeds) > 0 {
+		writeSeeds(w, s.Seeds)
+	}
+	if len(s.Files) > 0 {
+		fmt.Fprintf(w, "\nfiles:\n")
+		for _, path := range s.Files {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+	}
+}
+
+// print the address types sorted by name
+func writeNetworks(w io.Writer, m map[string]NetCount) {
+	fmt.Fprintf(w, "\nnetworks (total, good):\n")
+	if len(m) == 0 {
+		fmt.Fprintf(w, "  -\n")
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %6d %6d  %s\n", m[k].Total, m[k].Good, k)
+	}
+}
+
+// print the counts in the given order, already sorted by stats.TopGeo
+func writeTop(w io.Writer, title string, top []stats.GeoCount) {
+	fmt.Fprintf(w, "\n%s:\n", title)
+	if len(top) == 0 {
+		fmt.Fprintf(w, "  -\n")
+		return
+	}
+	for _, gc := range top {
+		fmt.Fprintf(w, "  %6d  %s\n", gc.Count, gc.Name)
+	}
+}
+
+// print the seeds sorted from the most good nodes
+func writeSeeds(w io.Writer, m map[string]SeedCount) {
+	fmt.Fprintf(w, "\nseeds (queued, good, dead):\n")
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]].Good == m[keys[j]].Good {
+			return keys[i] < keys[j]
+		}
+		return m[keys[i]].Good > m[keys[j]].Good
+	})
+	for _, k := range keys {
+		sc := m[k]
+		fmt.Fprintf(w, "  %6d %6d %6d  %s\n", sc.Queued, sc.Good, sc.Dead, k)
+	}
+}
+
+// print counts sorted from the most common
+func writeBreakdown(w io.Writer, title string, m map[string]int) {
+	fmt.Fprintf(w, "\n%s:\n", title)
+	if len(m) == 0 {
+		fmt.Fprintf(w, "  -\n")
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] == m[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return m[keys[i]] > m[keys[j]]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %6d  %s\n", m[k], strings.TrimSpace(k))
+	}
+}
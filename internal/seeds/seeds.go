@@ -0,0 +1,78 @@
+// seeds reads the initial nodes from a plain text file,
+// one host:port or bare host per line, # starts a comment
+package seeds
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MalformedError lists the skipped lines, the seeds returned with it are still valid
+type MalformedError struct {
+	Path  string
+	Lines []int
+}
+
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("%s: %d malformed lines skipped, first on line %d", e.Path, len(e.Lines), e.Lines[0])
+}
+
+// FromFile returns the seeds in the file order, duplicates included,
+// the error is a *MalformedError if only some lines were skipped
+func FromFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var seeds []string
+	var malformed []int
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if !valid(text) {
+			malformed = append(malformed, line)
+			continue
+		}
+		seeds = append(seeds, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return seeds, err
+	}
+	if len(malformed) > 0 {
+		return seeds, &MalformedError{Path: path, Lines: malformed}
+	}
+	return seeds, nil
+}
+
+// host:port, [ipv6]:port, a bare ipv6 or a bare host, the port is optional
+func valid(s string) bool {
+	if strings.ContainsAny(s, " \t,") {
+		return false
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// no port, only a bare ipv6 may have colons
+		if strings.Contains(s, ":") {
+			return net.ParseIP(s) != nil
+		}
+		host = s
+	} else if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return false
+	}
+	return host != ""
+}
@@ -0,0 +1,403 @@
+// crawl stats pushed by the client to whatever displays them,
+// kept separate from the gui so the client does not depend on termui
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// Sink receives a stats snapshot on every client tick
+type Sink interface {
+	Push(s Stats)
+}
+
+// Multi pushes to all the sinks in order, nil sinks are skipped.
+// Returns nil if there is no sink at all.
+func Multi(sinks ...Sink) Sink {
+	var m multi
+	for _, s := range sinks {
+		if s != nil {
+			m = append(m, s)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
+}
+
+type multi []Sink
+
+func (m multi) Push(s Stats) {
+	for _, sink := range m {
+		sink.Push(s)
+	}
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(s Stats)
+
+func (f SinkFunc) Push(s Stats) {
+	f(s)
+}
+
+// nodes count by address type
+type AddrCounts struct {
+	IPv4  int
+	IPv6  int
+	Onion int
+}
+
+// good nodes count by node.Kind
+type KindCounts struct {
+	Full    int
+	Limited int
+	Neither int
+}
+
+type Stats struct {
+	Connections int
+	// current limit, can change at runtime, lower than the max in the adaptive mode
+	ConnectionsLimit int
+	ConnectionsMax   int
+	// all discovered addresses, including unreachable
+	NodesTotal int
+	// unique endpoints that completed the handshake
+	NodesReachable int
+	NodesGood      int
+	NodesDead      int32
+	NodesQueued    int
+	// crawl start time, zero until the client is started
+	Started   time.Time
+	AddrTotal AddrCounts
+	AddrGood  AddrCounts
+	GoodKinds KindCounts
+	// pruned nodes not counted as good with SKIP_LIMITED
+	NodesLimitedSkipped int
+	// dead nodes by the reason of the last failed connect, see node.DeadReason
+	DeadReasons map[string]int
+	// dial errors and retries by error class
+	DialErrors  map[string]int
+	DialRetries map[string]int
+	// reachable nodes closed after their version by reason, see node.Reject
+	Rejected map[string]int
+	// good nodes count per latency bucket, see LatencyHistogram
+	Latency []int
+	// total messages received and sent
+	MsgsIn  uint64
+	MsgsOut uint64
+	// of the process, the dials wait over MAX_GOROUTINES
+	Goroutines int
+	// nodes by the seed they came through
+	Seeds map[string]SeedCount
+	// zero without LISTEN_ADDR
+	Inbound InboundCounts
+	// hosts banned for misbehaving or by BAN_FILE
+	Banned int
+	// received messages by command, see MsgTypeCounts
+	MsgTypes map[string]uint64
+	// fastest good nodes, at most TopNodesLimit
+	TopNodes []NodeSummary
+	// active connections, oldest first
+	ActiveConns []ConnInfo
+	// best chain height advertised by the good nodes, zero until one is known, see HeightStats
+	HeightMax    int32
+	HeightMedian int32
+	// good nodes behind the median by STALE_BLOCKS or far above it
+	NodesStale int
+	NodesLying int
+	// feefilter of the connected peers, zero peers until one sent it
+	FeeFilter FeeStats
+	// version timestamps of the good nodes against our clock
+	ClockSkew SkewStats
+	// finished connections and their average time in the connectors
+	ConnsDone int64
+	ConnAvg   time.Duration
+	// good nodes by netgroup
+	Diversity Diversity
+	// bytes of all the connections of the crawl, the rate in bytes per second
+	// and the dials waiting for MAX_BANDWIDTH
+	BytesIn         uint64
+	BytesOut        uint64
+	BytesRate       float64
+	BandwidthCapped bool
+	// good nodes by country and ASN, at most TopGeoLimit, nil without the geo file
+	TopCountries []GeoCount
+	TopASNs      []GeoCount
+	// MONITOR peers, fastest first, and the dropped ones replaced so far
+	Monitored    []MonitorInfo
+	MonitorDrops int
+	// the crawl waits for a refresh while the monitor set is established
+	CrawlPaused bool
+}
+
+// count of a message command
+type MsgTypeCount struct {
+	Command string
+	Count   uint64
+}
+
+// MsgTypeCounts returns the counts biggest first, same counts are sorted by command
+func MsgTypeCounts(types map[string]uint64) []MsgTypeCount {
+	counts := make([]MsgTypeCount, 0, len(types))
+	for command, cnt := range types {
+		counts = append(counts, MsgTypeCount{Command: command, Count: cnt})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Command < counts[j].Command
+	})
+	return counts
+}
+
+// peers connected to us with LISTEN_ADDR, not in the crawl counts
+type InboundCounts struct {
+	Active   int
+	Accepted int
+	// completed the version handshake
+	Handshaked int
+	// over INBOUND_MAX
+	Refused int
+}
+
+// nodes found through a seed, transitively by the gossip
+type SeedCount struct {
+	Queued int
+	Good   int
+	Dead   int
+}
+
+// max entries in Stats.TopCountries and Stats.TopASNs
+const TopGeoLimit = 10
+
+// good nodes count of a country or an ASN
+type GeoCount struct {
+	Name  string
+	Count int
+}
+
+// TopGeo returns the biggest counts, same counts are sorted by name
+func TopGeo(counts map[string]int) []GeoCount {
+	top := make([]GeoCount, 0, len(counts))
+	for name, cnt := range counts {
+		top = append(top, GeoCount{Name: name, Count: cnt})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > TopGeoLimit {
+		top = top[:TopGeoLimit]
+	}
+	return top
+}
+
+// spread of the good nodes over the netgroups, see node.NetGroup
+type Diversity struct {
+	Groups int
+	// nodes of the biggest group over all the nodes, 0 to 1
+	LargestShare float64
+	// Herfindahl index, the sum of the squared shares of the groups.
+	// 1 is a single group, 1/Groups is an even spread
+	HHI float64
+}
+
+// DiversityStats returns the zero Diversity without nodes
+func DiversityStats(groups map[string]int) Diversity {
+	total, largest := 0, 0
+	for _, cnt := range groups {
+		total += cnt
+		if cnt > largest {
+			largest = cnt
+		}
+	}
+	if total == 0 {
+		return Diversity{}
+	}
+	d := Diversity{Groups: len(groups), LargestShare: float64(largest) / float64(total)}
+	for _, cnt := range groups {
+		share := float64(cnt) / float64(total)
+		d.HHI += share * share
+	}
+	return d
+}
+
+// active connection for the diagnostics, see client.Connections
+type ConnInfo struct {
+	Endpoint string
+	// dialing, handshake or established
+	State    string
+	BytesIn  uint64
+	BytesOut uint64
+	// since the connector took the node
+	Age time.Duration
+}
+
+// peer kept connected by the monitor, see client.Monitored
+type MonitorInfo struct {
+	Endpoint string
+	RTT      time.Duration
+	// zero while connecting
+	Uptime time.Duration
+	// latest feefilter in sat/kvB, -1 if none came
+	FeeFilter int64
+	// inventory items announced per minute of the uptime
+	InvRate float64
+}
+
+// max nodes in Stats.TopNodes
+const TopNodesLimit = 10
+
+// good node info for the top nodes list
+type NodeSummary struct {
+	Endpoint  string
+	RTT       time.Duration
+	UserAgent string
+	Height    int32
+}
+
+// TopNodes returns the fastest nodes, nodes without latency are skipped
+func TopNodes(nodes []NodeSummary) []NodeSummary {
+	top := make([]NodeSummary, 0, len(nodes))
+	for _, n := range nodes {
+		if n.RTT > 0 {
+			top = append(top, n)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		return top[i].RTT < top[j].RTT
+	})
+	if len(top) > TopNodesLimit {
+		top = top[:TopNodesLimit]
+	}
+	return top
+}
+
+// heights above this are lies or bugs, about 190 years of blocks
+const MaxSaneHeight = 10_000_000
+
+// heights above the median by more than this are lies, a day of blocks
+const MaxHeightAhead = 144
+
+// HeightStats returns the max and the median of the advertised heights, the median
+// is the estimated tip. negative and absurdly large ones are skipped and the lying ones
+// do not raise the max, see Lying. false if none is left
+func HeightStats(heights []int32) (max, median int32, ok bool) {
+	sane := make([]int32, 0, len(heights))
+	for _, h := range heights {
+		if h >= 0 && h <= MaxSaneHeight {
+			sane = append(sane, h)
+		}
+	}
+	if len(sane) == 0 {
+		return 0, 0, false
+	}
+	sort.Slice(sane, func(i, j int) bool { return sane[i] < sane[j] })
+	median = sane[len(sane)/2]
+	for i := len(sane) - 1; i >= 0; i-- {
+		if !Lying(sane[i], median) {
+			return sane[i], median, true
+		}
+	}
+	return median, median, true
+}
+
+// Lying is a height that can't be true for the estimated tip
+func Lying(height, tip int32) bool {
+	return height < 0 || height > MaxSaneHeight || height > tip+MaxHeightAhead
+}
+
+// Stale is a height behind the estimated tip by more than the blocks
+func Stale(height, tip int32, blocks int) bool {
+	return height >= 0 && int64(height) < int64(tip)-int64(blocks)
+}
+
+// BIP133 feefilter min fee rates in sat/kvB across the peers that sent one
+type FeeStats struct {
+	Peers  int
+	Min    int64
+	Median int64
+	P90    int64
+}
+
+// FeeFilterStats returns the nearest rank percentiles of the fee rates, negative ones are
+// the peers that never sent a feefilter and are skipped, zero peers if none is left
+func FeeFilterStats(fees []int64) FeeStats {
+	sent := make([]int64, 0, len(fees))
+	for _, fee := range fees {
+		if fee >= 0 {
+			sent = append(sent, fee)
+		}
+	}
+	if len(sent) == 0 {
+		return FeeStats{}
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i] < sent[j] })
+	return FeeStats{
+		Peers:  len(sent),
+		Min:    sent[0],
+		Median: sent[len(sent)/2],
+		P90:    sent[(len(sent)*9+9)/10-1],
+	}
+}
+
+// bitcoind disconnects the peers with the clock off by more
+const MaxClockSkew = 70 * time.Minute
+
+// clock of the peers minus ours
+type SkewStats struct {
+	Peers  int
+	Median time.Duration
+	// off by more than MaxClockSkew either way
+	Off int
+}
+
+// ClockSkewStats returns the median skew, zero peers if none is known.
+// a median far from zero means our clock is off, not the peers
+func ClockSkewStats(skews []time.Duration) SkewStats {
+	if len(skews) == 0 {
+		return SkewStats{}
+	}
+	sorted := make([]time.Duration, len(skews))
+	copy(sorted, skews)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	s := SkewStats{Peers: len(sorted), Median: sorted[len(sorted)/2]}
+	for _, skew := range sorted {
+		if skew > MaxClockSkew || skew < -MaxClockSkew {
+			s.Off++
+		}
+	}
+	return s
+}
+
+// upper bounds of the latency buckets, the last bucket is everything above
+var LatencyBounds = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+// LatencyHistogram counts the latencies per bucket, zero latencies are skipped
+func LatencyHistogram(rtts []time.Duration) []int {
+	buckets := make([]int, len(LatencyBounds)+1)
+	for _, rtt := range rtts {
+		if rtt <= 0 {
+			continue
+		}
+		i := 0
+		for i < len(LatencyBounds) && rtt >= LatencyBounds[i] {
+			i++
+		}
+		buckets[i]++
+	}
+	return buckets
+}
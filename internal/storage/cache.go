@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nodes cache layout: cacheMagic, the uint16 version and the uint32 count,
+// then every endpoint as a uint8 length and the bytes followed by the int64
+// unix seconds it was last good. big endian. version 1 had no last good
+const (
+	cacheMagic    = "XRAYNODE"
+	cacheVersion  = 2
+	cacheEntryMin = 1 + 8
+)
+
+// errCacheStale is a missing cache, one of another version or older than the nodes file
+var errCacheStale = errors.New("nodes cache is stale")
+
+// CachePath is the binary copy of the nodes file, mainnet.json has mainnet.cache
+func CachePath(nodesPath string) string {
+	return strings.TrimSuffix(nodesPath, filepath.Ext(nodesPath)) + ".cache"
+}
+
+func writeCache(path string, nodes []SavedNode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cfg.FileMode)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	w.WriteString(cacheMagic)
+	var header [6]byte
+	binary.BigEndian.PutUint16(header[:2], cacheVersion)
+	binary.BigEndian.PutUint32(header[2:], uint32(len(nodes)))
+	w.Write(header[:])
+	var lastGood [8]byte
+	for _, n := range nodes {
+		// tor v3 with the brackets and the port is 70 bytes
+		if len(n.Endpoint) > 255 {
+			file.Close()
+			return fmt.Errorf("endpoint over 255 bytes: %.32s...", n.Endpoint)
+		}
+		w.WriteByte(byte(len(n.Endpoint)))
+		w.WriteString(n.Endpoint)
+		binary.BigEndian.PutUint64(lastGood[:], uint64(n.LastGood.Unix()))
+		w.Write(lastGood[:])
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readCache returns errCacheStale unless the cache is of this version
+// and not older than the nodes file
+func readCache(path, nodesPath string) ([]SavedNode, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errCacheStale
+	}
+	if err != nil {
+		return nil, err
+	}
+	if nodes, err := os.Stat(nodesPath); err == nil && nodes.ModTime().After(info.ModTime()) {
+		return nil, errCacheStale
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	header := make([]byte, len(cacheMagic)+6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if string(header[:len(cacheMagic)]) != cacheMagic {
+		return nil, fmt.Errorf("%s: not a nodes cache", path)
+	}
+	if binary.BigEndian.Uint16(header[len(cacheMagic):]) != cacheVersion {
+		return nil, errCacheStale
+	}
+	count := binary.BigEndian.Uint32(header[len(cacheMagic)+2:])
+	// an entry is at least the length byte and the last good,
+	// a corrupt count must not allocate over the file size
+	if max := (info.Size() - int64(len(header))) / cacheEntryMin; int64(count) > max {
+		return nil, fmt.Errorf("%s: %d entries in %d bytes", path, count, info.Size())
+	}
+	nodes := make([]SavedNode, 0, count)
+	buf := make([]byte, 255+8)
+	for i := uint32(0); i < count; i++ {
+		size, err := r.ReadByte()
+		if err == nil {
+			_, err = io.ReadFull(r, buf[:int(size)+8])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %v", path, i, err)
+		}
+		nodes = append(nodes, SavedNode{
+			Endpoint: string(buf[:size]),
+			LastGood: time.Unix(int64(binary.BigEndian.Uint64(buf[size:int(size)+8])), 0),
+		})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func savedNodes(count int, lastGood time.Time) []SavedNode {
+	nodes := make([]SavedNode, count)
+	for i := range nodes {
+		nodes[i] = SavedNode{
+			Endpoint: fmt.Sprintf("10.%d.%d.%d:8333", i>>16&0xff, i>>8&0xff, i&0xff),
+			LastGood: lastGood,
+		}
+	}
+	return nodes
+}
+
+// writeNodesFile writes the nodes file as Save does and the cache if asked
+func writeNodesFile(tb testing.TB, dir string, nodes []SavedNode, cache bool) string {
+	tb.Helper()
+	path := filepath.Join(dir, "mainnet.json")
+	endpoints := make([]string, len(nodes))
+	for i, n := range nodes {
+		endpoints[i] = n.Endpoint
+	}
+	data, err := json.MarshalIndent(endpoints, "", "  ")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	if cache {
+		if err := writeCache(CachePath(path), nodes); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestLoadCache(t *testing.T) {
+	lastGood := time.Now().Add(-time.Hour).Truncate(time.Second)
+	nodes := savedNodes(1000, lastGood)
+	// tor v3 in brackets with the port
+	nodes[0].Endpoint = "[" + strings.Repeat("a", 56) + ".onion]:8333"
+	path := writeNodesFile(t, t.TempDir(), nodes, true)
+	got, err := Load(nopLogger{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(nodes) {
+		t.Fatalf("loaded %d nodes, want %d", len(got), len(nodes))
+	}
+	for i := range got {
+		if got[i].Endpoint != nodes[i].Endpoint || !got[i].LastGood.Equal(lastGood) {
+			t.Fatalf("node %d = %+v, want %+v", i, got[i], nodes[i])
+		}
+	}
+}
+
+func TestLoadFallsBackToJSON(t *testing.T) {
+	lastGood := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, path string)
+	}{
+		{"no cache", func(t *testing.T, path string) {
+			if err := os.Remove(CachePath(path)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"other version", func(t *testing.T, path string) {
+			data, err := os.ReadFile(CachePath(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			binary.BigEndian.PutUint16(data[len(cacheMagic):], cacheVersion+1)
+			if err := os.WriteFile(CachePath(path), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"older than the nodes file", func(t *testing.T, path string) {
+			old := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(CachePath(path), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := savedNodes(10, lastGood)
+			path := writeNodesFile(t, t.TempDir(), nodes, true)
+			tt.modify(t, path)
+			got, err := Load(nopLogger{}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the json has no last good
+			want := savedNodes(10, time.Time{})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loaded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadCacheCorrupt(t *testing.T) {
+	header := len(cacheMagic) + 6
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		// the count says 10, the entries end after the header
+		{"truncated entries", func(data []byte) []byte { return data[:header] }},
+		{"truncated header", func(data []byte) []byte { return data[:header-2] }},
+		{"not a cache", func(data []byte) []byte { return append([]byte("NOTACACH"), data[len(cacheMagic):]...) }},
+		// 4G entries must not be allocated up front
+		{"count over the file size", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[len(cacheMagic)+2:], 0xffffffff)
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeNodesFile(t, t.TempDir(), savedNodes(10, time.Now()), true)
+			data, err := os.ReadFile(CachePath(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(CachePath(path), tt.corrupt(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readCache(CachePath(path), path); err == nil {
+				t.Error("corrupt cache read without an error")
+			}
+			// Load takes the json then
+			nodes, err := Load(nopLogger{}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) != 10 {
+				t.Errorf("loaded %d nodes, want 10", len(nodes))
+			}
+		})
+	}
+}
+
+// resume of a 500k nodes file from the json against the binary cache
+func BenchmarkLoad500k(b *testing.B) {
+	nodes := savedNodes(500000, time.Now())
+	for _, cache := range []bool{false, true} {
+		name := "json"
+		if cache {
+			name = "cache"
+		}
+		b.Run(name, func(b *testing.B) {
+			path := writeNodesFile(b, b.TempDir(), nodes, cache)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				loaded, err := Load(nopLogger{}, path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(loaded) != len(nodes) {
+					b.Fatalf("loaded %d nodes, want %d", len(loaded), len(nodes))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/seeds"
+)
+
+// Format of a crawl file, only jsonl and csv keep the node details
+type Format string
+
+const (
+	// endpoints array, the nodes file
+	FormatJSON Format = "json"
+	// the nodes log and STREAM lines
+	FormatJSONL Format = "jsonl"
+	// the nodes log fields as columns, endpoint first
+	FormatCSV Format = "csv"
+	// one endpoint per line like SEED_FILE
+	FormatText Format = "txt"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatJSON, FormatJSONL, FormatCSV, FormatText:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, one of json, jsonl, csv or txt", s)
+}
+
+func (f Format) detailed() bool {
+	return f == FormatJSONL || f == FormatCSV
+}
+
+// Filter of Convert, zero fields do not filter. the nodes without a pong
+// or the details do not pass the latency and the services filters
+type Filter struct {
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// all of these service bits
+	Services uint64
+	// ipv4, ipv6 or onion
+	Network node.AddrType
+	// the fastest ones after the other filters
+	Top int
+}
+
+// Convert reads the crawl file in the format, detected by the content if empty,
+// and writes the nodes passing the filter in another one. returns the written nodes
+func Convert(path string, from, to Format, filter Filter, w io.Writer) (int, error) {
+	lines, format, err := readNodes(path, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	lines = filter.apply(lines, format.detailed())
+	if err := writeNodes(w, to, lines); err != nil {
+		return 0, err
+	}
+	return len(lines), nil
+}
+
+func (f Filter) apply(lines []nodeLine, detailed bool) []nodeLine {
+	byLatency := f.MinLatency > 0 || f.MaxLatency > 0 || f.Top > 0
+	if !detailed && (byLatency || f.Services != 0) {
+		return nil
+	}
+	kept := make([]nodeLine, 0, len(lines))
+	for _, l := range lines {
+		latency := time.Duration(l.LatencyMs) * time.Millisecond
+		switch {
+		case byLatency && latency == 0:
+		case f.MinLatency > 0 && latency < f.MinLatency:
+		case f.MaxLatency > 0 && latency > f.MaxLatency:
+		case l.Services&f.Services != f.Services:
+		case f.Network != "" && node.AddrTypeOf(l.Endpoint) != f.Network:
+		default:
+			kept = append(kept, l)
+		}
+	}
+	if f.Top > 0 {
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].LatencyMs < kept[j].LatencyMs })
+		if len(kept) > f.Top {
+			kept = kept[:f.Top]
+		}
+	}
+	return kept
+}
+
+// readNodes returns the nodes of the file and its format, only the endpoints are set
+// unless the format is detailed
+func readNodes(path string, format Format) ([]nodeLine, Format, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if format == "" {
+		format = detectFormat(trimmed)
+	}
+	var lines []nodeLine
+	switch format {
+	case FormatJSON:
+		if len(trimmed) == 0 {
+			break
+		}
+		var endpoints []string
+		if err := json.Unmarshal(trimmed, &endpoints); err != nil {
+			return nil, "", err
+		}
+		lines = endpointLines(endpoints)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		i := 0
+		for scanner.Scan() {
+			i++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var l nodeLine
+			if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+				return nil, "", fmt.Errorf("line %d: %v", i, err)
+			}
+			lines = append(lines, l)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, "", err
+		}
+	case FormatCSV:
+		lines, err = readCSV(bytes.NewReader(trimmed))
+		if err != nil {
+			return nil, "", err
+		}
+	case FormatText:
+		// malformed lines are skipped like in SEED_FILE
+		endpoints, err := seeds.FromFile(path)
+		var malformed *seeds.MalformedError
+		if err != nil && !errors.As(err, &malformed) {
+			return nil, "", err
+		}
+		lines = endpointLines(endpoints)
+	}
+	return lines, format, nil
+}
+
+// by the first byte or the csv header
+func detectFormat(data []byte) Format {
+	switch {
+	case len(data) == 0:
+		return FormatText
+	case data[0] == '[':
+		return FormatJSON
+	case data[0] == '{':
+		return FormatJSONL
+	case bytes.HasPrefix(data, []byte(csvColumns[0]+",")):
+		return FormatCSV
+	}
+	return FormatText
+}
+
+func endpointLines(endpoints []string) []nodeLine {
+	lines := make([]nodeLine, len(endpoints))
+	for i, endpoint := range endpoints {
+		lines[i] = nodeLine{Endpoint: endpoint}
+	}
+	return lines
+}
+
+func writeNodes(w io.Writer, format Format, lines []nodeLine) error {
+	switch format {
+	case FormatJSON:
+		endpoints := make([]string, len(lines))
+		for i, l := range lines {
+			endpoints[i] = l.Endpoint
+		}
+		data, err := json.MarshalIndent(endpoints, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal nodes: %v", err)
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, l := range lines {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		return writeCSV(w, lines)
+	case FormatText:
+		for _, l := range lines {
+			if _, err := fmt.Fprintln(w, l.Endpoint); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// the json names of the nodeLine fields in their order and whether the cell is
+// a quoted json string, the rest is taken as json as is
+var csvColumns, csvQuoted = nodeLineColumns()
+
+func nodeLineColumns() ([]string, map[string]bool) {
+	t := reflect.TypeOf(nodeLine{})
+	columns := make([]string, 0, t.NumField())
+	quoted := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		columns = append(columns, name)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		// time marshals as a json string
+		quoted[name] = ft.Kind() == reflect.String || ft == reflect.TypeOf(time.Time{})
+	}
+	return columns, quoted
+}
+
+// the cells are the json values of the fields, unquoted strings, empty for the omitted ones
+func writeCSV(w io.Writer, lines []nodeLine) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvColumns); err != nil {
+		return err
+	}
+	row := make([]string, len(csvColumns))
+	for _, l := range lines {
+		data, err := json.Marshal(l)
+		if err != nil {
+			return fmt.Errorf("failed to marshal node: %v", err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("failed to marshal node: %v", err)
+		}
+		for i, col := range csvColumns {
+			raw, ok := fields[col]
+			switch {
+			case !ok || string(raw) == "null":
+				row[i] = ""
+			case csvQuoted[col]:
+				var s string
+				if err := json.Unmarshal(raw, &s); err != nil {
+					return fmt.Errorf("failed to marshal node: %v", err)
+				}
+				row[i] = s
+			default:
+				row[i] = string(raw)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// columns by the header, unknown ones are skipped so older files still read
+func readCSV(r io.Reader) ([]nodeLine, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lines []nodeLine
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]json.RawMessage, len(row))
+		for i, cell := range row {
+			if cell == "" || i >= len(header) {
+				continue
+			}
+			quoted, known := csvQuoted[header[i]]
+			if !known {
+				continue
+			}
+			if quoted {
+				fields[header[i]], _ = json.Marshal(cell)
+			} else {
+				fields[header[i]] = json.RawMessage(cell)
+			}
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", len(lines)+2, err)
+		}
+		var l nodeLine
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("line %d: %v", len(lines)+2, err)
+		}
+		lines = append(lines, l)
+	}
+}
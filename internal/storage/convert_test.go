@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeCrawlFile(tb testing.TB, dir, name, data string) string {
+	tb.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// convert the file and write the result next to it
+func convertFile(tb testing.TB, path string, to Format) string {
+	tb.Helper()
+	var out bytes.Buffer
+	if _, err := Convert(path, "", to, Filter{}, &out); err != nil {
+		tb.Fatal(err)
+	}
+	return writeCrawlFile(tb, filepath.Dir(path), filepath.Base(path)+"."+string(to), out.String())
+}
+
+// the crawl files keep their data through csv
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	advertised := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	detailed := []nodeLine{
+		{
+			Endpoint: "1.2.3.4:8333", Version: 70016, UserAgent: "/Satoshi:27.0.0/", Services: 1033,
+			Height: 840000, LatencyMs: 42, Stale: true, ClockSkewMs: -1500, Kind: "full",
+			AnnounceCount: 3, FirstFrom: "5.6.7.8:8333", Seed: "seed.bitcoin.sipa.be", Depth: 2,
+			Advertised: &advertised, SupportsV2: true, Confirmations: 2, Chain: "main",
+			OurUserAgent: "/xray:1.0/", Country: "DE", ASN: 3320, ASName: "DTAG, \"quoted\"",
+			Seen: time.Date(2024, 3, 1, 12, 5, 0, 0, time.UTC),
+		},
+		{Endpoint: "[2001:db8::1]:8333", UserAgent: "/btcd:0.24.0/", Rejected: "old version", PeerReject: "dup"},
+	}
+
+	t.Run("jsonl", func(t *testing.T) {
+		var in bytes.Buffer
+		if err := writeNodes(&in, FormatJSONL, detailed); err != nil {
+			t.Fatal(err)
+		}
+		path := writeCrawlFile(t, dir, "nodes.jsonl", in.String())
+		csvPath := convertFile(t, path, FormatCSV)
+		back, format, err := readNodes(convertFile(t, csvPath, FormatJSONL), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != FormatJSONL {
+			t.Errorf("format %s, want %s", format, FormatJSONL)
+		}
+		if !reflect.DeepEqual(back, detailed) {
+			t.Errorf("round trip\ngot  %+v\nwant %+v", back, detailed)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		path := writeCrawlFile(t, dir, "nodes.json", `["1.2.3.4:8333", "[2001:db8::1]:8333", "example.onion:8333"]`)
+		want, err := os.ReadFile(convertFile(t, path, FormatJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(convertFile(t, convertFile(t, path, FormatCSV), FormatJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("round trip\ngot  %s\nwant %s", got, want)
+		}
+	})
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	defer func(port uint16) { cfg.NodesPort = port }(cfg.NodesPort)
+	cfg.NodesPort = 8333
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"1.2.3.4:8333", "1.2.3.4:8333"},
+		{"1.2.3.4", "1.2.3.4:8333"},
+		{"[1.2.3.4]:8333", "1.2.3.4:8333"},
+		{"[::ffff:1.2.3.4]:8333", "1.2.3.4:8333"},
+		{"::ffff:1.2.3.4", "1.2.3.4:8333"},
+		{"[2001:0db8:0000::0001]:18333", "[2001:db8::1]:18333"},
+		{"[2001:db8::1]", "[2001:db8::1]:8333"},
+		{"Example.ONION:8333", "example.onion:8333"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEndpoint(tt.endpoint); got != tt.want {
+			t.Errorf("NormalizeEndpoint(%s) = %s, want %s", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := writeCrawlFile(t, dir, "old.jsonl",
+		`{"endpoint":"1.2.3.4:8333","user_agent":"/Satoshi:26.0.0/","services":1033}
+{"endpoint":"[::ffff:5.6.7.8]:8333","user_agent":"/Satoshi:27.0.0/","services":1033}
+{"endpoint":"9.9.9.9:8333","user_agent":"/Satoshi:27.0.0/","services":1033}
+`)
+	newPath := writeCrawlFile(t, dir, "new.jsonl",
+		`{"endpoint":"1.2.3.4:8333","user_agent":"/Satoshi:27.0.0/","services":1033}
+{"endpoint":"5.6.7.8:8333","user_agent":"/Satoshi:27.0.0/","services":1033}
+{"endpoint":"[2001:db8::1]:8333","user_agent":"/Satoshi:27.0.0/","services":1033}
+`)
+	res, err := Diff(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DiffResult{
+		Old:     3,
+		New:     3,
+		Added:   []string{"[2001:db8::1]:8333"},
+		Removed: []string{"9.9.9.9:8333"},
+		Changed: []Change{{Endpoint: "1.2.3.4:8333", OldUserAgent: "/Satoshi:26.0.0/", NewUserAgent: "/Satoshi:27.0.0/"}},
+		Churn:   50,
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("Diff\ngot  %+v\nwant %+v", res, want)
+	}
+
+	// the endpoints only, no changes without the details
+	jsonPath := writeCrawlFile(t, dir, "old.json", `["1.2.3.4", "[::ffff:5.6.7.8]:8333", "9.9.9.9:8333"]`)
+	res, err = Diff(jsonPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Added) != 1 || len(res.Removed) != 1 || len(res.Changed) != 0 {
+		t.Errorf("Diff of json: added %v, removed %v, changed %v", res.Added, res.Removed, res.Changed)
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// DiffResult is the churn between two crawls, endpoints as in the new file
+// or in the old one for the removed, all sorted
+type DiffResult struct {
+	Old     int      `json:"old"`
+	New     int      `json:"new"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	// nodes in both with another user agent or services, only if both files have the details
+	Changed []Change `json:"changed"`
+	// added plus removed of the nodes in any of the files, in percent
+	Churn float64 `json:"churn"`
+}
+
+type Change struct {
+	Endpoint     string `json:"endpoint"`
+	OldUserAgent string `json:"old_user_agent,omitempty"`
+	NewUserAgent string `json:"new_user_agent,omitempty"`
+	OldServices  string `json:"old_services,omitempty"`
+	NewServices  string `json:"new_services,omitempty"`
+}
+
+// Diff compares two crawl files of any format by the normalized endpoint
+func Diff(oldPath, newPath string) (*DiffResult, error) {
+	oldNodes, oldFormat, err := readNodes(oldPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", oldPath, err)
+	}
+	newNodes, newFormat, err := readNodes(newPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", newPath, err)
+	}
+	detailed := oldFormat.detailed() && newFormat.detailed()
+	oldSet := nodeSet(oldNodes)
+	newSet := nodeSet(newNodes)
+	res := &DiffResult{Old: len(oldSet), New: len(newSet), Added: []string{}, Removed: []string{}, Changed: []Change{}}
+	for key, n := range newSet {
+		prev, ok := oldSet[key]
+		if !ok {
+			res.Added = append(res.Added, n.Endpoint)
+			continue
+		}
+		if !detailed {
+			continue
+		}
+		if prev.UserAgent == n.UserAgent && prev.Services == n.Services {
+			continue
+		}
+		change := Change{Endpoint: n.Endpoint}
+		if prev.UserAgent != n.UserAgent {
+			change.OldUserAgent, change.NewUserAgent = prev.UserAgent, n.UserAgent
+		}
+		if prev.Services != n.Services {
+			change.OldServices = wire.ServiceFlag(prev.Services).String()
+			change.NewServices = wire.ServiceFlag(n.Services).String()
+		}
+		res.Changed = append(res.Changed, change)
+	}
+	for key, n := range oldSet {
+		if _, ok := newSet[key]; !ok {
+			res.Removed = append(res.Removed, n.Endpoint)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	sort.Slice(res.Changed, func(i, j int) bool { return res.Changed[i].Endpoint < res.Changed[j].Endpoint })
+	if union := res.Old + len(res.Added); union > 0 {
+		res.Churn = float64(len(res.Added)+len(res.Removed)) * 100 / float64(union)
+	}
+	return res, nil
+}
+
+// human readable version, the counts and the changed nodes
+func (r *DiffResult) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "old:     %d\n", r.Old)
+	fmt.Fprintf(w, "new:     %d\n", r.New)
+	fmt.Fprintf(w, "added:   %d\n", len(r.Added))
+	fmt.Fprintf(w, "removed: %d\n", len(r.Removed))
+	fmt.Fprintf(w, "changed: %d\n", len(r.Changed))
+	fmt.Fprintf(w, "churn:   %.1f%%\n", r.Churn)
+	for _, c := range r.Changed {
+		fmt.Fprintf(w, "  %s", c.Endpoint)
+		if c.NewUserAgent != "" || c.OldUserAgent != "" {
+			fmt.Fprintf(w, "  %s -> %s", c.OldUserAgent, c.NewUserAgent)
+		}
+		if c.NewServices != "" {
+			fmt.Fprintf(w, "  %s -> %s", c.OldServices, c.NewServices)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// by the normalized endpoint, the nodes log may repeat a node, the last one wins
+func nodeSet(nodes []nodeLine) map[string]nodeLine {
+	set := make(map[string]nodeLine, len(nodes))
+	for _, n := range nodes {
+		set[NormalizeEndpoint(n.Endpoint)] = n
+	}
+	return set
+}
+
+// NormalizeEndpoint is the endpoint in one form, [1.2.3.4]:8333, 1.2.3.4:8333, ::ffff:1.2.3.4
+// and a bare 1.2.3.4 are the same node on mainnet. the ipv6 in its shortest form and the host
+// names in lower case
+func NormalizeEndpoint(endpoint string) string {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = strings.Trim(endpoint, "[]"), strconv.Itoa(int(cfg.NodesPort))
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// SaveDiff writes added.json, removed.json and changed.json to the dir
+func SaveDiff(dir string, res *DiffResult) error {
+	if err := os.MkdirAll(dir, cfg.DirMode); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	for name, v := range map[string]interface{}{"added.json": res.Added, "removed.json": res.Removed, "changed.json": res.Changed} {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, cfg.FileMode); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -1,17 +1,26 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math/bits"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/1F47E/go-btc-xray/internal/client/node"
 	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/geo"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/report"
 )
 
-var cfg = config.New()
+var cfg = config.Shared()
 
+// Bootstrap creates the logs and data dirs and checks they are writable,
+// called on startup so the saves do not fail later
 func Bootstrap() error {
 	err := createDir(cfg.LogsDir)
 	if err != nil {
@@ -25,37 +34,409 @@ func Bootstrap() error {
 }
 
 func createDir(dir string) error {
-	return os.MkdirAll(dir, 0755)
+	err := os.MkdirAll(dir, cfg.DirMode)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, ".xray-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
 }
 
-func Load(filename string) ([]string, error) {
-	var ret []string
+// SavedNode is an entry of the nodes file and when it was last good
+type SavedNode struct {
+	Endpoint string
+	LastGood time.Time
+}
+
+// Load returns the nodes of the nodes file, read from its cache while the cache
+// is of this version and up to date, see NODES_CACHE. the json has no last good,
+// it stays zero, a rewritten file must not make its nodes look recently good
+func Load(log logger.Interface, filename string) ([]SavedNode, error) {
+	nodes, err := readCache(CachePath(filename), filename)
+	if err == nil {
+		return nodes, nil
+	}
+	if cfg.NodesCache {
+		log.With(logger.FieldModule, "storage").Debugf("nodes cache not used, reading %s: %v", filename, err)
+	}
 	// read from json
 	fData, err := os.ReadFile(filename)
 	if err != nil {
-		return ret, err
+		return nil, err
 	}
-	err = json.Unmarshal(fData, &ret)
+	var endpoints []string
+	err = json.Unmarshal(fData, &endpoints)
 	if err != nil {
-		return ret, err
+		return nil, err
+	}
+	nodes = make([]SavedNode, len(endpoints))
+	for i, e := range endpoints {
+		nodes[i] = SavedNode{Endpoint: e}
 	}
-	return ret, nil
+	return nodes, nil
 }
 
-func Save(nodes []*node.Node) error {
+// Save writes the best nodes first, see rank, then the carried ones
+// of the previous runs, see RETENTION. MAX_SAVED caps them all
+func Save(nodes []*node.Node, carried []SavedNode) error {
 	path := filepath.Join(cfg.DataDir, cfg.NodesFilename)
+	nodes = rank(nodes)
+	now := time.Now()
+	saved := make([]SavedNode, 0, len(nodes)+len(carried))
+	for _, n := range nodes {
+		saved = append(saved, SavedNode{Endpoint: n.EndpointSafe(), LastGood: now}) // [addr]:port for ipv6
+	}
+	saved = append(saved, carried...)
+	if cfg.MaxSaved > 0 && len(saved) > cfg.MaxSaved {
+		saved = saved[:cfg.MaxSaved]
+	}
 	// save nodes as json
-	fData := make([]string, len(nodes))
-	for i, n := range nodes {
-		fData[i] = n.EndpointSafe() // [addr]:port for ipv6
+	fData := make([]string, len(saved))
+	for i, n := range saved {
+		fData[i] = n.Endpoint
 	}
 	fDataJson, err := json.MarshalIndent(fData, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal nodes: %v", err)
 	}
-	err = os.WriteFile(path, fDataJson, 0644)
+	err = os.WriteFile(path, fDataJson, cfg.FileMode)
 	if err != nil {
 		return fmt.Errorf("failed to write nodes: %v", err)
 	}
+	// written after the json so it is not older than it
+	if cfg.NodesCache {
+		if err := writeCache(CachePath(path), saved); err != nil {
+			return fmt.Errorf("failed to write nodes cache: %v", err)
+		}
+	}
 	return nil
 }
+
+// sorted copy by the SAVE_SORT keys capped to MAX_SAVED,
+// ties keep the order the nodes were found
+func rank(nodes []*node.Node) []*node.Node {
+	ranked := append([]*node.Node(nil), nodes...)
+	if len(cfg.SaveSort) > 0 {
+		sort.SliceStable(ranked, func(i, j int) bool {
+			a, b := ranked[i], ranked[j]
+			for _, key := range cfg.SaveSort {
+				switch key {
+				case config.SortLatency:
+					ra, rb := a.RTT(), b.RTT()
+					if ra != rb {
+						// zero is no pong yet
+						return rb == 0 || (ra != 0 && ra < rb)
+					}
+				case config.SortServices:
+					sa, sb := bits.OnesCount64(uint64(a.Services())), bits.OnesCount64(uint64(b.Services()))
+					if sa != sb {
+						return sa > sb
+					}
+				case config.SortHeight:
+					if a.Height() != b.Height() {
+						return a.Height() > b.Height()
+					}
+				}
+			}
+			return false
+		})
+	}
+	if cfg.MaxSaved > 0 && len(ranked) > cfg.MaxSaved {
+		ranked = ranked[:cfg.MaxSaved]
+	}
+	return ranked
+}
+
+// OutputFiles returns the paths the crawl wrote to with the current config,
+// the ones not created are left out. the summary and the history are written with the list
+func OutputFiles() []string {
+	files := []string{
+		filepath.Join(cfg.DataDir, cfg.SummaryFilename+".json"),
+		filepath.Join(cfg.DataDir, cfg.SummaryFilename+".txt"),
+	}
+	if cfg.HistoryFilename != "" {
+		files = append(files, filepath.Join(cfg.DataDir, cfg.HistoryFilename))
+	}
+	names := []string{cfg.NodesFilename, cfg.NodesLogFilename, cfg.RejectedLogFilename}
+	if cfg.NodesCache {
+		names = append(names, filepath.Base(CachePath(cfg.NodesFilename)))
+	}
+	if cfg.StreamFilename != "" {
+		names = append(names, cfg.StreamFilename)
+	}
+	if cfg.RecordGraph {
+		names = append(names, cfg.GraphFilename)
+	}
+	for _, name := range names {
+		path := filepath.Join(cfg.DataDir, name)
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// save the crawl summary as json and as plain text next to the nodes file
+func SaveSummary(s *report.Summary) error {
+	base := filepath.Join(cfg.DataDir, cfg.SummaryFilename)
+	fDataJson, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %v", err)
+	}
+	err = os.WriteFile(base+".json", fDataJson, cfg.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to write summary: %v", err)
+	}
+	var txt bytes.Buffer
+	s.WriteText(&txt)
+	err = os.WriteFile(base+".txt", txt.Bytes(), cfg.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to write summary: %v", err)
+	}
+	return nil
+}
+
+// append the summary as a single json line to the history file
+func AppendHistory(s *report.Summary) error {
+	path := filepath.Join(cfg.DataDir, cfg.HistoryFilename)
+	line, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, cfg.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write history: %v", err)
+	}
+	return nil
+}
+
+// NodesLog appends good or rejected nodes as json lines while crawling,
+// cheaper than rewriting the whole nodes file on every save
+type NodesLog struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+type nodeLine struct {
+	Endpoint  string `json:"endpoint"`
+	Version   int32  `json:"version"`
+	UserAgent string `json:"user_agent"`
+	Services  uint64 `json:"services"`
+	Height    int32  `json:"height"`
+	// ping round trip, 0 without a pong
+	LatencyMs int64 `json:"latency_ms"`
+	// behind the estimated tip by STALE_BLOCKS or far above it when appended
+	Stale bool `json:"stale,omitempty"`
+	Lying bool `json:"lying,omitempty"`
+	// peer clock minus ours at the version, see node.ClockSkew
+	ClockSkewMs int64 `json:"clock_skew_ms"`
+	// full, limited (pruned) or neither by the services
+	Kind string `json:"kind"`
+	// announcements of the address until it was found good and the first peer
+	AnnounceCount int    `json:"announce_count"`
+	FirstFrom     string `json:"first_from,omitempty"`
+	// dns seed or seed list the node came through, see client.Seed
+	Seed string `json:"seed,omitempty"`
+	// hops from the seeds
+	Depth int32 `json:"depth"`
+	// latest time a peer saw the node by the addr messages
+	Advertised *time.Time `json:"advertised,omitempty"`
+	SupportsV2 bool       `json:"supports_v2,omitempty"`
+	// handshakes before the node was reported, over 1 with CONFIRM_PROBES
+	Confirmations int32 `json:"confirmations"`
+	// answer to getheaders from CHAIN_CHECKPOINT, see node.ChainStatus
+	Chain        string `json:"chain,omitempty"`
+	OurUserAgent string `json:"our_user_agent"`
+	Rejected     string `json:"rejected,omitempty"`
+	// last reject message of the node
+	PeerReject string `json:"peer_reject,omitempty"`
+	// with GEO_FILE or GEO_MMDB, geo.AnonymousNetwork for the onion and i2p nodes
+	Country string    `json:"country,omitempty"`
+	ASN     int       `json:"asn,omitempty"`
+	ASName  string    `json:"as_name,omitempty"`
+	Seen    time.Time `json:"seen"`
+}
+
+// NewNodesLog appends to the log of the previous runs, see rotateNodesLog
+func NewNodesLog(log logger.Interface) (*NodesLog, error) {
+	return openNodesLog(log, cfg.NodesLogFilename)
+}
+
+// NewRejectedLog appends to the log of the rejected nodes of the previous runs
+func NewRejectedLog(log logger.Interface) (*NodesLog, error) {
+	return openNodesLog(log, cfg.RejectedLogFilename)
+}
+
+func openNodesLog(log logger.Interface, filename string) (*NodesLog, error) {
+	path := filepath.Join(cfg.DataDir, filename)
+	if err := rotateNodesLog(log.With(logger.FieldModule, "storage"), path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, cfg.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nodes log: %v", err)
+	}
+	return &NodesLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// rotateNodesLog shifts the log over NODES_LOG_MAX_SIZE to path.1 and the older ones
+// by one, the ones above LOG_MAX_FILES are removed
+func rotateNodesLog(log logger.Interface, path string) error {
+	if cfg.NodesLogMaxSizeMB == 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < int64(cfg.NodesLogMaxSizeMB)<<20 {
+		return nil
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", path, cfg.LogMaxFiles))
+	for i := cfg.LogMaxFiles - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if cfg.LogMaxFiles > 0 {
+		err = os.Rename(path, path+".1")
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate nodes log: %v", err)
+	}
+	log.Infof("%s rotated at %dMb", path, info.Size()>>20)
+	return nil
+}
+
+func (l *NodesLog) Append(nodes []*node.Node) error {
+	now := time.Now()
+	for _, n := range nodes {
+		err := l.enc.Encode(newNodeLine(n, now))
+		if err != nil {
+			return fmt.Errorf("failed to write nodes log: %v", err)
+		}
+	}
+	return nil
+}
+
+func newNodeLine(n *node.Node, seen time.Time) nodeLine {
+	return nodeLine{
+		Endpoint:      n.EndpointSafe(),
+		Version:       n.Version(),
+		UserAgent:     n.UserAgent(),
+		Services:      uint64(n.Services()),
+		Height:        n.Height(),
+		LatencyMs:     n.RTT().Milliseconds(),
+		Stale:         n.Stale(),
+		Lying:         n.Lying(),
+		ClockSkewMs:   clockSkewMs(n),
+		Kind:          string(n.Kind()),
+		AnnounceCount: n.AnnounceCount(),
+		FirstFrom:     n.FirstFrom(),
+		Seed:          n.Origin(),
+		Depth:         n.Depth(),
+		Advertised:    advertised(n),
+		SupportsV2:    n.SupportsV2(),
+		Confirmations: n.Confirmations(),
+		Chain:         string(n.Chain()),
+		OurUserAgent:  n.OurUserAgent(),
+		Rejected:      string(n.Rejected()),
+		PeerReject:    n.PeerReject(),
+		Country:       n.Geo().Country,
+		ASN:           n.Geo().ASN,
+		ASName:        asName(n.Geo()),
+		Seen:          seen,
+	}
+}
+
+// the AS organization without the number, the anonymous network for the onions
+func asName(info geo.Info) string {
+	if info.Anonymous {
+		return geo.AnonymousNetwork
+	}
+	return info.Org
+}
+
+// AppendJSONL appends the node as a json line in the nodes log format,
+// the file is opened for every node and never truncated so it can be tailed across runs
+func AppendJSONL(path string, n *node.Node) error {
+	line, err := json.Marshal(newNodeLine(n, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to marshal node: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, cfg.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write stream: %v", err)
+	}
+	return nil
+}
+
+func advertised(n *node.Node) *time.Time {
+	t := n.Advertised()
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func clockSkewMs(n *node.Node) int64 {
+	skew, _ := n.ClockSkew()
+	return skew.Milliseconds()
+}
+
+func (l *NodesLog) Close() error {
+	return l.file.Close()
+}
+
+// GraphLog appends the discovery edges as json lines, one per address of every addr message.
+// the client keeps only the first sender of an address, the full graph is here
+type GraphLog struct {
+	file *os.File
+	buf  bytes.Buffer
+}
+
+type edgeLine struct {
+	Source string    `json:"source"`
+	Target string    `json:"target"`
+	Time   time.Time `json:"time"`
+}
+
+// NewGraphLog truncates the previous crawl graph
+func NewGraphLog() (*GraphLog, error) {
+	path := filepath.Join(cfg.DataDir, cfg.GraphFilename)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cfg.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open graph: %v", err)
+	}
+	return &GraphLog{file: file}, nil
+}
+
+// Append writes the edges of the message at once
+func (l *GraphLog) Append(source string, targets []string, at time.Time) error {
+	l.buf.Reset()
+	enc := json.NewEncoder(&l.buf)
+	for _, target := range targets {
+		if err := enc.Encode(edgeLine{Source: source, Target: target, Time: at}); err != nil {
+			return fmt.Errorf("failed to marshal edge: %v", err)
+		}
+	}
+	if _, err := l.file.Write(l.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write graph: %v", err)
+	}
+	return nil
+}
+
+func (l *GraphLog) Close() error {
+	return l.file.Close()
+}
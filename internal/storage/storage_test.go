@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/1F47E/go-btc-xray/internal/client/node"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(args ...interface{})                             {}
+func (nopLogger) Debugf(format string, args ...interface{})             {}
+func (nopLogger) Info(args ...interface{})                              {}
+func (nopLogger) Infof(format string, args ...interface{})              {}
+func (nopLogger) Warn(args ...interface{})                              {}
+func (nopLogger) Warnf(format string, args ...interface{})              {}
+func (nopLogger) Errorf(format string, args ...interface{})             {}
+func (nopLogger) Fatal(args ...interface{})                             {}
+func (l nopLogger) With(key string, value interface{}) logger.Interface { return l }
+
+// testNodes are distinct ipv4 nodes on the default port
+func testNodes(count int) []*node.Node {
+	nodes := make([]*node.Node, count)
+	for i := range nodes {
+		nodes[i] = node.NewNode(nopLogger{}, fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff), nil)
+	}
+	return nodes
+}
+
+// setDataDir points the data dir to a temp dir for the test
+func setDataDir(tb testing.TB) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	setDirs(tb, cfg.LogsDir, dir)
+	return dir
+}
+
+// setDirs points the logs and the data dirs to the paths for the test
+func setDirs(tb testing.TB, logsDir, dataDir string) {
+	tb.Helper()
+	prevLogs, prevData := cfg.LogsDir, cfg.DataDir
+	cfg.LogsDir, cfg.DataDir = logsDir, dataDir
+	tb.Cleanup(func() { cfg.LogsDir, cfg.DataDir = prevLogs, prevData })
+}
+
+func TestBootstrapMissingDirs(t *testing.T) {
+	tmp := t.TempDir()
+	setDirs(t, filepath.Join(tmp, "run", "logs"), filepath.Join(tmp, "crawl", "mainnet", "data"))
+	if err := Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{cfg.LogsDir, cfg.DataDir} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.IsDir() {
+			t.Errorf("%s is not a dir", dir)
+		}
+		// the writability probe is removed
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) > 0 {
+			t.Errorf("%s is not empty: %s", dir, entries[0].Name())
+		}
+	}
+	// the first save does not fail on the fresh dir
+	if err := Save(testNodes(3), nil); err != nil {
+		t.Errorf("save: %v", err)
+	}
+	// existing dirs are fine
+	if err := Bootstrap(); err != nil {
+		t.Errorf("bootstrap again: %v", err)
+	}
+}
+
+func TestBootstrapDirIsFile(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "data")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name             string
+		logsDir, dataDir string
+		want             string
+	}{
+		{"data dir", filepath.Join(tmp, "logs"), file, "failed to create data dir"},
+		{"under a file", filepath.Join(tmp, "logs"), filepath.Join(file, "mainnet"), "failed to create data dir"},
+		{"logs dir", file, filepath.Join(tmp, "ok"), "failed to create logs dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setDirs(t, tt.logsDir, tt.dataDir)
+			err := Bootstrap()
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateDirNotWritable(t *testing.T) {
+	readOnly := filepath.Join(t.TempDir(), "ro")
+	if err := os.Mkdir(readOnly, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dir  string
+		skip string
+	}{
+		{"read only dir", readOnly, "root writes to the read only dirs"},
+		// exists and nobody can create the files there
+		{"proc", "/proc", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.skip != "" && os.Geteuid() == 0 {
+				t.Skip(tt.skip)
+			}
+			if _, err := os.Stat(tt.dir); err != nil {
+				t.Skip(err)
+			}
+			err := createDir(tt.dir)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !strings.Contains(err.Error(), "is not writable") {
+				t.Errorf("error %q does not contain %q", err, "is not writable")
+			}
+		})
+	}
+}
+
+// the nodes log keeps the previous runs and is rotated over NODES_LOG_MAX_SIZE
+func TestNodesLogAppendRotate(t *testing.T) {
+	dir := setDataDir(t)
+	defer func(size, files int) {
+		cfg.NodesLogMaxSizeMB, cfg.LogMaxFiles = size, files
+	}(cfg.NodesLogMaxSizeMB, cfg.LogMaxFiles)
+	cfg.NodesLogMaxSizeMB, cfg.LogMaxFiles = 0, 2
+	path := filepath.Join(dir, cfg.NodesLogFilename)
+	run := func(count int) {
+		t.Helper()
+		l, err := NewNodesLog(nopLogger{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if err := l.Append(testNodes(count)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := func(path string) int {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+
+	run(3)
+	run(2)
+	if got := lines(path); got != 5 {
+		t.Errorf("%d lines after two runs, want 5", got)
+	}
+
+	// rotated on open, the older ones shifted and the ones over LOG_MAX_FILES removed
+	cfg.NodesLogMaxSizeMB = 1
+	if err := os.WriteFile(path, make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".1", []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".2", []byte("oldest\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run(1)
+	if got := lines(path); got != 1 {
+		t.Errorf("%d lines after the rotation, want 1", got)
+	}
+	if info, err := os.Stat(path + ".1"); err != nil || info.Size() != 1<<20 {
+		t.Errorf("rotated log: %v", err)
+	}
+	if data, err := os.ReadFile(path + ".2"); err != nil || string(data) != "previous\n" {
+		t.Errorf("shifted log %q: %v", data, err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("log over LOG_MAX_FILES kept: %v", err)
+	}
+	// under the size it is appended
+	run(1)
+	if got := lines(path); got != 2 {
+		t.Errorf("%d lines under the max size, want 2", got)
+	}
+}
+
+// the saver every second at 100k good nodes: the whole nodes file rewritten
+// against the nodes log appended with the new ones of the second
+func BenchmarkGoodNodesTick(b *testing.B) {
+	const good, perTick = 100_000, 100
+	nodes := testNodes(good)
+	b.Run("rewrite", func(b *testing.B) {
+		dir := setDataDir(b)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := Save(nodes, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		info, err := os.Stat(filepath.Join(dir, cfg.NodesFilename))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(info.Size()), "B/tick")
+	})
+	b.Run("append", func(b *testing.B) {
+		dir := setDataDir(b)
+		l, err := NewNodesLog(nopLogger{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer l.Close()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			start := i * perTick % good
+			if err := l.Append(nodes[start : start+perTick]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		info, err := os.Stat(filepath.Join(dir, cfg.NodesLogFilename))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(info.Size())/float64(b.N), "B/tick")
+	})
+}
+
+// the files not written are not listed, the summary is written with the list
+func TestOutputFiles(t *testing.T) {
+	dir := setDataDir(t)
+	defer func(cache bool, history, stream string) {
+		cfg.NodesCache, cfg.HistoryFilename, cfg.StreamFilename = cache, history, stream
+	}(cfg.NodesCache, cfg.HistoryFilename, cfg.StreamFilename)
+	cfg.NodesCache, cfg.HistoryFilename, cfg.StreamFilename = true, "", "stream.jsonl"
+	if err := os.WriteFile(filepath.Join(dir, cfg.NodesFilename), []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, cfg.SummaryFilename+".json"),
+		filepath.Join(dir, cfg.SummaryFilename+".txt"),
+		filepath.Join(dir, cfg.NodesFilename),
+	}
+	if got := OutputFiles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OutputFiles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+// web dashboard with the same data as the gui,
+// pushed to the browser with server sent events
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+)
+
+var cfg = config.Shared()
+
+//go:embed index.html
+var indexHTML []byte
+
+// events buffered per browser, slow browsers miss the updates
+const subscriberBuffer = 64
+
+// chart point, same series as the gui charts
+type point struct {
+	Total  int   `json:"total"`
+	Queued int   `json:"queued"`
+	Good   int   `json:"good"`
+	Dead   int32 `json:"dead"`
+	Conn   int   `json:"conn"`
+}
+
+type logLine struct {
+	Level logger.Level `json:"level"`
+	Line  string       `json:"line"`
+	Peer  bool         `json:"peer"`
+}
+
+type event struct {
+	Type  string       `json:"type"`
+	Stats *stats.Stats `json:"stats,omitempty"`
+	Point *point       `json:"point,omitempty"`
+	Log   *logLine     `json:"log,omitempty"`
+	// snapshot for the new browsers
+	History []point   `json:"history,omitempty"`
+	Logs    []logLine `json:"logs,omitempty"`
+	Limit   int       `json:"limit,omitempty"`
+	Points  int       `json:"points,omitempty"`
+	Lines   int       `json:"lines,omitempty"`
+}
+
+// Server is a stats.Sink and a logger.Sink, runs alongside the gui
+type Server struct {
+	ctx   context.Context
+	token string
+
+	mu      sync.Mutex
+	last    *stats.Stats
+	history []point
+	logs    []logLine
+	subs    map[chan []byte]struct{}
+}
+
+// token is optional, empty to serve without auth
+func New(ctx context.Context, token string) *Server {
+	return &Server{
+		ctx:   ctx,
+		token: token,
+		subs:  make(map[chan []byte]struct{}),
+	}
+}
+
+// ListenAndServe blocks until the context is done
+func (s *Server) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.auth(s.handleIndex))
+	mux.HandleFunc("/events", s.auth(s.handleEvents))
+	mux.HandleFunc("/stats", s.auth(s.handleStats))
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Push implements stats.Sink, never blocks
+func (s *Server) Push(st stats.Stats) {
+	p := point{
+		Total:  st.NodesTotal,
+		Queued: st.NodesQueued,
+		Good:   st.NodesGood,
+		Dead:   st.NodesDead,
+		Conn:   st.Connections,
+	}
+	s.mu.Lock()
+	s.last = &st
+	s.history = append(s.history, p)
+	if len(s.history) > cfg.ChartHistory {
+		s.history = s.history[len(s.history)-cfg.ChartHistory:]
+	}
+	s.mu.Unlock()
+	s.broadcast(event{Type: "stats", Stats: &st, Point: &p})
+}
+
+// PushLog implements logger.Sink, never blocks
+func (s *Server) PushLog(level logger.Level, line string, peer bool) {
+	l := logLine{Level: level, Line: line, Peer: peer}
+	s.mu.Lock()
+	s.logs = append(s.logs, l)
+	if len(s.logs) > cfg.LogLines {
+		s.logs = s.logs[len(s.logs)-cfg.LogLines:]
+	}
+	s.mu.Unlock()
+	s.broadcast(event{Type: "log", Log: &l})
+}
+
+func (s *Server) broadcast(e event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+func (s *Server) subscribe() (chan []byte, []byte) {
+	ch := make(chan []byte, subscriberBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[ch] = struct{}{}
+	snapshot, _ := json.Marshal(event{
+		Type:    "snapshot",
+		Stats:   s.last,
+		History: s.history,
+		Logs:    s.logs,
+		Limit:   cfg.ConnectionsLimit,
+		Points:  cfg.ChartHistory,
+		Lines:   cfg.LogLines,
+	})
+	return ch, snapshot
+}
+
+func (s *Server) unsubscribe(ch chan []byte) {
+	s.mu.Lock()
+	delete(s.subs, ch)
+	s.mu.Unlock()
+}
+
+// bearer token in the header or ?token= for the browser and EventSource
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				token = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(indexHTML)
+}
+
+// last stats as json with the active connections, null before the first update
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(last)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch, snapshot := s.subscribe()
+	defer s.unsubscribe(ch)
+	fmt.Fprintf(w, "data: %s\n\n", snapshot)
+	flusher.Flush()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-r.Context().Done():
+			return
+		case data := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// webhook posts crawl milestones as json to a configured url,
+// delivery is best effort with one retry
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-btc-xray/internal/config"
+	"github.com/1F47E/go-btc-xray/internal/logger"
+	"github.com/1F47E/go-btc-xray/internal/stats"
+)
+
+var cfg = config.Shared()
+
+const (
+	EventGoodNodes      = "good_nodes"
+	EventCycleCompleted = "cycle_completed"
+	EventCrawlCompleted = "crawl_completed"
+)
+
+const retryDelay = time.Second
+
+type Event struct {
+	Event string    `json:"event"`
+	At    time.Time `json:"at"`
+	// crossed good nodes threshold for good_nodes
+	Threshold int `json:"threshold,omitempty"`
+	// why the crawl stopped for crawl_completed
+	Reason string      `json:"reason,omitempty"`
+	Stats  stats.Stats `json:"stats"`
+}
+
+// Notifier is a stats.Sink, thresholds are checked on every stats update
+type Notifier struct {
+	log    logger.Interface
+	url    string
+	client *http.Client
+
+	mu   sync.Mutex
+	last stats.Stats
+	// thresholds already posted in the current crawl
+	crossed map[int]bool
+	wg      sync.WaitGroup
+}
+
+func New(log logger.Interface, url string) *Notifier {
+	return &Notifier{
+		log:     log.With(logger.FieldModule, "webhook"),
+		url:     url,
+		client:  &http.Client{Timeout: cfg.WebhookTimeout},
+		crossed: make(map[int]bool),
+	}
+}
+
+// Push implements stats.Sink, posting is done in the background
+func (n *Notifier) Push(st stats.Stats) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// new crawl cycle in the daemon mode
+	if !n.last.Started.IsZero() && !st.Started.Equal(n.last.Started) {
+		n.post(Event{Event: EventCycleCompleted, Stats: n.last})
+		n.crossed = make(map[int]bool)
+	}
+	n.last = st
+	for _, t := range cfg.WebhookGood {
+		if st.NodesGood >= t && !n.crossed[t] {
+			n.crossed[t] = true
+			n.post(Event{Event: EventGoodNodes, Threshold: t, Stats: st})
+		}
+	}
+}
+
+// Finish posts the crawl completion with the last stats and waits for all the posts
+func (n *Notifier) Finish(reason string) {
+	n.mu.Lock()
+	n.post(Event{Event: EventCrawlCompleted, Reason: reason, Stats: n.last})
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+func (n *Notifier) post(e Event) {
+	e.At = time.Now()
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		err := n.send(e)
+		if err != nil {
+			n.log.Warnf("%s failed, retrying: %v", e.Event, err)
+			time.Sleep(retryDelay)
+			err = n.send(e)
+		}
+		if err != nil {
+			n.log.Errorf("%s not delivered: %v", e.Event, err)
+			return
+		}
+		n.log.Debugf("%s delivered", e.Event)
+	}()
+}
+
+func (n *Notifier) send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -10,89 +10,452 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/1F47E/go-btc-xray/internal/client"
+	"github.com/1F47E/go-btc-xray/internal/client/node"
 	"github.com/1F47E/go-btc-xray/internal/config"
-	"github.com/1F47E/go-btc-xray/internal/dns"
 	"github.com/1F47E/go-btc-xray/internal/gui"
 	"github.com/1F47E/go-btc-xray/internal/logger"
 	"github.com/1F47E/go-btc-xray/internal/printer"
+	"github.com/1F47E/go-btc-xray/internal/probe"
+	"github.com/1F47E/go-btc-xray/internal/stats"
 	"github.com/1F47E/go-btc-xray/internal/storage"
+	"github.com/1F47E/go-btc-xray/internal/web"
+	"github.com/1F47E/go-btc-xray/internal/webhook"
 )
 
 func main() {
-	printer.Banner()
+	// fail fast on bad config
+	config.Init(os.Args[1:])
+	cfg, err := config.New()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	var err error
-	cfg := config.New()
+	// subcommands after the flags, like xray --conn 10 probe host:port
+	if args := config.Args(); len(args) > 0 {
+		switch args[0] {
+		case "probe":
+			os.Exit(probeCmd(args[1:]))
+		case "diff":
+			os.Exit(diffCmd(args[1:]))
+		case "convert":
+			os.Exit(convertCmd(args[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+			os.Exit(2)
+		}
+	}
 
-	guiCh := make(chan gui.IncomingData, 42)
-	log := logger.New(guiCh)
+	printer.Banner()
 
-	// create temp folders
-	err = storage.Bootstrap()
-	if err != nil {
-		log.Fatalf("failed to bootstrap the storage: %v", err)
+	// create the data and logs dirs before the logger opens its file
+	if err := storage.Bootstrap(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to bootstrap the storage: %v\n", err)
+		os.Exit(1)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// TUI
+	// gui and web are both the logs and the stats sinks, interfaces stay nil without them
 	var ui *gui.GUI
+	var logSinks []logger.Sink
+	var statsSinks []stats.Sink
 	if cfg.Gui {
-		ui = gui.New(ctx, guiCh)
-		go ui.Start()
+		ui = gui.New(ctx)
+		logSinks = append(logSinks, ui)
+		statsSinks = append(statsSinks, ui)
+	}
+	// WEB
+	var dashboard *web.Server
+	if cfg.HTTPAddr != "" {
+		dashboard = web.New(ctx, cfg.HTTPToken)
+		logSinks = append(logSinks, dashboard)
+		statsSinks = append(statsSinks, dashboard)
+	}
+	log := logger.New(logger.MultiSink(logSinks...))
+	if ui != nil {
+		ui.SetLogger(log)
+	}
+	// WEBHOOK
+	var notifier *webhook.Notifier
+	if cfg.WebhookURL != "" {
+		notifier = webhook.New(log, cfg.WebhookURL)
+		statsSinks = append(statsSinks, notifier)
+	}
+	statsSink := stats.Multi(statsSinks...)
+	if dashboard != nil {
+		go func() {
+			log.Infof("web dashboard on %s", cfg.HTTPAddr)
+			if err := dashboard.ListenAndServe(cfg.HTTPAddr); err != nil {
+				log.Errorf("web dashboard failed: %v", err)
+			}
+		}()
+	}
+
+	// GRACEFUL SHUTDOWN
+	// first reason wins, the rest are dropped
+	exitCh := make(chan string, 1)
+	// set before the ctx is canceled, empty if canceled by other means
+	var exitReason string
+	shutdown := func(reason string) {
+		select {
+		case exitCh <- reason:
+		default:
+		}
+	}
+
+	if ui != nil {
+		// quit from the gui stops the crawler
+		go ui.Start(func() { shutdown("quit from gui") })
+	}
+
+	// DAEMON
+	// crawl in cycles, every cycle has its own client
+	daemonDone := make(chan struct{})
+	if cfg.Daemon && os.Getenv("DRY_RUN") != "1" {
+		go func() {
+			client.RunDaemon(ctx, log, statsSink)
+			close(daemonDone)
+		}()
+	} else {
+		close(daemonDone)
 	}
 
 	// RPC CLIENT
-	c := client.NewClient(ctx, log, guiCh)
+	c := client.NewClient(ctx, log, statsSink)
 
-	if os.Getenv("DRY_RUN") != "1" {
+	if !cfg.Daemon && os.Getenv("DRY_RUN") != "1" {
 		// DNS SCAN
 		// scan seed nodes, add them to the client
 		go func() {
-			d := dns.New(log)
-			addrs := d.Scan()
-			if len(addrs) == 0 {
-				log.Fatalf("no seed nodes found")
+			// the saved nodes are enough to start like in the daemon, the seeds are still
+			// scanned and added to them but an empty scan is not fatal
+			addrs := client.SavedNodes(log)
+			var err error
+			if len(addrs) > 0 {
+				addrs = append(addrs, client.SeedNodes(log)...)
+			} else {
+				addrs, err = client.Bootstrap(ctx, log)
 			}
-			c.AddNodes(addrs)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Fatalf("%v", err)
+			}
+			c.AddSeeds(addrs)
 			// start the client after seed nodes are added
 			go c.Start()
 		}()
 	}
 
 	// PROFILING
-	if os.Getenv("PPROF") == "1" {
+	// pprof on /debug/pprof, goroutines by role and other counters on /debug/vars
+	if cfg.DebugAddr != "" {
 		go func() {
-			_ = http.ListenAndServe("localhost:6060", nil)
+			log.Infof("debug server on %s", cfg.DebugAddr)
+			if err := http.ListenAndServe(cfg.DebugAddr, nil); err != nil {
+				log.Errorf("debug server failed: %v", err)
+			}
+		}()
+	}
+
+	// RELOAD
+	// SIGHUP reads the config file, the seed, ban and whitelist files again, only some fields change without a restart
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		current := *cfg
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload(log, &current, c)
+				// daemon cycles read the files again by themselves
+				if !current.Daemon {
+					c.ReloadSeedFile()
+					c.ReloadBanFile()
+					c.ReloadWhitelist()
+				}
+			}
+		}
+	}()
+
+	// MONITOR REFRESH
+	// SIGUSR1 resumes the paused crawl
+	if cfg.Monitor > 0 {
+		go func() {
+			usr := make(chan os.Signal, 1)
+			signal.Notify(usr, syscall.SIGUSR1)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-usr:
+					c.Refresh()
+				}
+			}
 		}()
 	}
 
-	// GRACEFUL SHUTDOWN
 	go func() {
 		stop := make(chan os.Signal, 1)
 		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 		<-stop
-		log.Debug("received exit signal, canceling ctx")
-		cancel()
+		shutdown("received exit signal")
+		// do not wait for a key in the gui
+		if ui != nil {
+			ui.Stop()
+		}
+	}()
+	// DEADLINE
+	if cfg.MaxDuration > 0 {
+		timer := time.AfterFunc(cfg.MaxDuration, func() {
+			shutdown(fmt.Sprintf("max duration %s reached", cfg.MaxDuration))
+		})
+		defer timer.Stop()
+	}
+	// client stopped by itself, e.g. the queue is drained
+	go func() {
+		<-c.Done()
+		if reason := c.ExitReason(); reason != "" {
+			shutdown(reason)
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case reason := <-exitCh:
+			log.Infof("%s, canceling ctx", reason)
+			exitReason = reason
+			cancel()
+		}
 	}()
 
 	log.Debug("waiting for the context to be canceled")
 	// blocking, waiting for all the goroutines to exit
 	<-ctx.Done()
 	log.Debug("context canceled, exiting")
-	log.ResetToStdout()
-	// exit from GUI
-	if ui != nil {
-		go ui.Stop()
-	}
 	// RPC disconnect from all the nodes
+	// results are saved while the gui shows the final state
 	c.Disconnect()
+	c.SaveNodes()
+	summary := c.SaveSummary()
+	// daemon saves the results of the last cycle by itself
+	<-daemonDone
+	if notifier != nil {
+		notifier.Finish(exitReason)
+	}
+	// exit from GUI, waits for a key if the crawler finished by itself
+	if ui != nil {
+		<-ui.Done()
+	}
+	log.ResetToStdout()
+	// after the gui so it stays on the terminal
+	if summary != nil {
+		fmt.Println()
+		summary.WriteText(os.Stdout)
+	}
+}
+
+// apply the live fields of the reloaded config to the logger and the client,
+// current keeps the applied values to report only the new changes next time
+func reload(log *logger.Logger, current *config.Config, c *client.Client) {
+	next, err := config.Reload()
+	if err != nil {
+		log.Errorf("reload failed, config not changed: %v", err)
+		return
+	}
+	live, ignored := current.Diff(next)
+	if len(live) == 0 && len(ignored) == 0 {
+		log.Info("reload: nothing changed")
+		return
+	}
+	if err := log.SetLevels(next.LogLevel, next.LogLevels); err != nil {
+		log.Errorf("reload: %v", err)
+	} else {
+		current.LogLevel, current.LogLevels = next.LogLevel, next.LogLevels
+	}
+	// daemon cycles have their own clients with the startup values
+	if !current.Daemon {
+		c.SetConnectionsLimit(next.ConnectionsLimit)
+		c.SetSaveInterval(next.SaveInterval)
+		current.ConnectionsLimit, current.SaveInterval = next.ConnectionsLimit, next.SaveInterval
+	}
+	for _, change := range live {
+		if current.Daemon && !strings.HasPrefix(change, "LogLevel") {
+			ignored = append(ignored, change)
+			continue
+		}
+		log.Infof("reload: %s", change)
+	}
+	for _, change := range ignored {
+		log.Warnf("reload: %s ignored, needs a restart", change)
+	}
+}
+
+// probe a single node and exit, non-zero exit code if the handshake failed
+// usage: xray probe [--json] host:port
+func probeCmd(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result as a single JSON object")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s probe [--json] host:port\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := storage.Bootstrap(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to bootstrap the storage: %v\n", err)
+		return 1
+	}
+
+	// logs go to stderr to keep stdout clean for the result
+	log := logger.New(nil)
+	log.SetOutput(os.Stderr)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	res, err := probe.Run(ctx, log, fs.Arg(0))
+	if *asJSON {
+		if e := res.PrintJSON(os.Stdout); e != nil {
+			log.Errorf("failed to encode result: %v", e)
+		}
+	} else {
+		res.Print(os.Stdout)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// convert a crawl file to another format with the filters
+// usage: xray convert --to csv [--from jsonl] [filters] in [out]
+func convertCmd(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "", "input format json, jsonl, csv or txt, detected by the content if empty")
+	to := fs.String("to", "", "output format json, jsonl, csv or txt")
+	minLatency := fs.Duration("min-latency", 0, "only the nodes with the ping at least this")
+	maxLatency := fs.Duration("max-latency", 0, "only the nodes with the ping at most this")
+	services := fs.String("services", "", "only the nodes with all these service bits, like 1033 or 0x409")
+	network := fs.String("network", "", "only the nodes of ipv4, ipv6 or onion (tor)")
+	top := fs.Int("top", 0, "only this many fastest nodes by the ping")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s convert --to format [--from format] [filters] in [out]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 || *to == "" {
+		fs.Usage()
+		return 2
+	}
+	var filter storage.Filter
+	outFormat, err := storage.ParseFormat(*to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var inFormat storage.Format
+	if *from != "" {
+		if inFormat, err = storage.ParseFormat(*from); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *services != "" {
+		if filter.Services, err = strconv.ParseUint(*services, 0, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "bad services %q: %v\n", *services, err)
+			return 2
+		}
+	}
+	switch *network {
+	case "":
+	case "ipv4", "ipv6", "onion":
+		filter.Network = node.AddrType(*network)
+	case "tor":
+		filter.Network = node.AddrOnion
+	default:
+		fmt.Fprintf(os.Stderr, "unknown network %q, one of ipv4, ipv6 or onion\n", *network)
+		return 2
+	}
+	filter.MinLatency, filter.MaxLatency, filter.Top = *minLatency, *maxLatency, *top
+
+	// the input is read in full before the output is written, they may be the same file
+	var out bytes.Buffer
+	cnt, err := storage.Convert(fs.Arg(0), inFormat, outFormat, filter, &out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if fs.NArg() == 2 {
+		err = os.WriteFile(fs.Arg(1), out.Bytes(), config.Shared().FileMode)
+	} else {
+		_, err = os.Stdout.Write(out.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d nodes\n", cnt)
+	return 0
+}
+
+// compare two crawl files, the churn between two crawls
+// usage: xray diff [--json] [--out dir] old.json new.json
+func diffCmd(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	out := fs.String("out", "", "write added.json, removed.json and changed.json to this dir")
+	asJSON := fs.Bool("json", false, "print the result as a single JSON object")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s diff [--json] [--out dir] old.json new.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	res, err := storage.Diff(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode result: %v\n", err)
+			return 1
+		}
+	} else {
+		res.WriteText(os.Stdout)
+	}
+	if *out != "" {
+		if err := storage.SaveDiff(*out, res); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
 }